@@ -0,0 +1,328 @@
+package lazy
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	assetsManifestFile = "manifest.json"
+	signingKeysDir     = "contrib/keys"
+)
+
+// pgpHashes are accepted hashes of signatures, sha1 is not collision
+// resistant any more.
+var pgpHashes = map[crypto.Hash]bool{
+	crypto.SHA224: true,
+	crypto.SHA256: true,
+	crypto.SHA384: true,
+	crypto.SHA512: true,
+}
+
+// signingKeys are armored public keys of os images added into
+// contrib/keys before build, the key of os is named <os>.asc. Key of
+// assets session is needed for os without embedded key.
+//
+//go:embed contrib/keys
+var signingKeys embed.FS
+
+// signingKeyFingerprints pin primary keys of embedded keys, a wrong key
+// added into contrib/keys is refused. Fedora CoreOS is signed by the key
+// of each Fedora release, so it is not pinned.
+var signingKeyFingerprints = map[string]string{
+	coreosOS:  "04127D0BFABEC8871FFB2CCE50E0885593D2DCB4",
+	flatcarOS: "F88CFEDEFF29A5B4D9523864E25D9AED0593B34A",
+}
+
+type AssetsConfig struct {
+	Dir string `ini:"dir"`
+	// Mirror replaces download url of os, files are under <version>/
+	Mirror string `ini:"mirror"`
+	// Key is armored public key file of os vendor, it is required unless
+	// key of os is embedded
+	Key string `ini:"key"`
+}
+
+type assetsManifest struct {
+	OS      string        `json:"os"`
+	Channel string        `json:"channel"`
+	Version string        `json:"version"`
+	Files   []assetRecord `json:"files"`
+}
+
+type assetRecord struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	// Key is fingerprint of primary key whose key signed file
+	Key string `json:"key"`
+}
+
+// pgpDearmor returns binary of armored data, binary data is returned as
+// it is.
+func pgpDearmor(data []byte, blockType string) (io.Reader, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN ")) {
+		return bytes.NewReader(data), nil
+	}
+
+	block, err := armor.Decode(bytes.NewReader(bytes.TrimSpace(data)))
+	if err != nil {
+		return nil, err
+	}
+	if block.Type != blockType {
+		return nil, errors.New("OpenPGP armor should be " + blockType + ", got " + block.Type)
+	}
+	return block.Body, nil
+}
+
+// parsePGPKeyring reads armored or binary public keys. Subkeys are only
+// kept when their binding signatures are valid.
+func parsePGPKeyring(data []byte) (openpgp.EntityList, error) {
+	r, err := pgpDearmor(data, openpgp.PublicKeyType)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := openpgp.ReadKeyRing(r)
+	if err == nil && len(keys) == 0 {
+		err = errors.New("OpenPGP keyring has no key")
+	}
+	return keys, err
+}
+
+func pgpFingerprint(e *openpgp.Entity) string {
+	return strings.ToUpper(hex.EncodeToString(e.PrimaryKey.Fingerprint))
+}
+
+// verifyPGPSignature checks detached signature of r by keys, it returns
+// the key which made signature. Signing key must be valid, which is not
+// expired or revoked and is allowed to sign.
+func verifyPGPSignature(keys openpgp.EntityList, r io.Reader, signature []byte) (*openpgp.Entity, error) {
+	sr, err := pgpDearmor(signature, openpgp.SignatureType)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, signer, err := openpgp.VerifyDetachedSignature(keys, r, sr, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !pgpHashes[sig.Hash] {
+		return nil, fmt.Errorf("OpenPGP signature hash %v is not accepted", sig.Hash)
+	}
+	return signer, nil
+}
+
+// embeddedKeyring returns embedded key of os.
+func embeddedKeyring(osName string) (openpgp.EntityList, error) {
+	data, err := signingKeys.ReadFile(signingKeysDir + "/" + osName + ".asc")
+	if err != nil {
+		return nil, errors.New("Signing key of " + osName + " is not embedded, set key of assets session to vendor key")
+	}
+
+	keys, err := parsePGPKeyring(data)
+	if err != nil {
+		return nil, fmt.Errorf("Embedded key of %s is broken: %v", osName, err)
+	}
+	if err = checkSigningKey(osName, keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// checkSigningKey refuses keys of os whose fingerprint is not pinned one.
+func checkSigningKey(osName string, keys openpgp.EntityList) error {
+	fp, ok := signingKeyFingerprints[osName]
+	if !ok {
+		return nil
+	}
+	for _, k := range keys {
+		if pgpFingerprint(k) != fp {
+			return fmt.Errorf("Embedded key of %s should be %s, got %s", osName, fp, pgpFingerprint(k))
+		}
+	}
+	return nil
+}
+
+func (c *Config) assetsKeyring() (openpgp.EntityList, error) {
+	if len(c.A.Key) == 0 {
+		return embeddedKeyring(c.OS)
+	}
+
+	data, err := ioutil.ReadFile(c.A.Key)
+	if err != nil {
+		return nil, err
+	}
+	return parsePGPKeyring(data)
+}
+
+// FetchAssets downloads assets of os into assets dir, every file must be
+// verified by its signature. Checksums are recorded in manifest.json.
+func (c *Config) FetchAssets() error {
+	keys, err := c.assetsKeyring()
+	if err != nil {
+		return err
+	}
+
+	a := c.os.assets(c)
+	base := a.BaseURL
+	if len(c.A.Mirror) != 0 {
+		base = strings.TrimRight(c.A.Mirror, "/") + "/" + c.Version
+	}
+
+	dir := filepath.Join(c.A.Dir, filepath.FromSlash(a.Dir))
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	m := assetsManifest{
+		OS:      c.OS,
+		Channel: c.Channel,
+		Version: c.Version,
+		Files:   make([]assetRecord, 0, len(a.Files)),
+	}
+	for _, name := range a.Files {
+		r, err := fetchAsset(keys, base+"/"+name, filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		r.Name = name
+		m.Files = append(m.Files, *r)
+	}
+
+	bs, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, assetsManifestFile), append(bs, '\n'), 0644)
+}
+
+// fetchAsset downloads file and its signature when they are missing, file
+// is removed when it is not signed by keys.
+func fetchAsset(keys openpgp.EntityList, url, file string) (*assetRecord, error) {
+	sigFile := file + ".sig"
+	for _, d := range [][2]string{{url, file}, {url + ".sig", sigFile}} {
+		if _, err := os.Stat(d[1]); err == nil {
+			continue
+		}
+
+		log.Println("Download", d[0])
+		if err := downloadFile(d[0], d[1]); err != nil {
+			return nil, err
+		}
+	}
+
+	sig, err := ioutil.ReadFile(sigFile)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n := &countWriter{}
+	k, err := verifyPGPSignature(keys, io.TeeReader(f, io.MultiWriter(h, n)), sig)
+	if err != nil {
+		f.Close()
+		os.Remove(file)
+		os.Remove(sigFile)
+		return nil, fmt.Errorf("Verify signature of %s failed: %v", file, err)
+	}
+
+	return &assetRecord{
+		URL:    url,
+		Size:   n.n,
+		SHA256: hex.EncodeToString(h.Sum(nil)),
+		Key:    pgpFingerprint(k),
+	}, nil
+}
+
+type countWriter struct {
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// downloadFile downloads url into file through file.part, the partial
+// file of interrupted download is resumed by range request.
+func downloadFile(url, file string) error {
+	part := file + ".part"
+	f, err := os.OpenFile(part, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)) {
+			return errors.New("Content range of " + url + " is not correct: " + resp.Header.Get("Content-Range"))
+		}
+	case http.StatusOK:
+		// Server ignores range, download from beginning
+		if err = f.Truncate(0); err != nil {
+			return err
+		}
+		if _, err = f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// Partial file has been completed
+		if offset == 0 {
+			return errors.New("Download " + url + " failed: " + resp.Status)
+		}
+		f.Close()
+		return os.Rename(part, file)
+	default:
+		return errors.New("Download " + url + " failed: " + resp.Status)
+	}
+
+	if _, err = io.Copy(f, resp.Body); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(part, file)
+}
@@ -0,0 +1,427 @@
+package lazy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testPGPAsset = "lazykube test asset\n"
+
+// testPGPKey signed testPGPSignature of testPGPAsset by gpg
+const testPGPKey = `
+-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+mQENBGrWScYBCAC0Kzk6tEB6EjwG9BtAV7DhntMFSOp0I4XNaKqMapegpZWtB8oE
+PThkHIsQJl3H8MWDtiEP/PKRXohCVhLx1AGsDOaALASWMGiBJEOskKc2Z43/9yQN
+MwAGsGR9y8VM+7gARAn4671eX81XNeFcA5EHq4u3D2Vy/FsW5kKR92kO6Jt22weN
+7VMwK0OYxEFCYMZ19431XMve6Z9EzHoroPCwYT6e/qb9G97UA81Iq5A0JWvdueua
+21NfhRdiuKYAmJTDyjkNdaMxxVyaZrajHfC6BgtTAC+3+bl4UcUL4xIONMqrMhIv
+/BoO90ATbThl9TtQBthmFvcS6bx0Khv5QV+FABEBAAG0HUxhenlLdWJlIFRlc3Qg
+PHRlc3RAbGF6eWt1YmU+iQFOBBMBCgA4FiEEPHg88klRQO0IlJbKe7B8tmsNHvAF
+AmrWScYCGwMFCwkIBwIGFQoJCAsCBBYCAwECHgECF4AACgkQe7B8tmsNHvBqzwf/
+Vx0Y6uy86nMQi8xDHPva6LlAwtvXuVSiIEphXuhGDTKYn6L9DKYbcZujSegnAMKW
+6mzl4L0sNfEnJXCXOn6mwEduO5NDy/FkhCRxhxIPCZcJmmPIccR++qyJmkrSavOh
+FtjGccyWzuOeiXVf/RN1zErhQGd9t6008u408bHZkOiJATL2oiN3fWDwzP7wja+O
+K73zj42Njfuyty3OztUHhLozvs4TzETVGy9J7nWivNg2QSnw88wyxmSAvdLojK1e
+lYWoC243RGKGyoYOA6Lo+/d2nhdfl5R7okAoUjYll4ZdwgmtSQFJuvZnG59fp/e8
+hNqNf9iItmcRGsJGPiRpwg==
+=NFR7
+-----END PGP PUBLIC KEY BLOCK-----
+`
+
+const testPGPFingerprint = "3C783CF2495140ED089496CA7BB07CB66B0D1EF0"
+
+const testPGPSignature = `
+iQEzBAABCAAdFiEEPHg88klRQO0IlJbKe7B8tmsNHvAFAmrWScYACgkQe7B8tmsNHvCnYQf+NXqJ
+pQ5FUc0kgbS6L5uXW00eANA+B9HU4jLIlOok+mmOeotzCQfGjyZZHkws+8vxOlIPtoVi61E6EpiG
+LVivzhVDxKOdyiZ5DgG0H4jyha4W+ialeoVXtD7C7IG5WnH7oH0iSBPUFa+9c8hZHPHmpUXc+h2/
+bG9bqa1K9w8ikqt8Q8gITHwQpEBaZuea4Tb54knckYeamgUWEo/NqetHrQBH2WGvRYfzgFwHQgTX
+U9toM2gqkt0goOf0mjsz/oDFW+cY6MZPwEfNi+AcTMPJDZ4e/ZDlg7pvWCYrNrAZDRz1p+kKnVdI
+TPA58iLJHS8JDXQMhHIT9nUSknlZ/Wpj9w==
+`
+
+const testPGPOtherKey = `
+-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+mQENBGrWSckBCADOczXd2F7FDRd5JVDir7cVKORU1AiCy3P+MRSaiZQgGffyH9tp
+CY7ld4WkBm/cnmf+nneQnk4DA1Nm2viyEn7zS9WY/3m7ciJ2bxb87MD2EzqIiDcm
+EaMoi7SX+Oruq4J5Et7vReMEFYkKgHnQAqH9aGnvKQWnDA2R+VVHppq35yKwruf+
+6VgyKMBs/VKrjRgQvgpZqS4zlJmsTEsysM5buAQrR4ebDPiXGJvr+wsKuQYuWIGB
+inAt7lxuXpkMXOZWbJDoU9dofpuGgXZkyZPjWv0eqgbEWrva2AMMmSMOzNf8nPQS
+nKCUe/w6owHvFM/Fy5YD0Jzg3VYtt7R33NlrABEBAAG0Fk90aGVyIDxvdGhlckBs
+YXp5a3ViZT6JAU4EEwEKADgWIQRiTWVuWuGUBT87iAjkQXIQ/XQXvQUCatZJyQIb
+AwULCQgHAgYVCgkICwIEFgIDAQIeAQIXgAAKCRDkQXIQ/XQXvZ7EB/sGy1nBYkKA
+5lPzxMTmu7j8hUBS9NIvmJ+bWV0TcA5RZLCiytUyKCG/V5BEZI9PuF3NiPa4ovTN
+kly9bSB0PI9GlvwgFHa6ezHOzWXnenHeK4BeRmwJ0Ob8QDkG3Y09GIrRgqC/1jlE
+ls6LNSELl5+baysiU1+XJqJUPN1R75vPyC60huq1bjWWe7Gska8kLlmeq+tflf4Y
+KLoTfLGGmUYn+XCpiv4VzwuAf8mginmV23IcltrBBP9QfMuKVlMR3aGfaDe1RCmL
+3tLMaN1/fuw8PGf8YuHaszAL4xgtwmILNo62mbwpBhV4+NxQVfgHVZzu7pyGkpmE
+ZIossWG3Jw37
+=TBKJ
+-----END PGP PUBLIC KEY BLOCK-----
+`
+
+// testPGPSubkey has a certify only primary key and a signing subkey,
+// which signed testPGPSubkeySignature by sha256 and testPGPSHA1Signature
+// by sha1.
+const testPGPSubkey = `
+-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+mQENBGrWVyUBCADhw8fQmXZOU9AwCZIoZimpLceKTbX9uWxeWYR6bZSMS5SxRWMx
+OKgR9EuiNNaJq4qRwXCCH0fAp3AHkmddwEdXvFMnhW5aMBn6pT78P2fTSXNSWDhW
+2g4js2mx/amFurZbqj91hoXsP4JOui1me4fi73BmUndxUSHZFUIdC3pjD+5NznUM
++KDIe2Q5qpHCkFYTeRf5J2ofC6PcFihu+LQ4Htmtr5Sijz8v7zOnrhX1yCk5HjCz
+G8WiR6HiY0Z6CMTzwlqVriuAt9Rr3Sx9tf6w/Y48ObRvTExioA0rBShtr3Ar2RWJ
+MhMncFtpjOVvSS3/xW0usMjLiB0cjDkhf6NhABEBAAG0JkxhenlLdWJlIFN1Ymtl
+eSBUZXN0IDxzdWJrZXlAbGF6eWt1YmU+iQFOBBMBCgA4FiEE1rnRVBbJ4PuU0Noe
+g1oprTHCQXMFAmrWVyUCGwEFCwkIBwIGFQoJCAsCBBYCAwECHgECF4AACgkQg1op
+rTHCQXM37ggAzXe1wmLVR+yiGmtuefHBS9kWLLqfi60IL1GmpHt2vd28KvN1AR3p
+d9mSmFPh57T53sZMUvuVuqRH9+d6xrc8ev30iWkd6CMDnXnzzXisXhYlhGWMWL+2
+pZXSz3B9WYve22vTfeHydoXkYpZi1p1kja3CFu8wlSnZLh1VQ2crpH+oCv2Ya0Eg
+/W8tsA4DqSzk1fiXEtXJ1HIi1u/SgVO+CpjAYyujbNglUh75SkOGlFklnqrD7jlS
+IfHEuNqJQ2DRz9xaoriMn5MUviLtxtkttD7bugPSy2HtF9y3QlZ0ZF7cbc3/QXbN
+pdtEKipGZfVYAWfIR62A+hHFjLWBcSnfD7kBDQRq1lclAQgA741qUAW4FfJ3BTdH
+YuWp92J+5gRZygx29SLLTxM4a8Y+l5DD5nW4fZFqvVTLAGkJjBnnVpN/VT+BhkFF
+GeMhJfCudPSKEsFprrbX2hbuect1eeV+gGqB1+T13GJ9RUDLxJTmanhmxZTlnvSc
+hSD/yteLc9AHAhc/D7xGoaFVMuEatJPn2fLOWtnv/uGq3bDb6qxrqaxVWxLAKyfo
+5qYsRP+jMvo0dp/WL4VGQpZuZClWqdWR37EuzsdhCDFhu5jKHcSfCeR27egZcFjy
+HIc9j/rl7YGNEQQJYZUFuhGWKKPt/rZjdkNkuA/j9qhuC97+3LmHi4SIoId7479z
+RBqoxQARAQABiQJsBBgBCgAgFiEE1rnRVBbJ4PuU0Noeg1oprTHCQXMFAmrWVyUC
+GwIBQAkQg1oprTHCQXPAdCAEGQEKAB0WIQQlN7EEeByoXqnf6FdUgP6f5xqNJQUC
+atZXJQAKCRBUgP6f5xqNJRMiCAC0CWBci4e96fxim5TCk7E8n3agyRJbfYJIZOsc
+tyUT8eVYQ+J0qBA8D5gaWiVsvbgOSuhH/+5ipKsnhCirt/Eg8/54ymKlijYpaYnV
+F9nxG7TJbtk+Ac4V/RrXEc2AT62SL+GcHd7lIbAmMH6S5blMXuP3U88YvlzG2Ybc
+c5S/2UuAhlU6zT2mNDYklqkThOc4ntB2sN1BP0TO529gaInTPNdqCQjTu3HjSkmA
+7gncYSgNR+zwv1Bix+APmCv3CQ5avT+dp6ew519dPOfChxT3OSrtECI0+yIY4EQT
+o5QBYkT17RY4O/6hXyTE3zGYgHFq5qXVHeWIYiR7cb4pO0cZW1QIAOGQ2xen9Bs6
+N6x3PJxcMH884QordLL2/NQCWQqQ4kKMp85EPQrknAldw+uZPhXQMp/C2W9KBDVF
+yy7SfVFiv61+xvN93QLzjcKjTpYmwGq/pCKvZh+aF165gPBD12xXYU9y76vcI6lf
+VI2dB/ZCveGUtykjc5ujE+Gzj5Z9BzUYaJv9iJX/hyJEbsfLVDqxxksbcnksXTbP
+fYsskQbOWeDcnEtpbI/oJWVsycpXdsKycFvY6JE9MuXZ0gLJPaSgYfMVzlROicKn
+X+6xvJjcPO1Y7B1r4ggBLgGXEejqn1JKgyDqp56zMrz640bxB849M0k7vsC0e4/C
+5LtbvufBkQU=
+=yavT
+-----END PGP PUBLIC KEY BLOCK-----
+`
+
+const testPGPSubkeySignature = `
+iQEzBAABCAAdFiEEJTexBHgcqF6p3+hXVID+n+cajSUFAmrWVyUACgkQVID+n+cajSVwugf/bl5S
+940kQWMoZuhK/ZMH+JGhBcfh84vHKKG2MSEa6uNigWQqbTyfjSjYHfJqKWCvw3MQm4plYyV9n07G
+d7+XBFSKF7TSjhLPjLV+WRQvYNDU//KUK17oqvWUb/h24ZdbnsL6ONFBGCjkqiMbju6w8LEJ4ra+
+hEAJvPOhxXHL9xP30sKSvX7WKIAbsq4mWnaULPZ32euX9sHuF908oq3iA16waDEKfBBsnTlIhuJm
+mUy4ByJjkfJpmmty7aYxb5Rz0ivVP/NAtuGR026IgsliDqDp9YjIxr1WWjy/YIQs4fiPh757vXaf
+vh0U7wDJLf+VFeTq2Lncsv8FkhXszX5dvA==
+`
+
+const testPGPSHA1Signature = `
+iQEzBAABAgAdFiEEJTexBHgcqF6p3+hXVID+n+cajSUFAmrWVyUACgkQVID+n+cajSXn7ggAiHp1
+hlxpH6h43R0mDNNGjgLIxbOx1y62gZE2A32UsfYqq3I5D+k4hTZqqzxAyHUwpxy7b1Q9onjY1Tpy
+OJkmE7G/VjjmGso3U2of+3yxRkHe/dGx341b2OxkB7u4Wpfc0dxO70QxeFnbmK6haaUQP6ndKOQV
+iEUs4EJbP+qcfxSSQIEAC56HQZf6PSL7tLyrxY2mot01B1VQ+mEXsG7uM/c85O+WF7u3xGh/oAwe
+f4rVmcrl5kdmDrzAR+Zc3aaOXtoUyuNFRQv0EBcjg5mBp+DwoY/dqYbsPFJWCtwFjHsd4eQ51uCK
+9a9Mjtg0KCWeEmCKDddj9Tgl3WyQey4LQg==
+`
+
+// testPGPExpiredKey expired in 2021, it signed testPGPExpiredSignature
+// before it expired.
+const testPGPExpiredKey = `
+-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+mQENBF4L4QABCADk/cQcHvu/UgZvggn3QKaIiW7yzqjMklEgZqUK2bFkUqsuGxR1
+ORSpVcETJ/GueRiXM9Ccu9AqEIu1DkZWyP+dskfi1hyyVsgzk6euq/EZLNp5mYZO
+BKH87KKuhuSRxs7cLmlDjKvW7qSlvxpP68kfG1bOsqjdpYmKTBPmGJD8zXKYNUfY
+8+hZlVcyHQHl+1kC2pOtN6BoMDV0BTANSrtdlpr+TM3XhUpXPdOidwoB7wcT53V/
+szQ+UPelaIYUysasVDZHMulGstYOI5KsIknvzgAHExot79XsQkLUnn2XwSF/9RcP
+7Z//02Ntkb3lCF+5jLFvDz7EBePORt7a4ZsvABEBAAG0KExhenlLdWJlIEV4cGly
+ZWQgVGVzdCA8ZXhwaXJlZEBsYXp5a3ViZT6JAVQEEwEKAD4WIQRPg9qbiuOf6vya
+kMiWHWQ+FONFtgUCXgvhAAIbAwUJAeEzgAULCQgHAgYVCgkICwIEFgIDAQIeAQIX
+gAAKCRCWHWQ+FONFtvTlCACKrbJRzPLVq6lNnlJl6bnmi2vS0yIHR9nlrYRfMnxr
+knVhwuglv5g7UNjgOMaD8ri8m/BPcEYV9WD110CaW+55P5PQ/OiE9UDCOxY4sJ2O
+6pnPv++pYrveDyUrupS8GeC0C5BrHOJ8KspyNe0K+tGP4wdLPZ0clAdWvtqWYVf7
+uP8Szo3FCpnuqi9ULZEypsGURQxVKKSY7wSRQArP+zpFGNuWkzGC56B20yxAwu4n
+TDyPPpHf6KE8nMSDSxcVYovoqHF/pPTkOCer+wsC9+KQZh8H10sFk//UN10EBTcl
+K/M0m2lkDetUula3ae+CaV882GnpUp9fzx5KuQV8ca9l
+=yWGb
+-----END PGP PUBLIC KEY BLOCK-----
+`
+
+const testPGPExpiredSignature = `
+iQFFBAABCAAvFiEET4Pam4rjn+r8mpDIlh1kPhTjRbYFAl4NMoARHGV4cGlyZWRAbGF6eWt1YmUA
+CgkQlh1kPhTjRbb2BQgAlNwpRAIF54i5itGSnpRiZHw3VyYXGWxDeQcCInbDCNxby8PfQvQsFSrt
+FpL/h4eZt2GyLsR+eNZZrm/G0cZYaQ+KCpR5LK6Mz1V3S8Udh/sl+D3qbjPUh7h9EDLH+Irg537w
+w5zSQTtJprtJUejA10ynci7ctj5iFIdTwx1/q+v0dG51s2mjl5iBnNn8+ecW3E+CnZ3o08SbpJ+q
+O4TRBJMr6q0xEipYqo+3PbL/WMztcPM1LRLq1rivYbzNdefnWaL8DdlwBw0p5fRr7nM14BlijSTC
+tN7gfIxYet7OnWjSotyXylUkV/XESFhdN8IjtwquSzQWNmRtAIT85gJcQw==
+`
+
+func testPGPBytes(t *testing.T, s string) []byte {
+	bs, err := base64.StdEncoding.DecodeString(strings.Replace(s, "\n", "", -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bs
+}
+
+func testPGPSignatureBytes(t *testing.T) []byte {
+	return testPGPBytes(t, testPGPSignature)
+}
+
+type testAssetsServer struct {
+	*httptest.Server
+	sync.Mutex
+	ranges []string
+	files  map[string][]byte
+}
+
+func newTestAssetsServer(t *testing.T, files map[string][]byte) *testAssetsServer {
+	s := &testAssetsServer{files: files}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bs, ok := s.files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		s.Lock()
+		if rg := r.Header.Get("Range"); len(rg) != 0 {
+			s.ranges = append(s.ranges, path.Base(r.URL.Path)+" "+rg)
+		}
+		s.Unlock()
+		http.ServeContent(w, r, path.Base(r.URL.Path), time.Time{}, bytes.NewReader(bs))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func loadTestAssetsConfig(t *testing.T, mirror string) *Config {
+	dir := t.TempDir()
+	key := filepath.Join(dir, "key.asc")
+	if err := ioutil.WriteFile(key, []byte(testPGPKey), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return loadTestConfig(t, "os=coreos\nchannel=stable\nversion=1.2.3\n"+testINIConfig+
+		"\n[assets]\ndir="+filepath.Join(dir, "assets")+"\nmirror="+mirror+"\nkey="+key+"\n")
+}
+
+func TestFetchAssets(t *testing.T) {
+	sig := testPGPSignatureBytes(t)
+	files := make(map[string][]byte)
+	for _, name := range []string{"coreos_production_pxe.vmlinuz",
+		"coreos_production_pxe_image.cpio.gz", "coreos_production_image.bin.bz2"} {
+		files["/coreos/1.2.3/"+name] = []byte(testPGPAsset)
+		files["/coreos/1.2.3/"+name+".sig"] = sig
+	}
+	s := newTestAssetsServer(t, files)
+
+	c := loadTestAssetsConfig(t, s.URL+"/coreos/")
+	dir := filepath.Join(c.A.Dir, "coreos", "1.2.3")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Interrupted download is resumed from the partial file
+	part := filepath.Join(dir, "coreos_production_pxe.vmlinuz.part")
+	if err := ioutil.WriteFile(part, []byte(testPGPAsset[:8]), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.FetchAssets(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s.ranges) != 1 || s.ranges[0] != "coreos_production_pxe.vmlinuz bytes=8-" {
+		t.Fatalf("Only partial file should be resumed, got %v", s.ranges)
+	}
+
+	bs, err := ioutil.ReadFile(filepath.Join(dir, "coreos_production_pxe.vmlinuz"))
+	if err != nil || string(bs) != testPGPAsset {
+		t.Fatalf("Resumed file is not correct: %q %v", bs, err)
+	}
+	if _, err = os.Stat(part); !os.IsNotExist(err) {
+		t.Fatal("Partial file should be renamed")
+	}
+
+	bs, err = ioutil.ReadFile(filepath.Join(dir, assetsManifestFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := assetsManifest{}
+	if err = json.Unmarshal(bs, &m); err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256([]byte(testPGPAsset))
+	if m.Version != "1.2.3" || len(m.Files) != 3 {
+		t.Fatalf("Manifest is not correct: %s", bs)
+	}
+	for _, f := range m.Files {
+		if f.SHA256 != hex.EncodeToString(sum[:]) || f.Size != int64(len(testPGPAsset)) ||
+			f.Key != testPGPFingerprint || !strings.HasPrefix(f.URL, s.URL+"/coreos/1.2.3/") {
+			t.Fatalf("Manifest record of %s is not correct: %v", f.Name, f)
+		}
+	}
+}
+
+func TestFetchAssetsBadSignature(t *testing.T) {
+	sig := testPGPSignatureBytes(t)
+	files := map[string][]byte{
+		"/1.2.3/coreos_production_pxe.vmlinuz":     []byte("tampered asset\n"),
+		"/1.2.3/coreos_production_pxe.vmlinuz.sig": sig,
+	}
+	s := newTestAssetsServer(t, files)
+
+	c := loadTestAssetsConfig(t, s.URL)
+	if err := c.FetchAssets(); err == nil {
+		t.Fatal("Asset with bad signature should not be fetched")
+	}
+
+	file := filepath.Join(c.A.Dir, "coreos", "1.2.3", "coreos_production_pxe.vmlinuz")
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Fatal("Asset with bad signature should be removed")
+	}
+}
+
+func TestAssetsKeyring(t *testing.T) {
+	c := loadTestConfig(t, testINIConfig)
+	c.OS = "unknown"
+	if _, err := c.assetsKeyring(); err == nil {
+		t.Fatal("Keyring without embedded key should fail")
+	}
+
+	c.A.Key = filepath.Join(t.TempDir(), "key.asc")
+	if err := ioutil.WriteFile(c.A.Key, []byte(testPGPOtherKey), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err := c.assetsKeyring(); err != nil || len(keys) != 1 {
+		t.Fatalf("Key of assets session should be used: %v", err)
+	}
+}
+
+func TestEmbeddedSigningKeys(t *testing.T) {
+	entries, err := signingKeys.ReadDir(signingKeysDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".asc") {
+			continue
+		}
+		if _, err = embeddedKeyring(strings.TrimSuffix(e.Name(), ".asc")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keys, err := parsePGPKeyring([]byte(testPGPKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = checkSigningKey(coreosOS, keys); err == nil {
+		t.Fatal("Key which is not pinned key of coreos should be refused")
+	}
+	if err = checkSigningKey(fedoraCoreOSOS, keys); err != nil {
+		t.Fatal("Key of fedora-coreos is not pinned, got", err)
+	}
+}
+
+func TestVerifyPGPSignature(t *testing.T) {
+	keys, err := parsePGPKeyring([]byte(testPGPKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || pgpFingerprint(keys[0]) != testPGPFingerprint {
+		t.Fatalf("Keyring should have key %s, got %v", testPGPFingerprint, keys)
+	}
+
+	sig := testPGPSignatureBytes(t)
+	k, err := verifyPGPSignature(keys, strings.NewReader(testPGPAsset), sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k != keys[0] {
+		t.Fatalf("Signature should be made by %s, got %s", testPGPFingerprint, pgpFingerprint(k))
+	}
+
+	if _, err = verifyPGPSignature(keys, strings.NewReader("lazykube test asset!\n"), sig); err == nil {
+		t.Fatal("Signature of tampered data should not be verified")
+	}
+
+	others, err := parsePGPKeyring([]byte(testPGPOtherKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = verifyPGPSignature(others, strings.NewReader(testPGPAsset), sig); err == nil {
+		t.Fatal("Signature should not be verified by other key")
+	}
+	if _, err = verifyPGPSignature(append(others, keys...), strings.NewReader(testPGPAsset), sig); err != nil {
+		t.Fatal(err)
+	}
+
+	// binary keyring is read as armored keyring
+	buf := &bytes.Buffer{}
+	if err = keys[0].Serialize(buf); err != nil {
+		t.Fatal(err)
+	}
+	if binary, err := parsePGPKeyring(buf.Bytes()); err != nil || pgpFingerprint(binary[0]) != testPGPFingerprint {
+		t.Fatalf("Binary keyring should be parsed: %v", err)
+	}
+	if _, err = parsePGPKeyring([]byte(strings.Replace(testPGPKey, "PUBLIC KEY", "SIGNATURE", -1))); err == nil {
+		t.Fatal("Armor which is not public key should not be parsed")
+	}
+}
+
+func TestVerifyPGPSignatureSubkey(t *testing.T) {
+	keys, err := parsePGPKeyring([]byte(testPGPSubkey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k, err := verifyPGPSignature(keys, strings.NewReader(testPGPAsset), testPGPBytes(t, testPGPSubkeySignature)); err != nil || k != keys[0] {
+		t.Fatalf("Signature of subkey should be verified by its primary key: %v", err)
+	}
+
+	if _, err = verifyPGPSignature(keys, strings.NewReader(testPGPAsset), testPGPBytes(t, testPGPSHA1Signature)); err == nil {
+		t.Fatal("Signature by sha1 should not be accepted")
+	}
+
+	// subkey bound to testPGPKey by binding signature of another key
+	others, err := parsePGPKeyring([]byte(testPGPKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	others[0].Subkeys = keys[0].Subkeys
+	buf := &bytes.Buffer{}
+	if err = others[0].Serialize(buf); err != nil {
+		t.Fatal(err)
+	}
+	forged, err := parsePGPKeyring(buf.Bytes())
+	if err == nil {
+		_, err = verifyPGPSignature(forged, strings.NewReader(testPGPAsset), testPGPBytes(t, testPGPSubkeySignature))
+	}
+	if err == nil {
+		t.Fatal("Subkey without valid binding signature should not verify signature")
+	}
+}
+
+func TestVerifyPGPSignatureExpired(t *testing.T) {
+	keys, err := parsePGPKeyring([]byte(testPGPExpiredKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = verifyPGPSignature(keys, strings.NewReader(testPGPAsset), testPGPBytes(t, testPGPExpiredSignature)); err == nil {
+		t.Fatal("Signature of expired key should not be verified")
+	}
+}
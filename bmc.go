@@ -0,0 +1,174 @@
+package lazy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const (
+	ipmiBMCDriver    = "ipmi"
+	redfishBMCDriver = "redfish"
+
+	powerOn      = "on"
+	powerOff     = "off"
+	powerCycle   = "cycle"
+	powerPXEOnce = "pxe-once"
+)
+
+// bmcDriver controls power of physical node by its bmc, every action
+// should be idempotent.
+type bmcDriver interface {
+	on() error
+	off() error
+	// cycle powers on node which is off
+	cycle() error
+	// pxeOnce boots node from network at the next boot only
+	pxeOnce() error
+}
+
+func newBMCDriver(n *Node) (bmcDriver, error) {
+	password, err := bmcPassword(n.BMCCredentials)
+	if err != nil {
+		return nil, errors.New("Credentials of node " + n.ID + " failed: " + err.Error())
+	}
+
+	switch n.BMCDriver {
+	case ipmiBMCDriver:
+		return &ipmiDriver{address: n.BMCAddress, username: n.BMCUsername, password: password}, nil
+	case redfishBMCDriver:
+		return newRedfishDriver(n.BMCAddress, n.BMCUsername, password, n.BMCInsecure), nil
+	case "":
+		return nil, errors.New("Node " + n.ID + " has no bmc driver")
+	}
+	return nil, errors.New("Unknown bmc driver: " + n.BMCDriver)
+}
+
+// bmcPassword resolves credentials reference, so password is never kept
+// in ini config.
+func bmcPassword(ref string) (string, error) {
+	switch {
+	case len(ref) == 0:
+		return "", nil
+	case strings.HasPrefix(ref, "env:"):
+		password, ok := os.LookupEnv(strings.TrimPrefix(ref, "env:"))
+		if !ok {
+			return "", errors.New("Env " + strings.TrimPrefix(ref, "env:") + " is not set")
+		}
+		return password, nil
+	case strings.HasPrefix(ref, "file:"):
+		bs, err := ioutil.ReadFile(strings.TrimPrefix(ref, "file:"))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(bs), "\r\n"), nil
+	}
+	return "", errors.New("Credentials reference should be env:NAME or file:PATH")
+}
+
+// RunPower runs power action by bmc of nodes selected by targets.
+func (c *Config) RunPower(action string, targets []string) error {
+	nodes, err := c.selectNodes(targets)
+	if err != nil {
+		return err
+	}
+
+	for _, n := range nodes {
+		d, err := newBMCDriver(n)
+		if err != nil {
+			return err
+		}
+		if err = runPower(d, action); err != nil {
+			return errors.New("Power " + action + " node " + n.ID + " failed: " + err.Error())
+		}
+		log.Println("Power", action, "node", n.ID, "done")
+	}
+	return nil
+}
+
+func runPower(d bmcDriver, action string) error {
+	switch action {
+	case powerOn:
+		return d.on()
+	case powerOff:
+		return d.off()
+	case powerCycle:
+		return d.cycle()
+	case powerPXEOnce:
+		return d.pxeOnce()
+	}
+	return errors.New("Unknown power action: " + action)
+}
+
+// ipmiCommand runs ipmitool, password is passed by IPMI_PASSWORD env, so
+// it is not shown in process list.
+var ipmiCommand = func(password string, args ...string) (string, error) {
+	cmd := exec.Command("ipmitool", args...)
+	cmd.Env = append(os.Environ(), "IPMI_PASSWORD="+password)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("ipmitool %s failed: %v %s", strings.Join(args, " "), err, bytes.TrimSpace(out))
+	}
+	return string(out), nil
+}
+
+type ipmiDriver struct {
+	address  string
+	username string
+	password string
+}
+
+func (d *ipmiDriver) run(args ...string) (string, error) {
+	args = append([]string{"-I", "lanplus", "-H", d.address, "-U", d.username, "-E"}, args...)
+	return ipmiCommand(d.password, args...)
+}
+
+func (d *ipmiDriver) isOn() (bool, error) {
+	out, err := d.run("chassis", "power", "status")
+	if err != nil {
+		return false, err
+	}
+	return strings.HasSuffix(strings.TrimSpace(out), " on"), nil
+}
+
+func (d *ipmiDriver) power(on bool, action string) error {
+	isOn, err := d.isOn()
+	if err != nil || isOn == on {
+		return err
+	}
+	_, err = d.run("chassis", "power", action)
+	return err
+}
+
+func (d *ipmiDriver) on() error {
+	return d.power(true, "on")
+}
+
+func (d *ipmiDriver) off() error {
+	return d.power(false, "off")
+}
+
+func (d *ipmiDriver) cycle() error {
+	isOn, err := d.isOn()
+	if err != nil {
+		return err
+	}
+	if !isOn {
+		_, err = d.run("chassis", "power", "on")
+		return err
+	}
+	_, err = d.run("chassis", "power", "cycle")
+	return err
+}
+
+func (d *ipmiDriver) pxeOnce() error {
+	if _, err := d.run("chassis", "bootdev", "pxe"); err != nil {
+		return err
+	}
+	return d.cycle()
+}
@@ -0,0 +1,82 @@
+package lazy
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBMCPassword(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "password")
+	if err := ioutil.WriteFile(file, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LAZYKUBE_TEST_BMC_PASSWORD", "from-env")
+
+	for ref, expected := range map[string]string{
+		"":                               "",
+		"env:LAZYKUBE_TEST_BMC_PASSWORD": "from-env",
+		"file:" + file:                   "from-file",
+	} {
+		password, err := bmcPassword(ref)
+		if err != nil || password != expected {
+			t.Fatalf("Password of %q should be %q, got %q %v", ref, expected, password, err)
+		}
+	}
+
+	for _, ref := range []string{"secret", "env:LAZYKUBE_TEST_BMC_MISSING", "file:" + file + ".missing"} {
+		if _, err := bmcPassword(ref); err == nil {
+			t.Fatalf("Password of %q should fail", ref)
+		}
+	}
+}
+
+func TestBMCConfig(t *testing.T) {
+	for _, bmc := range []string{"bmc_driver=ilo\nbmc_address=10.0.0.5", "bmc_driver=ipmi"} {
+		file := filepath.Join(t.TempDir(), "lazy.ini")
+		content := strings.Replace(testINIConfig, "[work1]", "[work1]\n"+bmc, 1)
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := Load(file); err == nil {
+			t.Fatalf("Bmc config %q should fail", bmc)
+		}
+	}
+}
+
+func TestIPMIPower(t *testing.T) {
+	state := "off"
+	var calls []string
+	defer func(f func(string, ...string) (string, error)) { ipmiCommand = f }(ipmiCommand)
+	ipmiCommand = func(password string, args ...string) (string, error) {
+		if password != "secret" || strings.Join(args[:7], " ") != "-I lanplus -H 10.0.0.5 -U admin -E" {
+			t.Fatalf("Ipmitool of %v is not correct", args)
+		}
+		cmd := strings.Join(args[7:], " ")
+		if cmd == "chassis power status" {
+			return "Chassis Power is " + state + "\n", nil
+		}
+		calls = append(calls, cmd)
+		switch cmd {
+		case "chassis power on":
+			state = "on"
+		case "chassis power off":
+			state = "off"
+		}
+		return "", nil
+	}
+
+	d := &ipmiDriver{address: "10.0.0.5", username: "admin", password: "secret"}
+	for _, action := range []string{powerOn, powerOn, powerCycle, powerOff, powerOff, powerPXEOnce} {
+		if err := runPower(d, action); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := "chassis power on,chassis power cycle,chassis power off,chassis bootdev pxe,chassis power on"
+	if strings.Join(calls, ",") != expected {
+		t.Fatalf("Ipmitool calls should be %s, got %v", expected, calls)
+	}
+}
@@ -0,0 +1,355 @@
+package lazy
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	bundleManifestFile = "manifest.json"
+	// bundleImagesFile is served by matchbox, so nodes can load images
+	bundleImagesFile = "assets/images/images.tar"
+)
+
+var bundleImageReg = regexp.MustCompile(`(?m)^[\s-]*image:\s*"?([^\s"]+)"?\s*$`)
+
+// saveImages writes container images into file, images are pulled by
+// docker when they are not on local.
+var saveImages = func(images []string, file string) error {
+	for _, image := range images {
+		if err := exec.Command("docker", "image", "inspect", image).Run(); err == nil {
+			continue
+		}
+		log.Println("Pull image", image)
+		if out, err := exec.Command("docker", "pull", image).CombinedOutput(); err != nil {
+			return fmt.Errorf("Pull image %s failed: %v %s", image, err, out)
+		}
+	}
+
+	args := append([]string{"save", "-o", file}, images...)
+	if out, err := exec.Command("docker", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("Save images failed: %v %s", err, out)
+	}
+	return nil
+}
+
+type bundleManifest struct {
+	OS      string       `json:"os"`
+	Channel string       `json:"channel"`
+	Version string       `json:"version"`
+	Images  []string     `json:"images"`
+	Files   []bundleFile `json:"files"`
+}
+
+type bundleFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	// source is the local file of path
+	source string
+}
+
+// bundleImages returns container images referenced by ignition templates.
+func (c *Config) bundleImages() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(c.I.Templates, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+
+	set := make(map[string]bool)
+	for _, file := range files {
+		bs, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range bundleImageReg.FindAllSubmatch(bs, -1) {
+			set[string(m[1])] = true
+		}
+	}
+
+	images := make([]string, 0, len(set))
+	for image := range set {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+	return images, nil
+}
+
+// addBundleDir adds regular files under dir into prefix of bundle, hidden
+// files and partial downloads are skipped.
+func addBundleDir(files []bundleFile, dir, prefix string) ([]bundleFile, error) {
+	err := filepath.Walk(dir, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		name := fi.Name()
+		if file != dir && strings.HasPrefix(name, ".") {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !fi.Mode().IsRegular() || strings.HasSuffix(name, ".part") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		files = append(files, bundleFile{Path: path.Join(prefix, filepath.ToSlash(rel)), source: file})
+		return nil
+	})
+	return files, err
+}
+
+func sha256File(file string) (string, int64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// CreateBundle packs assets, generated groups and profiles of outputPath,
+// ignition templates and container images into gzipped tarball. Layout
+// of tarball is the same as matchbox data dir.
+func (c *Config) CreateBundle(file, outputPath string, images bool) error {
+	files, err := addBundleDir(nil, c.A.Dir, "assets")
+	if err != nil {
+		return errors.New("Add assets into bundle failed: " + err.Error())
+	}
+
+	// only groups and profiles applied into matchbox are packed
+	for dir, pattern := range matchboxConfigGlobs(outputPath) {
+		generated, err := filepath.Glob(pattern)
+		if err != nil {
+			return errors.New("Add generated config into bundle failed: " + err.Error())
+		}
+		for _, file := range generated {
+			files = append(files, bundleFile{Path: path.Join(dir, filepath.Base(file)), source: file})
+		}
+	}
+
+	if files, err = addBundleDir(files, c.I.Templates, "ignition"); err != nil {
+		return errors.New("Add ignition templates into bundle failed: " + err.Error())
+	}
+
+	m := &bundleManifest{
+		OS:      c.OS,
+		Channel: c.Channel,
+		Version: c.Version,
+		Images:  make([]string, 0),
+	}
+
+	if images {
+		if m.Images, err = c.bundleImages(); err != nil {
+			return err
+		}
+
+		tmp, err := ioutil.TempDir("", "lazykube-bundle")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tmp)
+
+		imagesFile := filepath.Join(tmp, "images.tar")
+		if err = saveImages(m.Images, imagesFile); err != nil {
+			return err
+		}
+		files = append(files, bundleFile{Path: bundleImagesFile, source: imagesFile})
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	for i := range files {
+		if files[i].SHA256, files[i].Size, err = sha256File(files[i].source); err != nil {
+			return err
+		}
+	}
+	m.Files = files
+
+	return writeBundle(file, m)
+}
+
+// writeBundle writes manifest as the first entry, so files can be
+// verified while they are unpacked.
+func writeBundle(file string, m *bundleManifest) error {
+	out, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	gw := gzip.NewWriter(out)
+	tw := tar.NewWriter(gw)
+
+	bs, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	bs = append(bs, '\n')
+	err = tw.WriteHeader(&tar.Header{Name: bundleManifestFile, Mode: 0644, Size: int64(len(bs))})
+	if err == nil {
+		_, err = tw.Write(bs)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, f := range m.Files {
+		if err = writeBundleFile(tw, f); err != nil {
+			return err
+		}
+	}
+
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	if err = gw.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+func writeBundleFile(tw *tar.Writer, f bundleFile) error {
+	src, err := os.Open(f.source)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if err = tw.WriteHeader(&tar.Header{Name: f.Path, Mode: 0644, Size: f.Size}); err != nil {
+		return err
+	}
+
+	// tar writer fails when file is changed after it is hashed
+	if _, err = io.CopyN(tw, src, f.Size); err != nil {
+		return errors.New("Write " + f.Path + " into bundle failed: " + err.Error())
+	}
+	return nil
+}
+
+// InstallBundle unpacks bundle into matchbox data dir, every file is
+// verified by checksum of manifest before it replaces the old one.
+func InstallBundle(file, dir string) error {
+	in, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	gr, err := gzip.NewReader(in)
+	if err != nil {
+		return errors.New("Bundle is not gzipped tarball: " + err.Error())
+	}
+	tr := tar.NewReader(gr)
+
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != bundleManifestFile {
+		return errors.New("Manifest should be the first file of bundle")
+	}
+
+	m := &bundleManifest{}
+	if err = json.NewDecoder(tr).Decode(m); err != nil {
+		return errors.New("Manifest of bundle is not correct: " + err.Error())
+	}
+
+	expected := make(map[string]bundleFile)
+	for _, f := range m.Files {
+		expected[f.Path] = f
+	}
+
+	for {
+		hdr, err = tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+
+		f, ok := expected[hdr.Name]
+		if !ok || hdr.Typeflag != tar.TypeReg {
+			return errors.New("File is not in manifest of bundle: " + hdr.Name)
+		}
+		delete(expected, hdr.Name)
+
+		if err = installBundleFile(tr, dir, f); err != nil {
+			return err
+		}
+	}
+
+	if len(expected) != 0 {
+		missing := make([]string, 0, len(expected))
+		for p := range expected {
+			missing = append(missing, p)
+		}
+		sort.Strings(missing)
+		return errors.New("Files of manifest are missing in bundle: " + strings.Join(missing, ","))
+	}
+
+	if len(m.Images) != 0 {
+		log.Println("Load container images by docker load -i", filepath.Join(dir, filepath.FromSlash(bundleImagesFile)))
+	}
+	return nil
+}
+
+func installBundleFile(r io.Reader, dir string, f bundleFile) error {
+	// Names of manifest can not escape from dir
+	clean := path.Clean("/" + f.Path)
+	if clean != "/"+f.Path {
+		return errors.New("Path of bundle file is not correct: " + f.Path)
+	}
+
+	dest := filepath.Join(dir, filepath.FromSlash(clean))
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+
+	tmp := dest + ".bundle"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(out, h), r)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && (n != f.Size || hex.EncodeToString(h.Sum(nil)) != f.SHA256) {
+		err = errors.New("Checksum of " + f.Path + " is not correct")
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
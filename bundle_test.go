@@ -0,0 +1,162 @@
+package lazy
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, file, content string) {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBundle(t *testing.T) {
+	dir := t.TempDir()
+	c := loadTestConfig(t, testINIConfig+"\n[assets]\ndir="+filepath.Join(dir, "assets")+"\n")
+
+	writeTestFile(t, filepath.Join(c.A.Dir, "coreos", "1353.7.0", "coreos_production_pxe.vmlinuz"), "kernel")
+	writeTestFile(t, filepath.Join(c.A.Dir, "coreos", "1353.7.0", "coreos_production_image.bin.bz2.part"), "partial")
+	writeTestFile(t, filepath.Join(c.A.Dir, "tls", "ca.pem"), "ca")
+	writeTestFile(t, filepath.Join(c.A.Dir, ".gitignore"), "*")
+
+	output := filepath.Join(dir, "_output")
+	if err := c.Generate(output); err != nil {
+		t.Fatal(err)
+	}
+
+	var saved []string
+	defer func(save func([]string, string) error) { saveImages = save }(saveImages)
+	saveImages = func(images []string, file string) error {
+		saved = images
+		return ioutil.WriteFile(file, []byte("images"), 0644)
+	}
+
+	bundle := filepath.Join(dir, "bundle.tar.gz")
+	if err := c.CreateBundle(bundle, output, true); err != nil {
+		t.Fatal(err)
+	}
+
+	images := map[string]bool{}
+	for _, image := range saved {
+		images[image] = true
+	}
+	if !images["quay.io/coreos/hyperkube:v1.5.2_coreos.0"] || !images["neoassist/docker-keepalived"] {
+		t.Fatalf("Images of templates are not saved: %v", saved)
+	}
+
+	target := filepath.Join(dir, "matchbox")
+	if err := InstallBundle(bundle, target); err != nil {
+		t.Fatal(err)
+	}
+
+	for file, content := range map[string]string{
+		"assets/coreos/1353.7.0/coreos_production_pxe.vmlinuz": "kernel",
+		"assets/tls/ca.pem":        "ca",
+		"assets/images/images.tar": "images",
+	} {
+		bs, err := ioutil.ReadFile(filepath.Join(target, filepath.FromSlash(file)))
+		if err != nil || string(bs) != content {
+			t.Fatalf("%s should be installed: %v", file, err)
+		}
+	}
+
+	for _, file := range []string{"groups/ctl1.json", "profiles/k8s-worker.json", "ignition/k8s-worker.yaml"} {
+		if _, err := os.Stat(filepath.Join(target, filepath.FromSlash(file))); err != nil {
+			t.Fatalf("%s should be installed: %v", file, err)
+		}
+	}
+
+	for _, file := range []string{"assets/coreos/1353.7.0/coreos_production_image.bin.bz2.part",
+		"assets/.gitignore", "groups/profiles"} {
+		if _, err := os.Stat(filepath.Join(target, filepath.FromSlash(file))); !os.IsNotExist(err) {
+			t.Fatalf("%s should not be installed", file)
+		}
+	}
+}
+
+func TestCreateBundleGenerated(t *testing.T) {
+	dir := t.TempDir()
+	c := loadTestConfig(t, testINIConfig)
+	c.A.Dir = filepath.Join(dir, "assets")
+	c.I.Templates = filepath.Join(dir, "templates")
+	os.MkdirAll(c.A.Dir, 0755)
+	os.MkdirAll(c.I.Templates, 0755)
+
+	// only files which are applied into matchbox are packed
+	output := filepath.Join(dir, "_output")
+	for _, file := range []string{"ctl1.json", "notes.txt", "nodes/ctl1.json",
+		"profiles/k8s-master.json", "profiles/k8s-master.json.bak", "profiles/old/k8s-worker.json",
+		"ubuntu/local.ipxe"} {
+		writeTestFile(t, filepath.Join(output, filepath.FromSlash(file)), "{}")
+	}
+
+	bundle := filepath.Join(dir, "bundle.tar.gz")
+	if err := c.CreateBundle(bundle, output, false); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gr)
+	if _, err = tr.Next(); err != nil {
+		t.Fatal(err)
+	}
+	m := &bundleManifest{}
+	if err = json.NewDecoder(tr).Decode(m); err != nil {
+		t.Fatal(err)
+	}
+
+	var paths []string
+	for _, file := range m.Files {
+		paths = append(paths, file.Path)
+	}
+	if expected := []string{"groups/ctl1.json", "profiles/k8s-master.json"}; !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("Bundle should only pack %v, got %v", expected, paths)
+	}
+}
+
+func TestInstallBundleChecksum(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "ca.pem")
+	writeTestFile(t, src, "ca")
+
+	bundle := filepath.Join(dir, "bundle.tar.gz")
+	m := &bundleManifest{Files: []bundleFile{{Path: "assets/tls/ca.pem", Size: 2, SHA256: "00", source: src}}}
+	if err := writeBundle(bundle, m); err != nil {
+		t.Fatal(err)
+	}
+
+	target := filepath.Join(dir, "matchbox")
+	if err := InstallBundle(bundle, target); err == nil {
+		t.Fatal("File with wrong checksum should not be installed")
+	}
+	if _, err := os.Stat(filepath.Join(target, "assets", "tls", "ca.pem")); !os.IsNotExist(err) {
+		t.Fatal("File with wrong checksum should be removed")
+	}
+
+	m.Files[0].Path = "../ca.pem"
+	m.Files[0].SHA256, _, _ = sha256File(src)
+	if err := writeBundle(bundle, m); err != nil {
+		t.Fatal(err)
+	}
+	if err := InstallBundle(bundle, target); err == nil {
+		t.Fatal("File out of matchbox dir should not be installed")
+	}
+}
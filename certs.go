@@ -0,0 +1,271 @@
+package lazy
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	tlsAssetsDir = "tls"
+
+	caCertFile      = "ca.pem"
+	caKeyFile       = "ca-key.pem"
+	adminKubeconfig = "kubeconfig"
+
+	caValidity   = 10000 * 24 * time.Hour
+	certValidity = 365 * 24 * time.Hour
+)
+
+// certRequest is certificate signed by cluster ca.
+type certRequest struct {
+	name   string
+	cn     string
+	org    []string
+	usages []x509.ExtKeyUsage
+	dns    []string
+	ips    []net.IP
+}
+
+type certKeyPair struct {
+	cert *x509.Certificate
+	key  *rsa.PrivateKey
+}
+
+func writePEM(file, typ string, der []byte, perm os.FileMode) error {
+	return ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), perm)
+}
+
+func readPEM(file, typ string) ([]byte, error) {
+	bs, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	b, _ := pem.Decode(bs)
+	if b == nil || b.Type != typ {
+		return nil, errors.New("File " + file + " is not pem of " + strings.ToLower(typ))
+	}
+	return b.Bytes, nil
+}
+
+func serialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// loadOrCreateCA reuses ca of dir, so certificates of installed nodes are
+// still trusted after certificates are generated again.
+func loadOrCreateCA(dir string) (*certKeyPair, error) {
+	certFile, keyFile := filepath.Join(dir, caCertFile), filepath.Join(dir, caKeyFile)
+	certDER, err := readPEM(certFile, "CERTIFICATE")
+	if err == nil {
+		keyDER, err := readPEM(keyFile, "RSA PRIVATE KEY")
+		if err != nil {
+			return nil, err
+		}
+
+		ca := &certKeyPair{}
+		if ca.cert, err = x509.ParseCertificate(certDER); err != nil {
+			return nil, err
+		}
+		if ca.key, err = x509.ParsePKCS1PrivateKey(keyDER); err != nil {
+			return nil, err
+		}
+		return ca, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "kube-ca"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = writePEM(keyFile, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key), 0600); err != nil {
+		return nil, err
+	}
+	if err = writePEM(certFile, "CERTIFICATE", der, 0644); err != nil {
+		return nil, err
+	}
+	return &certKeyPair{cert: cert, key: key}, nil
+}
+
+// signCert writes <name>.pem and <name>-key.pem of request into dir.
+func signCert(ca *certKeyPair, dir string, req certRequest) (*certKeyPair, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: req.cn, Organization: req.org},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(certValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           req.usages,
+		BasicConstraintsValid: true,
+		DNSNames:              req.dns,
+		IPAddresses:           req.ips,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	err = writePEM(filepath.Join(dir, req.name+"-key.pem"), "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key), 0600)
+	if err == nil {
+		err = writePEM(filepath.Join(dir, req.name+".pem"), "CERTIFICATE", der, 0644)
+	}
+	return &certKeyPair{cert: cert, key: key}, err
+}
+
+// certRequests returns certificates of apiserver and worker, names of
+// them are used by ignition templates.
+func (c *Config) certRequests() ([]certRequest, error) {
+	_, serviceNet, err := net.ParseCIDR(c.K.ServiceIPRange)
+	if err != nil {
+		return nil, err
+	}
+	// kubernetes service gets the first ip of service ip range
+	serviceIP := uint32ToIPv4(ipv4ToUint32(serviceNet.IP) + 1)
+
+	apiserver := certRequest{
+		name:   "apiserver",
+		cn:     "kube-apiserver",
+		usages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		dns: []string{
+			"kubernetes",
+			"kubernetes.default",
+			"kubernetes.default.svc",
+			"kubernetes.default.svc.cluster.local",
+		},
+		ips: []net.IP{serviceIP},
+	}
+	worker := certRequest{
+		name:   "worker",
+		cn:     "kube-worker",
+		usages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	for _, n := range c.Nodes {
+		if n.Role == "master" {
+			apiserver.dns = append(apiserver.dns, n.Domain)
+		}
+		worker.dns = append(worker.dns, n.Domain)
+	}
+
+	if c.V != nil && c.V.Enable {
+		apiserver.ips = append(apiserver.ips, net.ParseIP(c.V.VIP))
+		if len(c.V.Domain) != 0 {
+			apiserver.dns = append(apiserver.dns, c.V.Domain)
+			worker.dns = append(worker.dns, c.V.Domain)
+		}
+	}
+	return []certRequest{apiserver, worker}, nil
+}
+
+type kubeconfigData struct {
+	Server string
+	CA     string
+	Cert   string
+	Key    string
+}
+
+// GenerateCerts writes certificates of apiserver and worker with ca cert
+// into tls of assets dir, where nodes download them. CA key and admin
+// kubeconfig are written into pki dir, which is never served.
+func (c *Config) GenerateCerts() error {
+	tlsDir := filepath.Join(c.A.Dir, tlsAssetsDir)
+	if err := os.MkdirAll(tlsDir, 0755); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.K.PKI, 0700); err != nil {
+		return err
+	}
+
+	ca, err := loadOrCreateCA(c.K.PKI)
+	if err != nil {
+		return errors.New("Load cluster ca failed: " + err.Error())
+	}
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})
+	if err = ioutil.WriteFile(filepath.Join(tlsDir, caCertFile), caPEM, 0644); err != nil {
+		return err
+	}
+
+	reqs, err := c.certRequests()
+	if err != nil {
+		return err
+	}
+	for _, req := range reqs {
+		if _, err = signCert(ca, tlsDir, req); err != nil {
+			return errors.New("Sign " + req.name + " certificate failed: " + err.Error())
+		}
+	}
+
+	admin, err := signCert(ca, c.K.PKI, certRequest{
+		name:   "admin",
+		cn:     "kube-admin",
+		org:    []string{"system:masters"},
+		usages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return errors.New("Sign admin certificate failed: " + err.Error())
+	}
+
+	bs, err := renderTemplate(KUBECONFIG_TMPL, "kubeconfig", kubeconfigData{
+		Server: c.Cls.ControllerEndpoint,
+		CA:     base64.StdEncoding.EncodeToString(caPEM),
+		Cert:   base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: admin.cert.Raw})),
+		Key: base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{
+			Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(admin.key)})),
+	})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(c.K.PKI, adminKubeconfig), bs, 0600)
+}
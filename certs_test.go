@@ -0,0 +1,93 @@
+package lazy
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestGenerateCerts(t *testing.T) {
+	dir := t.TempDir()
+	c := loadTestConfig(t, testINIConfig)
+	c.A.Dir = filepath.Join(dir, "assets")
+	c.K.PKI = filepath.Join(dir, "pki")
+
+	if err := c.GenerateCerts(); err != nil {
+		t.Fatal(err)
+	}
+
+	caDER, err := readPEM(filepath.Join(c.A.Dir, tlsAssetsDir, caCertFile), "CERTIFICATE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	verify := func(file string, usage x509.ExtKeyUsage, names ...string) {
+		der, err := readPEM(file, "CERTIFICATE")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		opts := x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{usage}}
+		if _, err = cert.Verify(opts); err != nil {
+			t.Fatalf("Certificate %s is not signed by ca: %v", file, err)
+		}
+		for _, name := range names {
+			if err = cert.VerifyHostname(name); err != nil {
+				t.Fatalf("Certificate %s should be valid for %s: %v", file, name, err)
+			}
+		}
+	}
+
+	tlsDir := filepath.Join(c.A.Dir, tlsAssetsDir)
+	verify(filepath.Join(tlsDir, "apiserver.pem"), x509.ExtKeyUsageServerAuth,
+		"kubernetes.default", "ctl1.example.com", "vip.cluster.com", "172.17.0.30", "10.3.0.1")
+	verify(filepath.Join(tlsDir, "worker.pem"), x509.ExtKeyUsageServerAuth,
+		"ctl1.example.com", "work1.example.com", "work2.example.com")
+	verify(filepath.Join(c.K.PKI, "admin.pem"), x509.ExtKeyUsageClientAuth)
+
+	// assets are served, so ca key and admin credentials are not there
+	for _, name := range []string{caKeyFile, "admin-key.pem", adminKubeconfig} {
+		if _, err = ioutil.ReadFile(filepath.Join(tlsDir, name)); err == nil {
+			t.Fatalf("File %s should not be in served assets", name)
+		}
+	}
+
+	bs, err := ioutil.ReadFile(filepath.Join(c.K.PKI, adminKubeconfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := parseYAML(string(bs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cluster := v.(map[string]interface{})["clusters"].([]interface{})[0].(map[string]interface{})["cluster"].(map[string]interface{})
+	if cluster["server"] != "https://vip.cluster.com" {
+		t.Fatal("Server of kubeconfig should be controller endpoint, got", cluster["server"])
+	}
+	caPEM, _ := base64.StdEncoding.DecodeString(cluster["certificate-authority-data"].(string))
+	if b, _ := pem.Decode(caPEM); b == nil || string(b.Bytes) != string(caDER) {
+		t.Fatal("CA of kubeconfig is not cluster ca")
+	}
+
+	// ca is reused when certificates are generated again
+	if err = c.GenerateCerts(); err != nil {
+		t.Fatal(err)
+	}
+	der, err := readPEM(filepath.Join(tlsDir, caCertFile), "CERTIFICATE")
+	if err != nil || string(der) != string(caDER) {
+		t.Fatal("CA should be reused")
+	}
+}
@@ -1,11 +1,21 @@
 package lazy
 
+const (
+	defaultPodNetwork     = "10.2.0.0/16"
+	defaultServiceIPRange = "10.3.0.0/24"
+	defaultDNSServiceIP   = "10.3.0.10"
+)
+
 type Cluster struct {
 	InitialCluster     string
 	Endpoints          string
 	ControllerEndpoint string
 	AuthorizedKeys     string
 	Registries         []string
+	Masters            []string
+	DomainBase         string
+	EtcHosts           []string
 	M                  *MatchboxConfig
+	K                  *KubernetesConfig
 	*Network
 }
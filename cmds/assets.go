@@ -0,0 +1,68 @@
+package main
+
+import (
+  "github.com/lyanchih/LazyKube"
+  "github.com/spf13/cobra"
+)
+
+var (
+  assetsDir string
+  assetsMirror string
+  assetsKey string
+)
+
+const assetsUsage = `
+Manage os assets of matchbox
+`
+
+const assetsFetchUsage = `
+Download kernel, initrd and install image of os, channel and version
+of config into assets dir. Interrupted downloads are resumed, files
+must be verified by OpenPGP signature and their checksums are written
+into manifest.json beside them.
+`
+
+func newAssetsCmd() *cobra.Command {
+  cmd := &cobra.Command{
+    Use: "assets",
+    Short: "Manage os assets",
+    Long: assetsUsage,
+  }
+
+  cmd.AddCommand(newAssetsFetchCmd())
+
+  return cmd
+}
+
+func newAssetsFetchCmd() *cobra.Command {
+  cmd := &cobra.Command{
+    Use: "fetch",
+    Short: "Download and verify os assets",
+    Long: assetsFetchUsage,
+    RunE: func(cmd *cobra.Command, args []string) error {
+      c, err := lazy.Load(configFile)
+      if err != nil {
+        return err
+      }
+
+      if len(assetsDir) != 0 {
+        c.A.Dir = assetsDir
+      }
+      if len(assetsMirror) != 0 {
+        c.A.Mirror = assetsMirror
+      }
+      if len(assetsKey) != 0 {
+        c.A.Key = assetsKey
+      }
+      return c.FetchAssets()
+    },
+  }
+
+  f := cmd.Flags()
+  f.StringVar(&configFile, "config-file", "etc/lazy.ini", "Lazykube ini config file")
+  f.StringVar(&assetsDir, "dir", "", "Assets dir, overrides dir of assets session")
+  f.StringVar(&assetsMirror, "mirror", "", "Mirror url, overrides mirror of assets session")
+  f.StringVar(&assetsKey, "key", "", "Armored public key file, overrides embedded key")
+
+  return cmd
+}
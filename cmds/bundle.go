@@ -0,0 +1,83 @@
+package main
+
+import (
+  "github.com/lyanchih/LazyKube"
+  "github.com/spf13/cobra"
+)
+
+var (
+  bundleFile string
+  bundleImages bool
+  matchboxDir string
+)
+
+const bundleUsage = `
+Export and import offline bundle for sites without internet
+`
+
+const bundleCreateUsage = `
+Pack os assets and certs of assets dir, generated groups and profiles,
+ignition templates and container images referenced by the templates
+into a gzipped tarball. Manifest of the tarball records checksums of
+all files.
+`
+
+const bundleInstallUsage = `
+Unpack bundle into matchbox data dir, which has assets, groups,
+profiles and ignition folders. Files are verified by checksums of
+manifest before they are installed.
+`
+
+func newBundleCmd() *cobra.Command {
+  cmd := &cobra.Command{
+    Use: "bundle",
+    Short: "Export and import offline bundle",
+    Long: bundleUsage,
+  }
+
+  cmd.AddCommand(newBundleCreateCmd())
+  cmd.AddCommand(newBundleInstallCmd())
+
+  return cmd
+}
+
+func newBundleCreateCmd() *cobra.Command {
+  cmd := &cobra.Command{
+    Use: "create",
+    Short: "Create offline bundle",
+    Long: bundleCreateUsage,
+    RunE: func(cmd *cobra.Command, args []string) error {
+      c, err := lazy.Load(configFile)
+      if err != nil {
+        return err
+      }
+
+      return c.CreateBundle(bundleFile, outputPath, bundleImages)
+    },
+  }
+
+  f := cmd.Flags()
+  f.StringVar(&configFile, "config-file", "etc/lazy.ini", "Lazykube ini config file")
+  f.StringVar(&outputPath, "output", "_output", "Deploy config output path")
+  f.StringVar(&bundleFile, "file", "lazykube-bundle.tar.gz", "Bundle file")
+  f.BoolVar(&bundleImages, "images", true, "Save container images by docker")
+
+  return cmd
+}
+
+func newBundleInstallCmd() *cobra.Command {
+  cmd := &cobra.Command{
+    Use: "install",
+    Short: "Install offline bundle into matchbox",
+    Long: bundleInstallUsage,
+    RunE: func(cmd *cobra.Command, args []string) error {
+      return lazy.InstallBundle(bundleFile, matchboxDir)
+    },
+  }
+
+  f := cmd.Flags()
+  f.StringVar(&bundleFile, "file", "lazykube-bundle.tar.gz", "Bundle file")
+  f.StringVar(&matchboxDir, "matchbox-dir", "/var/lib/matchbox", "Matchbox data dir")
+
+  return cmd
+}
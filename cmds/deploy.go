@@ -0,0 +1,82 @@
+package main
+
+import (
+  "os"
+  "path/filepath"
+  "strings"
+  "time"
+  "github.com/lyanchih/LazyKube"
+  "github.com/spf13/cobra"
+)
+
+var (
+  deployMatchboxDir string
+  deployMatchboxIP string
+  deployDNS []string
+  deploySSHKey string
+  deployTimeout time.Duration
+  deployFromStep string
+  deployOnly []string
+)
+
+const deployUsage = `
+Deploy cluster by ordered steps: validate, certs, assets, generate,
+serve, power and wait. Progress of steps is recorded in state of output
+path, failed deploy is resumed from the failed step. Ini config is never
+rewritten, ssh key is only authorized in memory when keys of config are
+empty, --matchbox-ip and --dns replace ip of matchbox and dns servers of
+config in memory, like ips of matchbox and dnsmasq containers.
+`
+
+func newDeployCmd() *cobra.Command {
+  cmd := &cobra.Command{
+    Use: "deploy",
+    Short: "Deploy cluster end to end",
+    Long: deployUsage,
+    RunE: func(cmd *cobra.Command, args []string) error {
+      overrides := make(map[string]string)
+      if len(deployMatchboxIP) != 0 {
+        overrides["matchbox.ip"] = deployMatchboxIP
+      }
+      if len(deployDNS) != 0 {
+        overrides["dns.dns"] = strings.Join(deployDNS, ",")
+      }
+
+      c, err := lazy.LoadWithOverrides(configFile, overrides)
+      if err != nil {
+        return err
+      }
+
+      if len(deploySSHKey) == 0 {
+        if home, err := os.UserHomeDir(); err == nil {
+          deploySSHKey = filepath.Join(home, ".ssh", "id_rsa.pub")
+        }
+      }
+
+      return c.Deploy(&lazy.DeployOptions{
+        ConfigFile: configFile,
+        OutputPath: outputPath,
+        MatchboxDir: deployMatchboxDir,
+        Progress: filepath.Join(outputPath, "state", "deploy.json"),
+        Overrides: overrides,
+        SSHKey: deploySSHKey,
+        Timeout: deployTimeout,
+        FromStep: deployFromStep,
+        Only: deployOnly,
+      })
+    },
+  }
+
+  f := cmd.Flags()
+  f.StringVar(&configFile, "config-file", "etc/lazy.ini", "Lazykube ini config file")
+  f.StringVar(&outputPath, "output", "_output", "Deploy config output path")
+  f.StringVar(&deployMatchboxDir, "matchbox-dir", "contrib/matchbox", "Matchbox data dir which generated config is applied into")
+  f.StringVar(&deployMatchboxIP, "matchbox-ip", "", "Ip of matchbox which replaces ip of matchbox section in memory")
+  f.StringSliceVar(&deployDNS, "dns", nil, "Dns servers which replace dns of dns section in memory")
+  f.StringVar(&deploySSHKey, "ssh-key", "", "Public key authorized when config has no keys, default is ~/.ssh/id_rsa.pub")
+  f.DurationVar(&deployTimeout, "timeout", 30 * time.Minute, "Timeout of waiting nodes to be installed")
+  f.StringVar(&deployFromStep, "from-step", "", "Run steps from this step, ignoring recorded progress")
+  f.StringSliceVar(&deployOnly, "only", nil, "Only run these steps")
+
+  return cmd
+}
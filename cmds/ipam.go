@@ -0,0 +1,61 @@
+package main
+
+import (
+  "fmt"
+  "os"
+  "github.com/lyanchih/LazyKube"
+  "github.com/spf13/cobra"
+)
+
+var (
+  ipamFormat string
+)
+
+const ipamUsage = `
+Inspect ip address management of cluster network pools
+`
+
+const ipamShowUsage = `
+Show cidr, usable range, dhcp keep range, assigned, excluded and
+free addresses of every network pool
+`
+
+func newIPAMCmd() *cobra.Command {
+  cmd := &cobra.Command{
+    Use: "ipam",
+    Short: "Inspect ip address management",
+    Long: ipamUsage,
+  }
+
+  cmd.AddCommand(newIPAMShowCmd())
+
+  return cmd
+}
+
+func newIPAMShowCmd() *cobra.Command {
+  cmd := &cobra.Command{
+    Use: "show",
+    Short: "Show network pools usage",
+    Long: ipamShowUsage,
+    RunE: func(cmd *cobra.Command, args []string) error {
+      c, err := lazy.Load(configFile)
+      if err != nil {
+        return err
+      }
+
+      switch ipamFormat {
+      case "table":
+        return lazy.WriteIPAMTable(os.Stdout, c.IPAM())
+      case "json":
+        return lazy.WriteIPAMJSON(os.Stdout, c.IPAM())
+      }
+      return fmt.Errorf("Unknown output format: %s", ipamFormat)
+    },
+  }
+
+  f := cmd.Flags()
+  f.StringVar(&configFile, "config-file", "etc/lazy.ini", "Lazykube ini config file")
+  f.StringVar(&ipamFormat, "format", "table", "Output format, table or json")
+
+  return cmd
+}
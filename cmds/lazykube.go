@@ -7,7 +7,19 @@ import (
 
 const globalUsage = `The Lazy deploy tool for kuberentes cluster
 Common actions from this point include:
+- lazykube deploy:      Deploy cluster end to end
 - lazykube config:      Generate deploy config
+- lazykube ipam show:   Show network pools usage
+- lazykube network plan: Plan cluster networks
+- lazykube serve:       Serve proxy dhcp, tftp and http for pxe boot
+- lazykube assets fetch: Download and verify os assets
+- lazykube bundle:      Export and import offline bundle
+- lazykube vm render:   Render libvirt xml of nodes
+- lazykube vm create:   Create and start vm nodes
+- lazykube power on:    Power on physical nodes by bmc
+- lazykube status:      Show lifecycle stage of nodes
+- lazykube verify:      Verify health of deployed cluster
+- lazykube wait:        Wait for nodes to be installed or ready
 `
 
 func newRootCmd() *cobra.Command {
@@ -18,6 +30,17 @@ func newRootCmd() *cobra.Command {
   }
 
   cmd.AddCommand(newConfigCmd())
+  cmd.AddCommand(newIPAMCmd())
+  cmd.AddCommand(newNetworkCmd())
+  cmd.AddCommand(newServeCmd())
+  cmd.AddCommand(newAssetsCmd())
+  cmd.AddCommand(newBundleCmd())
+  cmd.AddCommand(newVMCmd())
+  cmd.AddCommand(newPowerCmd())
+  cmd.AddCommand(newStatusCmd())
+  cmd.AddCommand(newDeployCmd())
+  cmd.AddCommand(newVerifyCmd())
+  cmd.AddCommand(newWaitCmd())
   
   return cmd
 }
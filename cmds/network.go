@@ -0,0 +1,72 @@
+package main
+
+import (
+  "os"
+  "github.com/lyanchih/LazyKube"
+  "github.com/spf13/cobra"
+)
+
+var (
+  planReq = lazy.PlanRequest{}
+  planOutput string
+)
+
+const networkUsage = `
+Network helpers for cluster config
+`
+
+const networkPlanUsage = `
+Plan node pools, vip, dhcp keep range and kubernetes networks from
+a supernet and node counts, then write them as a lazy ini fragment
+`
+
+func newNetworkCmd() *cobra.Command {
+  cmd := &cobra.Command{
+    Use: "network",
+    Short: "Network helpers",
+    Long: networkUsage,
+  }
+
+  cmd.AddCommand(newNetworkPlanCmd())
+
+  return cmd
+}
+
+func newNetworkPlanCmd() *cobra.Command {
+  cmd := &cobra.Command{
+    Use: "plan",
+    Short: "Plan cluster networks",
+    Long: networkPlanUsage,
+    RunE: func(cmd *cobra.Command, args []string) error {
+      p, err := lazy.NewPlan(&planReq)
+      if err != nil {
+        return err
+      }
+
+      if planOutput == "-" {
+        return p.WriteINI(os.Stdout)
+      }
+
+      f, err := os.OpenFile(planOutput, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+      if err != nil {
+        return err
+      }
+      defer f.Close()
+
+      return p.WriteINI(f)
+    },
+  }
+
+  f := cmd.Flags()
+  f.StringVar(&planReq.Supernet, "supernet", "172.17.0.0/16", "Supernet which node pools are planned in")
+  f.IntVar(&planReq.Masters, "masters", 3, "Number of master nodes")
+  f.IntVar(&planReq.Minions, "minions", 2, "Number of minion nodes")
+  f.IntVar(&planReq.Others, "nodes", 0, "Number of nodes with other roles")
+  f.IntVar(&planReq.Networks, "networks", 1, "Number of node networks")
+  f.Float64Var(&planReq.Headroom, "headroom", 2, "Pool size multiplier of node count")
+  f.IntVar(&planReq.DHCPKeep, "dhcp-keep", 0, "DHCP dynamic range size, derived from node count when 0")
+  f.StringVar(&planReq.VIPDomain, "vip-domain", "vip.cluster.com", "Domain of planned vip")
+  f.StringVar(&planOutput, "output", "-", "Ini fragment output file, - means stdout")
+
+  return cmd
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+  "github.com/lyanchih/LazyKube"
+  "github.com/spf13/cobra"
+)
+
+const powerUsage = `
+Control power of physical nodes by bmc driver of node, ipmi or redfish.
+Actions target nodes by node id or role and are idempotent, e.g. on
+does nothing on powered node.
+`
+
+func newPowerCmd() *cobra.Command {
+  cmd := &cobra.Command{
+    Use: "power",
+    Short: "Control power of physical nodes",
+    Long: powerUsage,
+  }
+
+  cmd.AddCommand(newPowerActionCmd("on", "Power on nodes"))
+  cmd.AddCommand(newPowerActionCmd("off", "Power off nodes"))
+  cmd.AddCommand(newPowerActionCmd("cycle", "Power cycle nodes, nodes which are off are powered on"))
+  cmd.AddCommand(newPowerActionCmd("pxe-once", "Boot nodes from network at the next boot and power cycle them"))
+
+  return cmd
+}
+
+func newPowerActionCmd(action, short string) *cobra.Command {
+  cmd := &cobra.Command{
+    Use: action + " <node id or role>...",
+    Short: short,
+    Long: "\n" + short + " by bmc of nodes\n",
+    Args: cobra.MinimumNArgs(1),
+    RunE: func(cmd *cobra.Command, args []string) error {
+      c, err := lazy.Load(configFile)
+      if err != nil {
+        return err
+      }
+
+      return c.RunPower(action, args)
+    },
+  }
+
+  f := cmd.Flags()
+  f.StringVar(&configFile, "config-file", "etc/lazy.ini", "Lazykube ini config file")
+
+  return cmd
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+  "github.com/lyanchih/LazyKube"
+  "github.com/spf13/cobra"
+)
+
+const serveUsage = `
+Serve proxy dhcp, tftp and http in process, which are enabled by
+serve section of config. Proxy dhcp only answers boot file of declared
+nodes, ip addresses are still handed out by existing dhcp server.
+`
+
+func newServeCmd() *cobra.Command {
+  cmd := &cobra.Command{
+    Use: "serve",
+    Short: "Serve proxy dhcp, tftp and http for pxe boot",
+    Long: serveUsage,
+    RunE: func(cmd *cobra.Command, args []string) error {
+      c, err := lazy.Load(configFile)
+      if err != nil {
+        return err
+      }
+
+      return c.Serve()
+    },
+  }
+
+  f := cmd.Flags()
+  f.StringVar(&configFile, "config-file", "etc/lazy.ini", "Lazykube ini config file")
+
+  return cmd
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+  "os"
+  "github.com/lyanchih/LazyKube"
+  "github.com/spf13/cobra"
+)
+
+var (
+  statusReset bool
+)
+
+const statusUsage = `
+Show lifecycle stage of nodes, which is declared, booting, installing,
+installed or ready, with time of the stage and the last error. Stages
+up to installed are updated by requests of nodes to http server of serve
+command, ready is set by verify command.
+`
+
+func newStatusCmd() *cobra.Command {
+  cmd := &cobra.Command{
+    Use: "status [node id or role]...",
+    Short: "Show lifecycle stage of nodes",
+    Long: statusUsage,
+    RunE: func(cmd *cobra.Command, args []string) error {
+      c, err := lazy.Load(configFile)
+      if err != nil {
+        return err
+      }
+
+      if statusReset {
+        if err = c.ResetNodeStates(args); err != nil {
+          return err
+        }
+      }
+      return c.WriteNodeStatus(os.Stdout, args)
+    },
+  }
+
+  f := cmd.Flags()
+  f.StringVar(&configFile, "config-file", "etc/lazy.ini", "Lazykube ini config file")
+  f.BoolVar(&statusReset, "reset", false, "Reset nodes to declared before they are reinstalled")
+
+  return cmd
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+  "os"
+  "github.com/lyanchih/LazyKube"
+  "github.com/spf13/cobra"
+)
+
+const verifyUsage = `
+Verify cluster by admin kubeconfig and CA of pki dir. It checks health
+of apiserver through VIP and every master, etcd members against initial
+cluster, registration of every node and readiness of kube-dns. Nodes
+which are ready are moved to ready stage.
+`
+
+func newVerifyCmd() *cobra.Command {
+  cmd := &cobra.Command{
+    Use: "verify",
+    Short: "Verify health of deployed cluster",
+    Long: verifyUsage,
+    RunE: func(cmd *cobra.Command, args []string) error {
+      c, err := lazy.Load(configFile)
+      if err != nil {
+        return err
+      }
+
+      return c.Verify(os.Stdout)
+    },
+  }
+
+  f := cmd.Flags()
+  f.StringVar(&configFile, "config-file", "etc/lazy.ini", "Lazykube ini config file")
+
+  return cmd
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+  "os"
+  "github.com/lyanchih/LazyKube"
+  "github.com/spf13/cobra"
+)
+
+const vmUsage = `
+Manage vm nodes for local deploy. Actions target nodes by node id or
+role, all nodes are targeted without them. Actions are idempotent, e.g.
+create only defines and starts what is missing.
+`
+
+const vmRenderUsage = `
+Render libvirt network xml of each network pool, volume and domain xml
+of each node into libvirt folder of output path. Domains boot from
+network of the first interface before disk.
+`
+
+func newVMCmd() *cobra.Command {
+  cmd := &cobra.Command{
+    Use: "vm",
+    Short: "Manage vm nodes",
+    Long: vmUsage,
+  }
+
+  cmd.AddCommand(newVMRenderCmd())
+  cmd.AddCommand(newVMActionCmd("create", "Create and start vm nodes"))
+  cmd.AddCommand(newVMActionCmd("start", "Start vm nodes"))
+  cmd.AddCommand(newVMActionCmd("reboot", "Reboot vm nodes"))
+  cmd.AddCommand(newVMActionCmd("shutdown", "Shutdown vm nodes"))
+  cmd.AddCommand(newVMActionCmd("poweroff", "Poweroff vm nodes"))
+  cmd.AddCommand(newVMActionCmd("destroy", "Destroy vm nodes and their disks"))
+  cmd.AddCommand(newVMActionCmd("vol-delete", "Delete disks of vm nodes"))
+  cmd.AddCommand(newVMStatusCmd())
+
+  return cmd
+}
+
+func newVMRenderCmd() *cobra.Command {
+  cmd := &cobra.Command{
+    Use: "render",
+    Short: "Render libvirt xml of nodes",
+    Long: vmRenderUsage,
+    RunE: func(cmd *cobra.Command, args []string) error {
+      c, err := lazy.Load(configFile)
+      if err != nil {
+        return err
+      }
+
+      return c.RenderVM(outputPath)
+    },
+  }
+
+  f := cmd.Flags()
+  f.StringVar(&configFile, "config-file", "etc/lazy.ini", "Lazykube ini config file")
+  f.StringVar(&outputPath, "output", "_output", "Deploy config output path")
+
+  return cmd
+}
+
+func newVMActionCmd(action, short string) *cobra.Command {
+  cmd := &cobra.Command{
+    Use: action + " [node id or role]...",
+    Short: short,
+    Long: "\n" + short + " by driver of vm section\n",
+    RunE: func(cmd *cobra.Command, args []string) error {
+      c, err := lazy.Load(configFile)
+      if err != nil {
+        return err
+      }
+
+      return c.RunVM(action, args)
+    },
+  }
+
+  f := cmd.Flags()
+  f.StringVar(&configFile, "config-file", "etc/lazy.ini", "Lazykube ini config file")
+
+  return cmd
+}
+
+func newVMStatusCmd() *cobra.Command {
+  cmd := &cobra.Command{
+    Use: "status [node id or role]...",
+    Short: "Show state of vm nodes",
+    Long: "\nShow state of vm nodes, which is running, stopped or not found\n",
+    RunE: func(cmd *cobra.Command, args []string) error {
+      c, err := lazy.Load(configFile)
+      if err != nil {
+        return err
+      }
+
+      return c.WriteVMStatus(os.Stdout, args)
+    },
+  }
+
+  f := cmd.Flags()
+  f.StringVar(&configFile, "config-file", "etc/lazy.ini", "Lazykube ini config file")
+
+  return cmd
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+  "errors"
+  "os"
+  "time"
+  "github.com/lyanchih/LazyKube"
+  "github.com/spf13/cobra"
+)
+
+var (
+  waitFor string
+  waitTimeout time.Duration
+  waitNodes []string
+)
+
+const waitUsage = `
+Wait until nodes are installed or ready. Waiting for ready also checks
+health of cluster as verify command. When timeout expires, it exits with
+status of nodes and failed checks, which shows where nodes are stuck.
+`
+
+func newWaitCmd() *cobra.Command {
+  cmd := &cobra.Command{
+    Use: "wait",
+    Short: "Wait for nodes to be installed or ready",
+    Long: waitUsage,
+    RunE: func(cmd *cobra.Command, args []string) error {
+      if waitFor != "installed" && waitFor != "ready" {
+        return errors.New("Wait for should be installed or ready, got " + waitFor)
+      }
+
+      c, err := lazy.Load(configFile)
+      if err != nil {
+        return err
+      }
+
+      return c.Wait(os.Stdout, waitFor, waitTimeout, waitNodes)
+    },
+  }
+
+  f := cmd.Flags()
+  f.StringVar(&configFile, "config-file", "etc/lazy.ini", "Lazykube ini config file")
+  f.StringVar(&waitFor, "for", "ready", "Stage to wait for, installed or ready")
+  f.DurationVar(&waitTimeout, "timeout", 30 * time.Minute, "Timeout of waiting")
+  f.StringSliceVar(&waitNodes, "nodes", nil, "Node ids or roles to wait for, default is all nodes")
+
+  return cmd
+}
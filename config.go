@@ -9,6 +9,7 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -24,6 +25,18 @@ func loadINIConfig(file string) (*iniConfig, error) {
 	return (*iniConfig)(cfg), nil
 }
 
+func (cfg *iniConfig) override(overrides map[string]string) error {
+	iniFile := (*ini.File)(cfg)
+	for k, v := range overrides {
+		i := strings.LastIndex(k, ".")
+		if i <= 0 || i == len(k)-1 {
+			return errors.New("Override key should be section.key, got " + k)
+		}
+		iniFile.Section(k[:i]).Key(k[i+1:]).SetValue(v)
+	}
+	return nil
+}
+
 func (cfg *iniConfig) newConfigFromSection(s string, v interface{}) (interface{}, error) {
 	iniFile := (*ini.File)(cfg)
 	sec, err := iniFile.GetSection(s)
@@ -47,7 +60,12 @@ func (cfg *iniConfig) newDefaultConfig() (*DefaultConfig, error) {
 	if err != nil {
 		return nil, err
 	}
-	return v.(*DefaultConfig), nil
+
+	d := v.(*DefaultConfig)
+	if len(d.OS) == 0 {
+		d.OS = coreosOS
+	}
+	return d, nil
 }
 
 func (cfg *iniConfig) newNodes(ids []string) ([]*Node, error) {
@@ -103,16 +121,144 @@ func (cfg *iniConfig) newDNSConfig() (*DNSConfig, error) {
 	if err != nil {
 		return nil, err
 	}
-	return v.(*DNSConfig), nil
+
+	d := v.(*DNSConfig)
+	// dnsmasq used to be run by docker driver
+	if len(d.Driver) == 0 || d.Driver == "docker" {
+		d.Driver = dnsmasqDNSDriver
+	}
+	return d, nil
 }
 
 func (cfg *iniConfig) newDHCPConfig() (*DHCPConfig, error) {
-	v, err := cfg.newConfigFromSection("dns", &DHCPConfig{})
+	v, err := cfg.newConfigFromSection("dhcp", &DHCPConfig{})
+	if err != nil {
+		return nil, err
+	}
+
+	d := v.(*DHCPConfig)
+	if len(d.Driver) == 0 {
+		d.Driver = dnsmasqDHCPDriver
+	}
+	return d, nil
+}
+
+func (cfg *iniConfig) newKubernetesConfig() (*KubernetesConfig, error) {
+	v, err := cfg.newConfigFromSection("kubernetes", &KubernetesConfig{})
+	if err != nil {
+		return nil, err
+	}
+
+	k := v.(*KubernetesConfig)
+	if len(k.PodNetwork) == 0 {
+		k.PodNetwork = defaultPodNetwork
+	}
+	if len(k.ServiceIPRange) == 0 {
+		k.ServiceIPRange = defaultServiceIPRange
+	}
+	if len(k.DNSServiceIP) == 0 {
+		k.DNSServiceIP = defaultDNSServiceIP
+	}
+	if len(k.PKI) == 0 {
+		k.PKI = "etc/pki"
+	}
+	return k, nil
+}
+
+func (cfg *iniConfig) newServeConfig() (*ServeConfig, error) {
+	v, err := cfg.newConfigFromSection("serve", &ServeConfig{})
+	if err != nil {
+		return nil, err
+	}
+
+	s := v.(*ServeConfig)
+	if len(s.TFTPRoot) == 0 {
+		s.TFTPRoot = "/var/lib/tftpboot"
+	}
+	if len(s.Assets) == 0 {
+		s.Assets = "/var/lib/matchbox/assets"
+	}
+	if len(s.ListenIP) == 0 {
+		s.ListenIP = "0.0.0.0"
+	}
+	if len(s.State) == 0 {
+		s.State = "_output/state/nodes.json"
+	}
+	return s, nil
+}
+
+func (cfg *iniConfig) newIgnitionConfig() (*IgnitionConfig, error) {
+	v, err := cfg.newConfigFromSection("ignition", &IgnitionConfig{})
+	if err != nil {
+		return nil, err
+	}
+
+	i := v.(*IgnitionConfig)
+	if len(i.Templates) == 0 {
+		i.Templates = "contrib/matchbox/ignition"
+	}
+	return i, nil
+}
+
+func (cfg *iniConfig) newUbuntuConfig() (*UbuntuConfig, error) {
+	v, err := cfg.newConfigFromSection("ubuntu", &UbuntuConfig{})
 	if err != nil {
 		return nil, err
 	}
 
-	return v.(*DHCPConfig), nil
+	u := v.(*UbuntuConfig)
+	if len(u.Version) == 0 {
+		u.Version = "22.04.4"
+	}
+	if len(u.Username) == 0 {
+		u.Username = "ubuntu"
+	}
+	switch u.Storage {
+	case "":
+		u.Storage = ubuntuLVMStorage
+	case ubuntuLVMStorage, ubuntuDirectStorage:
+	default:
+		return nil, errors.New("Storage layout of ubuntu is not correct: " + u.Storage)
+	}
+	return u, nil
+}
+
+func (cfg *iniConfig) newAssetsConfig() (*AssetsConfig, error) {
+	v, err := cfg.newConfigFromSection("assets", &AssetsConfig{})
+	if err != nil {
+		return nil, err
+	}
+
+	a := v.(*AssetsConfig)
+	if len(a.Dir) == 0 {
+		a.Dir = "contrib/matchbox/assets"
+	}
+	return a, nil
+}
+
+func (cfg *iniConfig) newVMConfig() (*VMConfig, error) {
+	v, err := cfg.newConfigFromSection("vm", &VMConfig{})
+	if err != nil {
+		return nil, err
+	}
+
+	vm := v.(*VMConfig)
+	if len(vm.Driver) == 0 {
+		vm.Driver = libvirtVMDriver
+	}
+	if len(vm.URI) == 0 {
+		vm.URI = "qemu:///system"
+	}
+	if len(vm.Pool) == 0 {
+		vm.Pool = "default"
+	}
+	if len(vm.Dir) == 0 {
+		vm.Dir = "_output/qemu"
+	}
+	if len(vm.OVMF) == 0 {
+		vm.OVMF = "/usr/share/ovmf/OVMF.fd"
+	}
+	return vm, nil
 }
 
 func (cfg *iniConfig) newVIPConfig() (*VIPConfig, error) {
@@ -131,11 +277,22 @@ type Config struct {
 	D     *DNSConfig
 	DHCP  *DHCPConfig
 	V     *VIPConfig
+	K     *KubernetesConfig
+	S     *ServeConfig
+	I     *IgnitionConfig
+	U     *UbuntuConfig
+	A     *AssetsConfig
+	VM    *VMConfig
 	Nodes []*Node
 	Cls   *Cluster
+
+	os   osProvider
+	dns  dnsBackend
+	dhcp dhcpBackend
 }
 
 type DefaultConfig struct {
+	OS         string   `ini:"os"`
 	Version    string   `ini:"version"`
 	Channel    string   `ini:"channel"`
 	DomainBase string   `ini:"domain_base"`
@@ -154,6 +311,22 @@ type NodeConfig struct {
 	Role    string   `ini:"role"`
 	IP      []string `ini:"ip"`
 	Profile string   `ini:"profile"`
+	// Firmware is bios or uefi, it decides boot file of the node
+	Firmware string `ini:"firmware"`
+	// Distro is installed on nodes which are not master or minion
+	Distro string `ini:"distro"`
+	// Memory in MiB, CPU count and Disk in GiB of vm node
+	Memory int `ini:"memory"`
+	CPU    int `ini:"cpu"`
+	Disk   int `ini:"disk"`
+	// BMC of physical node, driver is ipmi or redfish
+	BMCAddress  string `ini:"bmc_address"`
+	BMCDriver   string `ini:"bmc_driver"`
+	BMCUsername string `ini:"bmc_username"`
+	// BMCCredentials refers password by env:NAME or file:PATH
+	BMCCredentials string `ini:"bmc_credentials"`
+	// BMCInsecure skips verification of self-signed redfish certificate
+	BMCInsecure bool `ini:"bmc_insecure"`
 }
 
 type ContainerConfig struct {
@@ -161,20 +334,32 @@ type ContainerConfig struct {
 }
 
 type NetworkConfig struct {
-	Gateway       string   `ini:"gateway"`
-	IPs           []string `ini:"ips"`
-	DHCP_keep     int      `ini:"dhcp_keep"`
-	InterfaceBase string   `ini:"interface_base"`
+	Gateway         string   `ini:"gateway"`
+	IPs             []string `ini:"ips"`
+	DHCP_keep       []int    `ini:"dhcp_keep"`
+	DHCP_lease      []string `ini:"dhcp_lease"`
+	DHCP_interfaces []string `ini:"dhcp_interfaces"`
+	InterfaceBase   string   `ini:"interface_base"`
+	Allocation      string   `ini:"allocation"`
 }
 
 type DNSConfig struct {
-	DNS    []string `ini:"dns"`
-	Driver string   `ini:"driver"`
+	DNS         []string `ini:"dns"`
+	Driver      string   `ini:"driver"`
+	ServerIP    string   `ini:"server_ip"`
+	InjectHosts bool     `ini:"inject_hosts"`
 }
 
 type DHCPConfig struct {
-	Enable    bool   `ini:"enable"`
-	Interface string `ini:"interface"`
+	Enable     bool     `ini:"enable"`
+	Interface  []string `ini:"interface"`
+	Driver     string   `ini:"driver"`
+	NextServer string   `ini:"next_server"`
+	Listen     []string `ini:"listen"`
+	Lease      string   `ini:"lease"`
+	DNS        []string `ini:"dns"`
+	Search     []string `ini:"search"`
+	NTP        []string `ini:"ntp"`
 }
 
 type VIPConfig struct {
@@ -183,13 +368,33 @@ type VIPConfig struct {
 	Domain string `ini:"domain"`
 }
 
+type KubernetesConfig struct {
+	PodNetwork     string `ini:"pod_network"`
+	ServiceIPRange string `ini:"service_ip_range"`
+	DNSServiceIP   string `ini:"dns_service_ip"`
+	// PKI keeps cluster ca key and admin kubeconfig, it is never served
+	PKI string `ini:"pki"`
+}
+
 func Load(file string) (*Config, error) {
+	return LoadWithOverrides(file, nil)
+}
+
+// LoadWithOverrides loads config whose keys are replaced by overrides
+// before config is analyzed. Keys of overrides are section.key, they are
+// only kept in memory and file is never rewritten.
+func LoadWithOverrides(file string, overrides map[string]string) (*Config, error) {
 	cfg, err := loadINIConfig(file)
 	if err != nil {
 		log.Println("Load ini config failed:", err)
 		return nil, err
 	}
 
+	if err = cfg.override(overrides); err != nil {
+		log.Println("Override ini config failed:", err)
+		return nil, err
+	}
+
 	c := &Config{}
 
 	if c.DefaultConfig, err = cfg.newDefaultConfig(); err != nil {
@@ -227,6 +432,36 @@ func Load(file string) (*Config, error) {
 		return nil, err
 	}
 
+	if c.K, err = cfg.newKubernetesConfig(); err != nil {
+		log.Println("Load kubernetes config failed:", err)
+		return nil, err
+	}
+
+	if c.S, err = cfg.newServeConfig(); err != nil {
+		log.Println("Load serve config failed:", err)
+		return nil, err
+	}
+
+	if c.I, err = cfg.newIgnitionConfig(); err != nil {
+		log.Println("Load ignition config failed:", err)
+		return nil, err
+	}
+
+	if c.U, err = cfg.newUbuntuConfig(); err != nil {
+		log.Println("Load ubuntu config failed:", err)
+		return nil, err
+	}
+
+	if c.A, err = cfg.newAssetsConfig(); err != nil {
+		log.Println("Load assets config failed:", err)
+		return nil, err
+	}
+
+	if c.VM, err = cfg.newVMConfig(); err != nil {
+		log.Println("Load vm config failed:", err)
+		return nil, err
+	}
+
 	if c.Nodes, err = cfg.newNodes(c.NodeIDs); err != nil {
 		log.Println("Load nodes failed:", err)
 		return nil, err
@@ -234,6 +469,7 @@ func Load(file string) (*Config, error) {
 
 	c.Cls = &Cluster{
 		M: c.M,
+		K: c.K,
 	}
 
 	if err = c.analyze(); err != nil {
@@ -244,12 +480,18 @@ func Load(file string) (*Config, error) {
 }
 
 func (c *Config) analyze() (err error) {
+	if err = c.analyzeOS(); err != nil {
+		return errors.New("Analyze os failed: " + err.Error())
+	}
 	if err = c.analyzeNetwork(); err != nil {
 		return errors.New("Analyze network failed: " + err.Error())
 	}
 	if err = c.analyzeMatchbox(); err != nil {
 		return errors.New("Analyze matchbox failed: " + err.Error())
 	}
+	if err = c.analyzeKubernetes(); err != nil {
+		return errors.New("Analyze kubernetes failed: " + err.Error())
+	}
 	if err = c.analyzeNodes(); err != nil {
 		return errors.New("Analyze nodes failed: " + err.Error())
 	}
@@ -259,10 +501,26 @@ func (c *Config) analyze() (err error) {
 	if err = c.analyzeCluster(); err != nil {
 		return errors.New("Analyze cluster failed: " + err.Error())
 	}
+	if err = c.analyzeDNS(); err != nil {
+		return errors.New("Analyze dns failed: " + err.Error())
+	}
+	if err = c.analyzeDHCP(); err != nil {
+		return errors.New("Analyze dhcp failed: " + err.Error())
+	}
 	return nil
 }
 
+func (c *Config) analyzeOS() (err error) {
+	c.os, err = newOSProvider(c.OS)
+	return err
+}
+
 func (c *Config) analyzeNetwork() error {
+	// dhcp lease is the default lease of pools
+	if len(c.N.DHCP_lease) == 0 && len(c.DHCP.Lease) != 0 {
+		c.N.DHCP_lease = []string{c.DHCP.Lease}
+	}
+
 	n, err := newNetwork(c.N)
 	if err != nil {
 		return err
@@ -277,6 +535,27 @@ func (c *Config) analyzeMatchbox() error {
 	return nil
 }
 
+func (c *Config) analyzeKubernetes() error {
+	_, podNet, err := net.ParseCIDR(c.K.PodNetwork)
+	if err != nil {
+		return errors.New("Pod network format is not correct: " + c.K.PodNetwork)
+	}
+
+	_, serviceNet, err := net.ParseCIDR(c.K.ServiceIPRange)
+	if err != nil {
+		return errors.New("Service ip range format is not correct: " + c.K.ServiceIPRange)
+	}
+
+	if podNet.Contains(serviceNet.IP) || serviceNet.Contains(podNet.IP) {
+		return errors.New("Pod network overlaps with service ip range")
+	}
+
+	if !serviceNet.Contains(net.ParseIP(c.K.DNSServiceIP)) {
+		return errors.New("DNS service ip is not in service ip range: " + c.K.DNSServiceIP)
+	}
+	return nil
+}
+
 func (c *Config) analyzeNodes() error {
 	for i, node := range c.Nodes {
 		node.ID = c.NodeIDs[i]
@@ -285,10 +564,85 @@ func (c *Config) analyzeNodes() error {
 			node.Domain = node.Domain + "." + c.DomainBase
 		}
 
+		if node.Memory < 0 || node.CPU < 0 || node.Disk < 0 {
+			return errors.New("Memory, cpu and disk of node " + node.ID + " should not be negative")
+		}
+		if node.Memory == 0 {
+			node.Memory = defaultNodeMemory
+		}
+		if node.CPU == 0 {
+			node.CPU = defaultNodeCPU
+		}
+		if node.Disk == 0 {
+			node.Disk = defaultNodeDisk
+		}
+
+		switch node.Distro {
+		case "":
+		case ubuntuDistro:
+			if node.Role == "master" || node.Role == "minion" {
+				return errors.New("Distro of node " + node.ID + " is only for nodes which are not master or minion")
+			}
+			if len(node.Profile) == 0 {
+				node.Profile = ubuntuProfileID(node)
+			}
+		default:
+			return errors.New("Distro of node " + node.ID + " is not correct: " + node.Distro)
+		}
+
 		if len(node.Profile) == 0 {
 			node.Profile = "node"
 		}
 
+		switch node.Firmware {
+		case "":
+			node.Firmware = biosFirmware
+		case biosFirmware, uefiFirmware:
+		default:
+			return errors.New("Firmware of node " + node.ID + " is not correct: " + node.Firmware)
+		}
+
+		switch node.BMCDriver {
+		case "":
+		case ipmiBMCDriver, redfishBMCDriver:
+			if len(node.BMCAddress) == 0 {
+				return errors.New("BMC address of node " + node.ID + " is required by bmc driver")
+			}
+		default:
+			return errors.New("BMC driver of node " + node.ID + " is not correct: " + node.BMCDriver)
+		}
+	}
+
+	for _, e := range c.excludedIPs() {
+		c.Cls.excludeIP(e.IP, e.Reason)
+	}
+
+	// Static IPs must be registered before any dynamic allocation,
+	// otherwise the allocator may hand them out again.
+	for _, node := range c.Nodes {
+		for i := range node.MAC {
+			ip, ok := node.staticIP(c, i)
+			if !ok {
+				continue
+			}
+
+			if err := c.Cls.reserveIP(ip, i, node.ID); err != nil {
+				log.Println("Reserve static ip failed: ", err)
+				return err
+			}
+		}
+	}
+
+	nodes := c.Nodes
+	if c.N.Allocation == hashAllocation {
+		// Probing result depends on allocation order, so allocate with
+		// sorted node ids to keep ips independent of nodes ordering.
+		nodes = make([]*Node, len(c.Nodes))
+		copy(nodes, c.Nodes)
+		sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	}
+
+	for _, node := range nodes {
 		nics, err := node.makeInterfaces(c)
 		if err != nil {
 			log.Println("Make interfaces failed: ", err)
@@ -337,9 +691,11 @@ func (c *Config) analyzeCluster() error {
 	var controllerEndpoint string
 	initialCluster := make([]string, 0, len(c.Nodes))
 	endpoints := make([]string, 0, len(c.Nodes))
+	masters := make([]string, 0, len(c.Nodes))
 
 	for _, n := range c.Nodes {
 		if n.Role == "master" {
+			masters = append(masters, n.Domain)
 			initialCluster = append(initialCluster, fmt.Sprintf("%s=http://%s:2380", n.ID, n.Domain))
 			endpoints = append(endpoints, fmt.Sprintf("http://%s:2379", n.Domain))
 			if len(controllerEndpoint) == 0 {
@@ -360,37 +716,39 @@ func (c *Config) analyzeCluster() error {
 
 	c.Cls.InitialCluster = strings.Join(initialCluster, ",")
 	c.Cls.Endpoints = strings.Join(endpoints, ",")
+	c.Cls.Masters = masters
+	c.Cls.DomainBase = c.DomainBase
 	c.Cls.ControllerEndpoint = controllerEndpoint
 	c.Cls.AuthorizedKeys = string(bs)
 	c.Cls.Registries = c.C.Registries
 	return nil
 }
 
+func (c *Config) analyzeDNS() (err error) {
+	if c.D.InjectHosts {
+		c.Cls.EtcHosts = c.dnsRecords().hostsLines()
+	}
+
+	c.dns, err = newDNSBackend(c.D.Driver)
+	return err
+}
+
+func (c *Config) analyzeDHCP() (err error) {
+	c.dhcp, err = newDHCPBackend(c.DHCP.Driver)
+	return err
+}
+
 func (c *Config) Generate(outputPath string) error {
 	err := os.MkdirAll(outputPath, 0744)
 	if err != nil {
 		log.Fatal("Make output path ", outputPath, "fail")
 	}
-	err = writeTemplateToFile(OS_INSTALL_TMPL, "install",
-		filepath.Join(outputPath, "install.json"), c)
-	if err != nil {
-		log.Println("Write template install failed: ", err)
+	if err = c.generateOS(outputPath); err != nil {
+		log.Println("Write", c.OS, "install config failed: ", err)
 	}
 
 	for _, n := range c.Nodes {
-		var tmpl, name string
-		switch n.Role {
-		case "master":
-			tmpl = K8S_CONTROLLER_TMPL
-			name = "controller"
-		case "minion":
-			tmpl = K8S_WORKER_TMPL
-			name = "worker"
-		default:
-			tmpl = NODE_TMPL
-			name = "Node " + n.ID
-		}
-
+		tmpl, name := n.groupTemplate()
 		err = writeTemplateToFile(tmpl, name,
 			filepath.Join(outputPath, n.ID+".json"), n)
 		if err != nil {
@@ -403,5 +761,34 @@ func (c *Config) Generate(outputPath string) error {
 	if err != nil {
 		log.Println("Write dnsmasq config failed: ", err)
 	}
+
+	dr, err := c.dhcpRecords()
+	if err == nil {
+		err = c.dhcp.generate(outputPath, dr)
+	}
+	if err != nil {
+		log.Println("Write", c.DHCP.Driver, "dhcp config failed: ", err)
+	}
+
+	records := c.dnsRecords()
+	if err = c.dns.generate(outputPath, records); err != nil {
+		log.Println("Write", c.D.Driver, "dns config failed: ", err)
+	}
+
+	err = writeTemplateToFile(HOSTS_TMPL, "hosts",
+		filepath.Join(outputPath, "hosts"), records.hostsLines())
+	if err != nil {
+		log.Println("Write hosts file failed: ", err)
+	}
+
+	if err = c.generateUbuntu(outputPath); err != nil {
+		log.Println("Write ubuntu autoinstall failed: ", err)
+	}
+
+	if c.I.Render {
+		if err = c.generateIgnition(outputPath); err != nil {
+			return errors.New("Write ignition failed: " + err.Error())
+		}
+	}
 	return nil
 }
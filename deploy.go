@@ -0,0 +1,433 @@
+package lazy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	deployValidate = "validate"
+	deployCerts    = "certs"
+	deployAssets   = "assets"
+	deployGenerate = "generate"
+	deployServe    = "serve"
+	deployPower    = "power"
+	deployWait     = "wait"
+)
+
+type DeployOptions struct {
+	// ConfigFile is checked whether config is changed since last deploy
+	ConfigFile  string
+	OutputPath  string
+	MatchboxDir string
+	// Progress is file of deploy progress record
+	Progress string
+	// Overrides are keys of config which are resolved in memory, like ip
+	// of matchbox container. They are part of config checksum.
+	Overrides map[string]string
+	// SSHKey is public key file which is authorized when keys of config
+	// are empty, it is never written into config.
+	SSHKey   string
+	Timeout  time.Duration
+	FromStep string
+	Only     []string
+}
+
+type deployStep struct {
+	name string
+	run  func(d *deployer) error
+	// rerun step runs again before later steps, even though it is done
+	rerun bool
+}
+
+type deployer struct {
+	c    *Config
+	opts *DeployOptions
+}
+
+var deploySteps = []deployStep{
+	{deployValidate, (*deployer).validate, false},
+	{deployCerts, func(d *deployer) error { return d.c.GenerateCerts() }, false},
+	{deployAssets, func(d *deployer) error { return d.c.FetchAssets() }, false},
+	{deployGenerate, func(d *deployer) error { return d.c.Generate(d.opts.OutputPath) }, false},
+	// servers only live in deploy process, so they are served again
+	// whenever later steps are resumed
+	{deployServe, (*deployer).serve, true},
+	{deployPower, (*deployer).power, false},
+	{deployWait, (*deployer).wait, false},
+}
+
+type deployProgress struct {
+	// Config is checksum of ini config of deploy
+	Config string                       `json:"config"`
+	Steps  map[string]*deployStepRecord `json:"steps"`
+}
+
+type deployStepRecord struct {
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Error    string    `json:"error,omitempty"`
+}
+
+func (r *deployStepRecord) done() bool {
+	return r != nil && !r.Finished.IsZero() && len(r.Error) == 0
+}
+
+func loadDeployProgress(file string) (*deployProgress, error) {
+	p := &deployProgress{}
+	bs, err := ioutil.ReadFile(file)
+	if err == nil {
+		err = json.Unmarshal(bs, p)
+		if err != nil {
+			return nil, errors.New("Deploy progress " + file + " is not correct: " + err.Error())
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if p.Steps == nil {
+		p.Steps = make(map[string]*deployStepRecord)
+	}
+	return p, nil
+}
+
+func (p *deployProgress) write(file string) error {
+	bs, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, append(bs, '\n'), 0644)
+}
+
+// deployPlan returns steps which should be run. Steps of only are run
+// alone, otherwise steps are run from from step, or resumed from the
+// first step which is not done. Rerun steps before planned steps are
+// always planned.
+func deployPlan(steps []deployStep, p *deployProgress, from string, only []string) ([]deployStep, error) {
+	plan, err := selectDeploySteps(steps, p, from, only)
+	if err != nil || len(plan) == 0 {
+		return plan, err
+	}
+
+	planned := make(map[string]bool)
+	for _, s := range plan {
+		planned[s.name] = true
+	}
+
+	last := 0
+	for i, s := range steps {
+		if planned[s.name] {
+			last = i
+		}
+	}
+
+	withRerun := make([]deployStep, 0, len(steps))
+	for i, s := range steps {
+		if planned[s.name] || (s.rerun && i < last) {
+			withRerun = append(withRerun, s)
+		}
+	}
+	return withRerun, nil
+}
+
+func selectDeploySteps(steps []deployStep, p *deployProgress, from string, only []string) ([]deployStep, error) {
+	index := make(map[string]int)
+	for i, s := range steps {
+		index[s.name] = i
+	}
+
+	if len(only) != 0 {
+		selected := make(map[string]bool)
+		for _, name := range only {
+			if _, ok := index[name]; !ok {
+				return nil, errors.New("Unknown deploy step: " + name)
+			}
+			selected[name] = true
+		}
+
+		plan := make([]deployStep, 0, len(only))
+		for _, s := range steps {
+			if selected[s.name] {
+				plan = append(plan, s)
+			}
+		}
+		return plan, nil
+	}
+
+	if len(from) != 0 {
+		i, ok := index[from]
+		if !ok {
+			return nil, errors.New("Unknown deploy step: " + from)
+		}
+		return steps[i:], nil
+	}
+
+	for i, s := range steps {
+		if !p.Steps[s.name].done() {
+			return steps[i:], nil
+		}
+	}
+	return nil, nil
+}
+
+// Deploy runs ordered deploy steps, progress of every step is recorded,
+// so failed deploy is resumed from the failed step. Config file is only
+// read.
+func (c *Config) Deploy(opts *DeployOptions) error {
+	return c.runDeploy(deploySteps, opts)
+}
+
+// deployChecksum returns checksum of config file and overrides, so steps
+// are deployed again when either is changed.
+func deployChecksum(opts *DeployOptions) (string, error) {
+	checksum, _, err := sha256File(opts.ConfigFile)
+	if err != nil || len(opts.Overrides) == 0 {
+		return checksum, err
+	}
+
+	keys := make([]string, 0, len(opts.Overrides))
+	for k := range opts.Overrides {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	io.WriteString(h, checksum)
+	for _, k := range keys {
+		io.WriteString(h, "\n"+k+"="+opts.Overrides[k])
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (c *Config) runDeploy(steps []deployStep, opts *DeployOptions) error {
+	checksum, err := deployChecksum(opts)
+	if err != nil {
+		return err
+	}
+
+	p, err := loadDeployProgress(opts.Progress)
+	if err != nil {
+		return err
+	}
+	if len(p.Config) != 0 && p.Config != checksum {
+		log.Println("Config is changed since last deploy, deploy from the beginning")
+		p.Steps = make(map[string]*deployStepRecord)
+	}
+	p.Config = checksum
+
+	plan, err := deployPlan(steps, p, opts.FromStep, opts.Only)
+	if err != nil {
+		return err
+	}
+	if len(plan) == 0 {
+		log.Println("All deploy steps are done, use --from-step to deploy again")
+		return nil
+	}
+
+	d := &deployer{c: c, opts: opts}
+	if err = d.authorizeKey(); err != nil {
+		return err
+	}
+
+	for _, s := range plan {
+		log.Println("Deploy step", s.name, "started")
+		r := &deployStepRecord{Started: time.Now()}
+		p.Steps[s.name] = r
+		if err = p.write(opts.Progress); err != nil {
+			return err
+		}
+
+		err = s.run(d)
+		r.Finished = time.Now()
+		if err != nil {
+			r.Error = err.Error()
+		}
+		if werr := p.write(opts.Progress); err == nil {
+			err = werr
+		}
+		if err != nil {
+			return errors.New("Deploy step " + s.name + " failed: " + err.Error())
+		}
+		log.Println("Deploy step", s.name, "done in", r.Finished.Sub(r.Started).Round(time.Millisecond))
+	}
+	return nil
+}
+
+// authorizeKey authorizes ssh key of options when config has no key, key
+// is only kept in memory. It runs before any step, so resumed steps which
+// render config get the key too.
+func (d *deployer) authorizeKey() error {
+	c := d.c
+	if len(c.Keys) != 0 || len(d.opts.SSHKey) == 0 {
+		return nil
+	}
+
+	bs, err := ioutil.ReadFile(d.opts.SSHKey)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if key := strings.TrimSpace(string(bs)); len(key) != 0 {
+		log.Println("Authorize ssh key", d.opts.SSHKey)
+		c.Keys = append(c.Keys, key)
+		bs, err = json.Marshal(c.Keys)
+		if err != nil {
+			return err
+		}
+		c.Cls.AuthorizedKeys = string(bs)
+	}
+	return nil
+}
+
+// validate checks config needed by deploy steps before they run. Signing
+// key is checked by assets step, so steps without assets need no key.
+func (d *deployer) validate() error {
+	c := d.c
+	if len(c.Keys) == 0 {
+		return errors.New("No ssh key is authorized, set keys of DEFAULT section or generate " + d.opts.SSHKey)
+	}
+
+	if len(c.M.URL) == 0 {
+		return errors.New("Url of matchbox section is required")
+	}
+
+	for _, n := range c.Nodes {
+		if len(n.BMCDriver) != 0 {
+			if _, err := newBMCDriver(n); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := newVMBackend(c); err != nil {
+			return errors.New("Node " + n.ID + " without bmc needs vm driver: " + err.Error())
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// matchboxConfigGlobs returns globs of generated groups and profiles
+// under output path by their folder of matchbox data dir.
+func matchboxConfigGlobs(outputPath string) map[string]string {
+	return map[string]string{
+		"groups":   filepath.Join(outputPath, "*.json"),
+		"profiles": filepath.Join(outputPath, profileOutputDir, "*.json"),
+	}
+}
+
+// applyMatchbox copies generated groups and profiles into matchbox data
+// dir.
+func (d *deployer) applyMatchbox() error {
+	for dir, pattern := range matchboxConfigGlobs(d.opts.OutputPath) {
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return err
+		}
+
+		dest := filepath.Join(d.opts.MatchboxDir, dir)
+		if err = os.MkdirAll(dest, 0755); err != nil {
+			return err
+		}
+		for _, file := range files {
+			if err = copyFile(file, filepath.Join(dest, filepath.Base(file))); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// serve applies generated config into matchbox and runs servers enabled
+// by serve section in background, they live until deploy returns.
+func (d *deployer) serve() error {
+	if err := d.applyMatchbox(); err != nil {
+		return err
+	}
+
+	s := d.c.S
+	if !s.ProxyDHCP && !s.TFTP && len(s.HTTP) == 0 {
+		log.Println("Serve section is disabled, matchbox and dhcp should be served by others")
+		return nil
+	}
+
+	errc := make(chan error, 1)
+	go func() { errc <- d.c.Serve() }()
+	select {
+	case err := <-errc:
+		return err
+	case <-time.After(time.Second):
+	}
+
+	go func() { log.Println("Serve failed:", <-errc) }()
+	return nil
+}
+
+// power resets states of nodes, then boots nodes with bmc from network
+// and creates other nodes as vm.
+func (d *deployer) power() error {
+	c := d.c
+	if err := c.ResetNodeStates(nil); err != nil {
+		return err
+	}
+
+	var vb vmBackend
+	for _, n := range c.Nodes {
+		if len(n.BMCDriver) != 0 {
+			if err := c.RunPower(powerPXEOnce, []string{n.ID}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if vb == nil {
+			b, err := newVMBackend(c)
+			if err != nil {
+				return err
+			}
+			vb = b
+		}
+		if err := c.runVM(vb, vmCreate, []string{n.ID}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wait waits for nodes to be installed, stages of nodes are only tracked
+// by http server of serve section, so it is skipped without the server.
+func (d *deployer) wait() error {
+	if len(d.c.S.HTTP) == 0 {
+		log.Println("Http of serve section is disabled, stages of nodes are not tracked, skip waiting nodes")
+		return nil
+	}
+	return d.c.WaitNodes(stageInstalled, d.opts.Timeout, nil)
+}
@@ -0,0 +1,213 @@
+package lazy
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunDeploy(t *testing.T) {
+	dir := t.TempDir()
+	c := loadTestConfig(t, testINIConfig)
+	opts := &DeployOptions{
+		ConfigFile: filepath.Join(dir, "lazy.ini"),
+		Progress:   filepath.Join(dir, "state", "deploy.json"),
+	}
+	writeTestFile(t, opts.ConfigFile, testINIConfig)
+
+	writeTestFile(t, filepath.Join(dir, "id_rsa.pub"), "ssh-rsa AAAA test@lazykube\n")
+	opts.SSHKey = filepath.Join(dir, "id_rsa.pub")
+
+	var runs []string
+	fail := "power"
+	steps := make([]deployStep, 0, len(deploySteps))
+	for _, s := range deploySteps {
+		name := s.name
+		steps = append(steps, deployStep{name, func(d *deployer) error {
+			runs = append(runs, name)
+			if name == deployGenerate && d.c.Cls.AuthorizedKeys != `["ssh-rsa AAAA test@lazykube"]` {
+				t.Fatal("Ssh key should be authorized before generate, got", d.c.Cls.AuthorizedKeys)
+			}
+			if name == fail {
+				return errors.New("boom")
+			}
+			return nil
+		}, s.rerun})
+	}
+
+	deploy := func(expected string, ok bool) {
+		runs = nil
+		err := c.runDeploy(steps, opts)
+		if (err == nil) != ok {
+			t.Fatalf("Deploy error is not expected: %v", err)
+		}
+		if strings.Join(runs, ",") != expected {
+			t.Fatalf("Deploy should run %s, got %v", expected, runs)
+		}
+	}
+
+	deploy("validate,certs,assets,generate,serve,power", false)
+	p, err := loadDeployProgress(opts.Progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Steps[deployServe].done() || p.Steps[deployPower].done() || p.Steps[deployPower].Error != "boom" {
+		t.Fatalf("Progress of failed deploy is not correct: %+v", p.Steps)
+	}
+
+	// servers of serve are run again for resumed steps
+	fail = ""
+	deploy("serve,power,wait", true)
+	deploy("", true)
+
+	opts.Only = []string{"wait", "certs"}
+	deploy("certs,serve,wait", true)
+	opts.Only = []string{"validate", "generate"}
+	deploy("validate,generate", true)
+	opts.Only = nil
+
+	// resumed generate gets ssh key without validate step
+	c = loadTestConfig(t, testINIConfig)
+	opts.FromStep = "generate"
+	deploy("generate,serve,power,wait", true)
+
+	opts.FromStep = "serve"
+	deploy("serve,power,wait", true)
+	opts.FromStep = "wait"
+	deploy("serve,wait", true)
+	opts.FromStep = "reboot"
+	deploy("", false)
+	opts.FromStep = ""
+
+	// changed config is deployed from the beginning
+	if err = ioutil.WriteFile(opts.ConfigFile, []byte(testINIConfig+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	deploy("validate,certs,assets,generate,serve,power,wait", true)
+
+	bs, err := ioutil.ReadFile(opts.ConfigFile)
+	if err != nil || string(bs) != testINIConfig+"\n" {
+		t.Fatal("Config file should not be rewritten by deploy")
+	}
+}
+
+func TestLoadWithOverrides(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "lazy.ini")
+	writeTestFile(t, file, testINIConfig)
+
+	c, err := LoadWithOverrides(file, map[string]string{"matchbox.ip": "172.17.0.9", "dns.dns": "172.17.0.3,8.8.8.8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.M.IP != "172.17.0.9" || strings.Join(c.D.DNS, ",") != "172.17.0.3,8.8.8.8" {
+		t.Fatalf("Config should be overridden, got %s %v", c.M.IP, c.D.DNS)
+	}
+
+	// matchbox ip is resolved before config is analyzed
+	found := false
+	for _, a := range c.dnsRecords().Addresses {
+		found = found || (a.Name == "matchbox.com" && a.IP == "172.17.0.9")
+	}
+	if !found {
+		t.Fatal("Dns record of matchbox should be overridden ip")
+	}
+
+	if bs, _ := ioutil.ReadFile(file); string(bs) != testINIConfig {
+		t.Fatal("Config file should not be rewritten")
+	}
+	if _, err = LoadWithOverrides(file, map[string]string{"ip": "172.17.0.9"}); err == nil {
+		t.Fatal("Override without section should fail")
+	}
+}
+
+func TestDeployChecksum(t *testing.T) {
+	opts := &DeployOptions{ConfigFile: filepath.Join(t.TempDir(), "lazy.ini")}
+	writeTestFile(t, opts.ConfigFile, testINIConfig)
+
+	plain, err := deployChecksum(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.Overrides = map[string]string{"matchbox.ip": "172.17.0.9"}
+	first, _ := deployChecksum(opts)
+	opts.Overrides["matchbox.ip"] = "172.17.0.10"
+	second, _ := deployChecksum(opts)
+	if plain == first || first == second {
+		t.Fatal("Checksum should be changed by overrides")
+	}
+}
+
+func TestDeployValidate(t *testing.T) {
+	dir := t.TempDir()
+	content := strings.Replace(testINIConfig, "[work1]", "[work1]\nbmc_driver=ipmi\nbmc_address=10.0.0.5", 1)
+	c := loadTestConfig(t, content)
+	d := &deployer{c: c, opts: &DeployOptions{SSHKey: filepath.Join(dir, "id_rsa.pub")}}
+
+	if err := d.validate(); err == nil {
+		t.Fatal("Deploy without ssh key should fail")
+	}
+
+	writeTestFile(t, d.opts.SSHKey, "ssh-rsa AAAA test@lazykube\n")
+	if err := d.authorizeKey(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Cls.AuthorizedKeys != `["ssh-rsa AAAA test@lazykube"]` {
+		t.Fatal("Ssh key should be authorized, got", c.Cls.AuthorizedKeys)
+	}
+	// signing key is only needed by assets step
+	c.OS = "unknown"
+	if err := d.validate(); err != nil {
+		t.Fatal("Deploy without signing key should be validated, got", err)
+	}
+
+	c.VM.Driver = "hyperv"
+	if err := d.validate(); err == nil || !strings.Contains(err.Error(), "ctl1") {
+		t.Fatal("Node without bmc should need vm driver, got", err)
+	}
+}
+
+func TestApplyMatchbox(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "_output")
+	writeTestFile(t, filepath.Join(output, "ctl1.json"), "{}")
+	writeTestFile(t, filepath.Join(output, "dnsmasq.conf"), "")
+	writeTestFile(t, filepath.Join(output, profileOutputDir, "install-reboot.json"), "{}")
+	writeTestFile(t, filepath.Join(output, "state", "nodes.json"), "{}")
+
+	d := &deployer{opts: &DeployOptions{OutputPath: output, MatchboxDir: filepath.Join(dir, "matchbox")}}
+	if err := d.applyMatchbox(); err != nil {
+		t.Fatal(err)
+	}
+
+	for file, exist := range map[string]bool{
+		"groups/ctl1.json":             true,
+		"profiles/install-reboot.json": true,
+		"groups/dnsmasq.conf":          false,
+		"groups/nodes.json":            false,
+	} {
+		_, err := os.Stat(filepath.Join(dir, "matchbox", filepath.FromSlash(file)))
+		if (err == nil) != exist {
+			t.Fatalf("Existence of %s should be %v", file, exist)
+		}
+	}
+}
+
+func TestDeployWaitWithoutHTTP(t *testing.T) {
+	c := loadTestConfig(t, testINIConfig)
+	c.S.State = filepath.Join(t.TempDir(), "nodes.json")
+	d := &deployer{c: c, opts: &DeployOptions{Timeout: time.Millisecond}}
+	if err := d.wait(); err != nil {
+		t.Fatal("Wait should be skipped without http of serve, got", err)
+	}
+
+	defer func(i time.Duration) { waitInterval = i }(waitInterval)
+	waitInterval = time.Millisecond
+	c.S.HTTP = ":8080"
+	if err := d.wait(); err == nil {
+		t.Fatal("Wait should fail when nodes are not installed")
+	}
+}
@@ -0,0 +1,361 @@
+package lazy
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	dnsmasqDHCPDriver = "dnsmasq"
+	dhcpdDHCPDriver   = "dhcpd"
+	keaDHCPDriver     = "kea"
+
+	dhcpOutputDir = "dhcp"
+	// iPXE firmware served by tftp for legacy pxe clients
+	ipxeBootFile = "undionly.kpxe"
+	// iPXE firmware served by tftp or http for uefi clients
+	ipxeEFIBootFile = "ipxe.efi"
+	// UEFI http boot clients need vendor class "HTTPClient" in reply
+	httpClientVendorClass = "HTTPClient"
+	// iPXE sets dhcp option 77 (user class) to "iPXE"
+	ipxeUserClass = "iPXE"
+	// infiniteLease is the lease seconds of "infinite" lease time
+	infiniteLease = 4294967295
+)
+
+type dhcpBackend interface {
+	// generate writes config files of the dhcp server into output path
+	generate(outputPath string, r *dhcpRecords) error
+}
+
+func newDHCPBackend(driver string) (dhcpBackend, error) {
+	switch driver {
+	case dnsmasqDHCPDriver:
+		return dnsmasqDHCP{}, nil
+	case dhcpdDHCPDriver:
+		return dhcpdDHCP{}, nil
+	case keaDHCPDriver:
+		return keaDHCP{}, nil
+	}
+	return nil, errors.New("Unknown dhcp driver: " + driver)
+}
+
+type dhcpSubnet struct {
+	dhcpRange
+	LeaseSeconds int64
+}
+
+type dhcpHost struct {
+	// Name is unique for each interface
+	Name         string
+	Hostname     string
+	MAC          string
+	IP           string
+	Lease        string
+	LeaseSeconds int64
+	// UEFI is true when node firmware is uefi, these hosts get
+	// EFIBootFile even if the client arch is unknown.
+	UEFI bool
+}
+
+// dhcpRecords is the backend independent view of cluster dhcp.
+type dhcpRecords struct {
+	Subnets []dhcpSubnet
+	Hosts   []dhcpHost
+	// BootFile is served by tftp to clients which are not iPXE yet
+	BootFile string
+	// EFIBootFile is served by tftp to uefi clients (arch 7 and 9)
+	EFIBootFile string
+	// HTTPBootURL is iPXE firmware of uefi http boot clients (arch 16)
+	HTTPBootURL string
+	// BootURL is the iPXE script which iPXE clients chainload
+	BootURL    string
+	NextServer string
+	Listen     []string
+	DNSServers []string
+	Search     []string
+	NTPServers []string
+}
+
+func (c *Config) dhcpRecords() (*dhcpRecords, error) {
+	r := &dhcpRecords{
+		BootFile:    ipxeBootFile,
+		EFIBootFile: ipxeEFIBootFile,
+		// matchbox serves assets directory which holds tftpboot
+		HTTPBootURL: c.M.URL + "/assets/tftpboot/" + ipxeEFIBootFile,
+		BootURL:     c.M.URL + "/boot.ipxe",
+		NextServer:  c.DHCP.NextServer,
+		Listen:      c.DHCP.Listen,
+		DNSServers:  c.DHCP.DNS,
+		Search:      c.DHCP.Search,
+		NTPServers:  c.DHCP.NTP,
+	}
+	if len(r.NextServer) == 0 {
+		r.NextServer = c.M.IP
+	}
+
+	for _, dr := range c.Cls.GetDHCPRanges() {
+		secs, err := parseLease(dr.Lease)
+		if err != nil {
+			return nil, err
+		}
+		r.Subnets = append(r.Subnets, dhcpSubnet{dr, secs})
+	}
+
+	for _, n := range c.Nodes {
+		for _, nic := range n.Nics {
+			lease := c.Cls.LeaseOf(nic.IP)
+			secs, err := parseLease(lease)
+			if err != nil {
+				return nil, err
+			}
+
+			r.Hosts = append(r.Hosts, dhcpHost{
+				Name:         n.ID + "-" + nic.Interface,
+				Hostname:     n.ID,
+				MAC:          nic.MAC,
+				IP:           nic.IP,
+				Lease:        lease,
+				LeaseSeconds: secs,
+				UEFI:         n.Firmware == uefiFirmware,
+			})
+		}
+	}
+	return r, nil
+}
+
+func (r *dhcpRecords) hostByMAC(mac string) *dhcpHost {
+	for i := range r.Hosts {
+		if strings.EqualFold(r.Hosts[i].MAC, mac) {
+			return &r.Hosts[i]
+		}
+	}
+	return nil
+}
+
+// bootFile chooses boot file in the same way as generated dhcp configs,
+// httpBoot is true when the file is uefi http boot url.
+func (r *dhcpRecords) bootFile(h *dhcpHost, arch uint16, hasArch, ipxe bool) (file string, httpBoot bool) {
+	switch {
+	case ipxe:
+		return r.BootURL, false
+	case hasArch && arch == 16:
+		return r.HTTPBootURL, true
+	case hasArch && (arch == 7 || arch == 9), h != nil && h.UEFI:
+		return r.EFIBootFile, false
+	}
+	return r.BootFile, false
+}
+
+// parseLease converts dnsmasq lease time, such as 45m, 1h, 2d or
+// infinite, into seconds.
+func parseLease(lease string) (int64, error) {
+	if lease == "infinite" {
+		return infiniteLease, nil
+	}
+
+	unit := int64(1)
+	num := lease
+	if len(lease) != 0 {
+		switch lease[len(lease)-1] {
+		case 's':
+			num = lease[:len(lease)-1]
+		case 'm':
+			unit, num = 60, lease[:len(lease)-1]
+		case 'h':
+			unit, num = 3600, lease[:len(lease)-1]
+		case 'd':
+			unit, num = 86400, lease[:len(lease)-1]
+		case 'w':
+			unit, num = 604800, lease[:len(lease)-1]
+		}
+	}
+
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, errors.New("Lease time format is not correct: " + lease)
+	}
+	return n * unit, nil
+}
+
+// dnsmasqDHCP appends dhcp section into dnsmasq.conf, so it should be
+// generated after dnsmasq.conf.
+type dnsmasqDHCP struct{}
+
+func (dnsmasqDHCP) generate(outputPath string, r *dhcpRecords) error {
+	return appendTemplateToFile(DNSMASQ_DHCP_TMPL, "dnsmasq dhcp",
+		filepath.Join(outputPath, "dnsmasq.conf"), r)
+}
+
+type dhcpdDHCP struct{}
+
+func (dhcpdDHCP) generate(outputPath string, r *dhcpRecords) error {
+	dir := filepath.Join(outputPath, dhcpOutputDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	return writeTemplateToFile(DHCPD_TMPL, "dhcpd",
+		filepath.Join(dir, "dhcpd.conf"), r)
+}
+
+type keaConfig struct {
+	Dhcp4 keaDhcp4 `json:"Dhcp4"`
+}
+
+type keaDhcp4 struct {
+	InterfacesConfig keaInterfaces   `json:"interfaces-config"`
+	LeaseDatabase    keaDatabase     `json:"lease-database"`
+	ClientClasses    []keaClass      `json:"client-classes"`
+	Subnet4          []keaSubnet     `json:"subnet4"`
+	OptionData       []keaOptionData `json:"option-data,omitempty"`
+}
+
+type keaInterfaces struct {
+	Interfaces []string `json:"interfaces"`
+}
+
+type keaDatabase struct {
+	Type string `json:"type"`
+}
+
+type keaClass struct {
+	Name         string          `json:"name"`
+	Test         string          `json:"test"`
+	NextServer   string          `json:"next-server,omitempty"`
+	BootFileName string          `json:"boot-file-name"`
+	OptionData   []keaOptionData `json:"option-data,omitempty"`
+}
+
+type keaSubnet struct {
+	ID            int              `json:"id"`
+	Subnet        string           `json:"subnet"`
+	Interface     string           `json:"interface,omitempty"`
+	Pools         []keaPool        `json:"pools"`
+	ValidLifetime int64            `json:"valid-lifetime"`
+	OptionData    []keaOptionData  `json:"option-data,omitempty"`
+	Reservations  []keaReservation `json:"reservations"`
+}
+
+type keaPool struct {
+	Pool string `json:"pool"`
+}
+
+type keaOptionData struct {
+	Name string `json:"name"`
+	Data string `json:"data"`
+}
+
+type keaReservation struct {
+	HWAddress     string   `json:"hw-address"`
+	IPAddress     string   `json:"ip-address"`
+	Hostname      string   `json:"hostname"`
+	ClientClasses []string `json:"client-classes,omitempty"`
+}
+
+type keaDHCP struct{}
+
+func (keaDHCP) generate(outputPath string, r *dhcpRecords) error {
+	dir := filepath.Join(outputPath, dhcpOutputDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	bs, err := json.MarshalIndent(newKeaConfig(r), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "kea-dhcp4.conf"), bs, 0644)
+}
+
+func newKeaConfig(r *dhcpRecords) *keaConfig {
+	kc := &keaConfig{Dhcp4: keaDhcp4{
+		InterfacesConfig: keaInterfaces{Interfaces: []string{"*"}},
+		LeaseDatabase:    keaDatabase{Type: "memfile"},
+		// Client arch 7 and 9 are uefi, 16 is uefi http boot. Old bios
+		// pxe roms do not send arch option, so bios is the fallback.
+		// Hosts reserved with uefi firmware are put into uefi class by
+		// their reservation. The pxe test refers to UNKNOWN, so kea
+		// evaluates it after host lookup and it can see the reserved
+		// uefi class, which must be defined before pxe.
+		ClientClasses: []keaClass{{
+			Name:         "ipxe",
+			Test:         "option[77].text == '" + ipxeUserClass + "'",
+			BootFileName: r.BootURL,
+		}, {
+			Name:         "uefi-http",
+			Test:         "not member('ipxe') and option[93].hex == 0x0010",
+			BootFileName: r.HTTPBootURL,
+			OptionData:   []keaOptionData{{Name: "vendor-class-identifier", Data: httpClientVendorClass}},
+		}, {
+			Name:         "uefi",
+			Test:         "not member('ipxe') and (option[93].hex == 0x0007 or option[93].hex == 0x0009)",
+			NextServer:   r.NextServer,
+			BootFileName: r.EFIBootFile,
+		}, {
+			Name:         "pxe",
+			Test:         "not member('ipxe') and (member('UNKNOWN') or not member('uefi')) and (not option[93].exists or option[93].hex == 0x0000)",
+			NextServer:   r.NextServer,
+			BootFileName: r.BootFile,
+		}},
+	}}
+
+	for _, o := range []keaOptionData{
+		{Name: "domain-name-servers", Data: strings.Join(r.DNSServers, ", ")},
+		{Name: "domain-search", Data: strings.Join(r.Search, ", ")},
+		{Name: "ntp-servers", Data: strings.Join(r.NTPServers, ", ")},
+	} {
+		if len(o.Data) != 0 {
+			kc.Dhcp4.OptionData = append(kc.Dhcp4.OptionData, o)
+		}
+	}
+
+	ifaces := make([]string, 0)
+	for i, s := range r.Subnets {
+		ks := keaSubnet{
+			ID:            i + 1,
+			Subnet:        s.CIDR,
+			Interface:     s.Interface,
+			Pools:         []keaPool{{Pool: s.Start.String() + " - " + s.End.String()}},
+			ValidLifetime: s.LeaseSeconds,
+			Reservations:  make([]keaReservation, 0),
+		}
+		if len(s.Gateway) != 0 {
+			ks.OptionData = append(ks.OptionData, keaOptionData{Name: "routers", Data: s.Gateway})
+		}
+
+		_, ipnet, _ := net.ParseCIDR(s.CIDR)
+		for _, h := range r.Hosts {
+			if ipnet != nil && ipnet.Contains(net.ParseIP(h.IP)) {
+				kr := keaReservation{
+					HWAddress: strings.ToLower(h.MAC),
+					IPAddress: h.IP,
+					Hostname:  h.Hostname,
+				}
+				if h.UEFI {
+					kr.ClientClasses = []string{"uefi"}
+				}
+				ks.Reservations = append(ks.Reservations, kr)
+			}
+		}
+
+		if len(s.Interface) != 0 {
+			ifaces = append(ifaces, s.Interface)
+		}
+		kc.Dhcp4.Subnet4 = append(kc.Dhcp4.Subnet4, ks)
+	}
+
+	// Only listen on bound interfaces when every subnet is bound
+	if len(r.Listen) != 0 {
+		kc.Dhcp4.InterfacesConfig.Interfaces = r.Listen
+	} else if len(ifaces) != 0 && len(ifaces) == len(r.Subnets) {
+		kc.Dhcp4.InterfacesConfig.Interfaces = ifaces
+	}
+	return kc
+}
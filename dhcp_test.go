@@ -0,0 +1,176 @@
+package lazy
+
+import (
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseLease(t *testing.T) {
+	testFunc := func(lease string, secs int64, ok bool) {
+		n, err := parseLease(lease)
+		if (err == nil) != ok {
+			t.Fatalf("Lease %s parse error should be %v, got %v", lease, !ok, err)
+		}
+		if n != secs {
+			t.Fatalf("Lease %s should be %d seconds, got %d", lease, secs, n)
+		}
+	}
+
+	testFunc("3600", 3600, true)
+	testFunc("45s", 45, true)
+	testFunc("30m", 1800, true)
+	testFunc("1h", 3600, true)
+	testFunc("2d", 172800, true)
+	testFunc("1w", 604800, true)
+	testFunc("infinite", infiniteLease, true)
+	testFunc("", 0, false)
+	testFunc("1y", 0, false)
+	testFunc("-5m", 0, false)
+}
+
+func TestKeaConfig(t *testing.T) {
+	r := &dhcpRecords{
+		BootFile:   ipxeBootFile,
+		BootURL:    "http://matchbox.com:8080/boot.ipxe",
+		NextServer: "172.17.0.2",
+		Subnets: []dhcpSubnet{{
+			dhcpRange: dhcpRange{
+				ipRange:   ipRange{Start: net.ParseIP("172.17.0.80"), End: net.ParseIP("172.17.0.99")},
+				CIDR:      "172.17.0.0/24",
+				Interface: "eth0",
+				Gateway:   "172.17.0.1",
+			},
+			LeaseSeconds: 3600,
+		}},
+		Hosts: []dhcpHost{
+			{Name: "ctl1-eth0", Hostname: "ctl1", MAC: "52:54:00:A1:9C:AE", IP: "172.17.0.21"},
+			{Name: "ctl1-eth1", Hostname: "ctl1", MAC: "52:54:00:a1:9c:af", IP: "192.168.100.50"},
+		},
+	}
+
+	kc := newKeaConfig(r)
+	if ifaces := kc.Dhcp4.InterfacesConfig.Interfaces; len(ifaces) != 1 || ifaces[0] != "eth0" {
+		t.Fatalf("Kea should listen on bound interface eth0, got %v", ifaces)
+	}
+
+	s := kc.Dhcp4.Subnet4[0]
+	if s.Pools[0].Pool != "172.17.0.80 - 172.17.0.99" || s.OptionData[0].Data != "172.17.0.1" {
+		t.Fatalf("Kea subnet is not correct: %v", s)
+	}
+
+	if len(s.Reservations) != 1 || s.Reservations[0].HWAddress != "52:54:00:a1:9c:ae" {
+		t.Fatalf("Kea subnet should only reserve hosts in subnet: %v", s.Reservations)
+	}
+
+	if c := kc.Dhcp4.ClientClasses; c[0].BootFileName != r.BootURL || c[3].BootFileName != ipxeBootFile {
+		t.Fatalf("Kea client classes should chainload iPXE: %v", c)
+	}
+	if c := kc.Dhcp4.ClientClasses[3]; !strings.Contains(c.Test, "not option[93].exists") {
+		t.Fatalf("Clients without arch option should fall back to bios pxe: %s", c.Test)
+	}
+}
+
+func TestKeaConfigUEFIReservation(t *testing.T) {
+	r := &dhcpRecords{
+		BootFile:    ipxeBootFile,
+		EFIBootFile: ipxeEFIBootFile,
+		NextServer:  "172.17.0.2",
+		Subnets: []dhcpSubnet{{
+			dhcpRange: dhcpRange{
+				ipRange: ipRange{Start: net.ParseIP("172.17.0.80"), End: net.ParseIP("172.17.0.99")},
+				CIDR:    "172.17.0.0/24",
+			},
+		}},
+		Hosts: []dhcpHost{
+			{Name: "ctl1-eth0", Hostname: "ctl1", MAC: "52:54:00:a1:9c:ae", IP: "172.17.0.21", UEFI: true},
+			{Name: "work1-eth0", Hostname: "work1", MAC: "52:54:00:a1:9c:b0", IP: "172.17.0.22"},
+		},
+	}
+
+	kc := newKeaConfig(r)
+	rs := kc.Dhcp4.Subnet4[0].Reservations
+	if len(rs) != 2 || len(rs[0].ClientClasses) != 1 || rs[0].ClientClasses[0] != "uefi" || len(rs[1].ClientClasses) != 0 {
+		t.Fatalf("Only uefi host should be reserved into uefi class: %v", rs)
+	}
+
+	// A reserved uefi client without option 93 must not match pxe, so
+	// boot file comes from the uefi class.
+	classes := kc.Dhcp4.ClientClasses
+	uefi, pxe := classes[2], classes[3]
+	if pxe.Name != "pxe" || !strings.Contains(pxe.Test, "not member('uefi')") || !strings.Contains(pxe.Test, "member('UNKNOWN')") {
+		t.Fatalf("Pxe class should exclude reserved uefi hosts after host lookup: %s", pxe.Test)
+	}
+	if uefi.Name != "uefi" || uefi.BootFileName != ipxeEFIBootFile {
+		t.Fatalf("Uefi class should boot %s: %v", ipxeEFIBootFile, uefi)
+	}
+}
+
+func TestDHCPConfig(t *testing.T) {
+	c := loadTestConfig(t, testINIConfig+`
+[dhcp]
+enable=true
+interface=eth1
+lease=12h
+dns=172.17.0.2
+search=example.com
+ntp=172.17.0.1
+`)
+	if !c.DHCP.Enable {
+		t.Fatal("DHCP should be loaded from dhcp session")
+	}
+
+	nics := c.Nodes[0].Nics
+	if nics[0].DHCP || !nics[1].DHCP {
+		t.Fatalf("Only eth1 should use dhcp: %v", nics)
+	}
+
+	r, err := c.dhcpRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Subnets[0].LeaseSeconds != 43200 || r.Hosts[0].Lease != "12h" {
+		t.Fatalf("DHCP lease should be 12h: %v", r.Subnets[0])
+	}
+
+	if r.DNSServers[0] != "172.17.0.2" || r.Search[0] != "example.com" || r.NTPServers[0] != "172.17.0.1" {
+		t.Fatalf("DHCP options are not correct: %v %v %v", r.DNSServers, r.Search, r.NTPServers)
+	}
+}
+
+func TestDHCPFirmware(t *testing.T) {
+	c := loadTestConfig(t, strings.Replace(testINIConfig, "[work2]\n", "[work2]\nfirmware=uefi\n", 1))
+	r, err := c.dhcpRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, h := range r.Hosts {
+		if h.UEFI != (h.Hostname == "work2") {
+			t.Fatalf("Only work2 should use uefi firmware: %v", h)
+		}
+	}
+
+	if r.EFIBootFile != ipxeEFIBootFile || r.HTTPBootURL != "http://matchbox.com:8080/assets/tftpboot/ipxe.efi" {
+		t.Fatalf("UEFI boot files are not correct: %s %s", r.EFIBootFile, r.HTTPBootURL)
+	}
+
+	kc := newKeaConfig(r)
+	for _, kr := range kc.Dhcp4.Subnet4[0].Reservations {
+		if (len(kr.ClientClasses) != 0) != (kr.Hostname == "work2") {
+			t.Fatalf("Only work2 should be reserved with uefi class: %v", kr)
+		}
+	}
+
+	file := filepath.Join(t.TempDir(), "lazy.ini")
+	content := strings.Replace(testINIConfig, "[work2]\n", "[work2]\nfirmware=efi\n", 1)
+	if err = ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Load(file); err == nil {
+		t.Fatal("Unknown firmware should not be loaded")
+	}
+}
@@ -0,0 +1,350 @@
+package lazy
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	dnsmasqDNSDriver = "dnsmasq"
+	corednsDNSDriver = "coredns"
+	bindDNSDriver    = "bind"
+
+	corednsConfigDir = "/etc/coredns"
+	dnsOutputDir     = "dns"
+)
+
+var (
+	zoneSerialReg = regexp.MustCompile(`(?m)^\s*([0-9]+)\s*; serial$`)
+
+	// now is replaced by tests to get a stable zone serial
+	now = time.Now
+)
+
+type dnsBackend interface {
+	// generate writes config files of the dns server into output path
+	generate(outputPath string, r *dnsRecords) error
+}
+
+func newDNSBackend(driver string) (dnsBackend, error) {
+	switch driver {
+	case dnsmasqDNSDriver:
+		return dnsmasqDNS{}, nil
+	case corednsDNSDriver:
+		return corednsDNS{}, nil
+	case bindDNSDriver:
+		return bindDNS{}, nil
+	}
+	return nil, errors.New("Unknown dns driver: " + driver)
+}
+
+type dnsAddress struct {
+	Name string
+	IP   string
+}
+
+type dnsSRV struct {
+	Service  string
+	Target   string
+	Port     int
+	Priority int
+	Weight   int
+}
+
+type dnsSRVGroup struct {
+	Service string
+	Records []dnsSRV
+}
+
+// dnsRecords is the backend independent view of cluster dns.
+type dnsRecords struct {
+	Domain string
+	// ServerIP is address of the dns server itself
+	ServerIP string
+	// Addresses only have forward records
+	Addresses []dnsAddress
+	// Hosts have both forward and reverse records
+	Hosts []dnsAddress
+	// Pointers only have reverse records
+	Pointers   []dnsAddress
+	SRVs       []dnsSRV
+	Forwarders []string
+}
+
+func (c *Config) dnsRecords() *dnsRecords {
+	r := &dnsRecords{
+		Domain:     c.DomainBase,
+		ServerIP:   c.D.ServerIP,
+		Forwarders: c.D.DNS,
+	}
+	if len(r.ServerIP) == 0 {
+		r.ServerIP = c.M.IP
+	}
+
+	if len(c.M.Domain) != 0 && len(c.M.IP) != 0 {
+		r.Addresses = append(r.Addresses, dnsAddress{Name: c.M.Domain, IP: c.M.IP})
+	}
+
+	if c.V != nil && c.V.Enable && len(c.V.Domain) != 0 {
+		r.Addresses = append(r.Addresses, dnsAddress{Name: c.V.Domain, IP: c.V.VIP})
+	}
+
+	for _, n := range c.Nodes {
+		for i, ip := range n.IP {
+			a := dnsAddress{Name: n.Domain, IP: ip}
+			if i == 0 {
+				r.Hosts = append(r.Hosts, a)
+			} else {
+				r.Pointers = append(r.Pointers, a)
+			}
+		}
+	}
+
+	if len(c.DomainBase) != 0 {
+		for _, m := range c.Cls.Masters {
+			r.SRVs = append(r.SRVs,
+				dnsSRV{Service: "_etcd-server._tcp." + c.DomainBase, Target: m, Port: 2380, Weight: 10},
+				dnsSRV{Service: "_etcd-client._tcp." + c.DomainBase, Target: m, Port: 2379, Weight: 10})
+		}
+	}
+	return r
+}
+
+// hostsLines returns lines of hosts file, which resolves every forward
+// record without any dns server.
+func (r *dnsRecords) hostsLines() []string {
+	lines := []string{"127.0.0.1 localhost", "::1 localhost"}
+	for _, as := range [][]dnsAddress{r.Addresses, r.Hosts} {
+		for _, a := range as {
+			lines = append(lines, a.IP+" "+a.Name)
+		}
+	}
+	return lines
+}
+
+// SRVGroups returns srv records grouped by service, in order of the
+// first appearance of each service.
+func (r *dnsRecords) SRVGroups() []dnsSRVGroup {
+	groups := make([]dnsSRVGroup, 0)
+	index := make(map[string]int)
+	for _, srv := range r.SRVs {
+		i, ok := index[srv.Service]
+		if !ok {
+			i = len(groups)
+			index[srv.Service] = i
+			groups = append(groups, dnsSRVGroup{Service: srv.Service})
+		}
+		groups[i].Records = append(groups[i].Records, srv)
+	}
+	return groups
+}
+
+// dnsmasqDNS appends dns section into dnsmasq.conf, so it should be
+// generated after dnsmasq.conf.
+type dnsmasqDNS struct{}
+
+func (dnsmasqDNS) generate(outputPath string, r *dnsRecords) error {
+	return appendTemplateToFile(DNSMASQ_DNS_TMPL, "dnsmasq dns",
+		filepath.Join(outputPath, "dnsmasq.conf"), r)
+}
+
+type corednsDNS struct{}
+
+func (corednsDNS) generate(outputPath string, r *dnsRecords) error {
+	dir := filepath.Join(outputPath, dnsOutputDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	data := struct {
+		*dnsRecords
+		Dir string
+	}{r, corednsConfigDir}
+	err := writeTemplateToFile(COREDNS_TMPL, "coredns",
+		filepath.Join(dir, "Corefile"), data)
+	if err != nil {
+		return err
+	}
+
+	err = writeTemplateToFile(COREDNS_HOSTS_TMPL, "coredns hosts",
+		filepath.Join(dir, "hosts"), r)
+	if err != nil {
+		return err
+	}
+
+	return writeTemplateToFile(COREDNS_REVERSE_HOSTS_TMPL, "coredns reverse hosts",
+		filepath.Join(dir, "hosts.reverse"), r)
+}
+
+type bindZone struct {
+	Origin  string
+	File    string
+	NS      string
+	Serial  uint64
+	Records []bindRecord
+}
+
+type bindRecord struct {
+	Name string
+	Type string
+	Data string
+}
+
+type bindDNS struct{}
+
+func (bindDNS) generate(outputPath string, r *dnsRecords) error {
+	dir := filepath.Join(outputPath, dnsOutputDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	zones := bindZones(r)
+	for _, z := range zones {
+		if err := writeBindZone(filepath.Join(dir, z.File), z); err != nil {
+			return err
+		}
+	}
+
+	return writeTemplateToFile(BIND_NAMED_TMPL, "bind named",
+		filepath.Join(dir, "named.conf"), zones)
+}
+
+// bindZones splits records into forward zones and /24 reverse zones.
+// Names under domain base are put into the same zone, other names get
+// their own zone.
+func bindZones(r *dnsRecords) []*bindZone {
+	forward := make(map[string]*bindZone)
+	reverse := make(map[string]*bindZone)
+	origins := make([]string, 0)
+
+	forwardZone := func(name string) (*bindZone, string) {
+		origin := name
+		if len(r.Domain) != 0 && (name == r.Domain || strings.HasSuffix(name, "."+r.Domain)) {
+			origin = r.Domain
+		}
+
+		z, ok := forward[origin]
+		if !ok {
+			z = &bindZone{Origin: origin, File: "db." + origin, NS: "ns." + origin}
+			if len(r.ServerIP) != 0 {
+				z.Records = append(z.Records, bindRecord{"ns", "A", r.ServerIP})
+			}
+			forward[origin] = z
+			origins = append(origins, origin)
+		}
+		return z, zoneRelativeName(name, origin)
+	}
+
+	reverseZone := func(ip string) (*bindZone, string) {
+		ip4 := net.ParseIP(ip).To4()
+		if ip4 == nil {
+			return nil, ""
+		}
+
+		origin := fmt.Sprintf("%d.%d.%d.in-addr.arpa", ip4[2], ip4[1], ip4[0])
+		z, ok := reverse[origin]
+		if !ok {
+			z = &bindZone{Origin: origin, File: "db." + origin}
+			reverse[origin] = z
+			origins = append(origins, origin)
+		}
+		return z, strconv.Itoa(int(ip4[3]))
+	}
+
+	for _, as := range [][]dnsAddress{r.Addresses, r.Hosts} {
+		for _, a := range as {
+			z, name := forwardZone(a.Name)
+			z.Records = append(z.Records, bindRecord{name, "A", a.IP})
+		}
+	}
+
+	for _, as := range [][]dnsAddress{r.Hosts, r.Pointers} {
+		for _, a := range as {
+			if z, name := reverseZone(a.IP); z != nil {
+				z.Records = append(z.Records, bindRecord{name, "PTR", a.Name + "."})
+			}
+		}
+	}
+
+	for _, srv := range r.SRVs {
+		z, name := forwardZone(srv.Service)
+		z.Records = append(z.Records, bindRecord{name, "SRV",
+			fmt.Sprintf("%d %d %d %s.", srv.Priority, srv.Weight, srv.Port, srv.Target)})
+	}
+
+	sort.Strings(origins)
+	reverseNS := "ns." + r.Domain
+	if len(r.Domain) == 0 {
+		for _, origin := range origins {
+			if z, ok := forward[origin]; ok {
+				reverseNS = z.NS
+				break
+			}
+		}
+	}
+
+	zones := make([]*bindZone, 0, len(origins))
+	for _, origin := range origins {
+		if z, ok := forward[origin]; ok {
+			zones = append(zones, z)
+			continue
+		}
+
+		z := reverse[origin]
+		z.NS = reverseNS
+		zones = append(zones, z)
+	}
+	return zones
+}
+
+func zoneRelativeName(name, origin string) string {
+	if name == origin {
+		return "@"
+	}
+	return strings.TrimSuffix(name, "."+origin)
+}
+
+// writeBindZone writes zone file, serial of existing zone file is kept
+// when records are not changed, otherwise it is bumped.
+func writeBindZone(file string, z *bindZone) error {
+	var oldSerial uint64
+	old, err := ioutil.ReadFile(file)
+	if err == nil {
+		if m := zoneSerialReg.FindSubmatch(old); m != nil {
+			oldSerial, _ = strconv.ParseUint(string(m[1]), 10, 64)
+		}
+	}
+
+	if oldSerial != 0 {
+		z.Serial = oldSerial
+		if err = writeTemplateToFile(BIND_ZONE_TMPL, "bind zone", file, z); err != nil {
+			return err
+		}
+
+		current, err := ioutil.ReadFile(file)
+		if err == nil && string(current) == string(old) {
+			return nil
+		}
+	}
+
+	z.Serial = zoneSerial(oldSerial)
+	return writeTemplateToFile(BIND_ZONE_TMPL, "bind zone", file, z)
+}
+
+// zoneSerial returns serial in YYYYMMDDnn format which is bigger than old.
+func zoneSerial(old uint64) uint64 {
+	serial, _ := strconv.ParseUint(now().Format("20060102")+"00", 10, 64)
+	if serial <= old {
+		serial = old + 1
+	}
+	return serial
+}
@@ -0,0 +1,191 @@
+package lazy
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testDNSRecords() *dnsRecords {
+	return &dnsRecords{
+		Domain:     "example.com",
+		ServerIP:   "172.17.0.2",
+		Addresses:  []dnsAddress{{Name: "matchbox.com", IP: "172.17.0.2"}},
+		Hosts:      []dnsAddress{{Name: "ctl1.example.com", IP: "172.17.0.21"}},
+		Pointers:   []dnsAddress{{Name: "ctl1.example.com", IP: "192.168.100.50"}},
+		SRVs:       []dnsSRV{{Service: "_etcd-server._tcp.example.com", Target: "ctl1.example.com", Port: 2380, Weight: 10}},
+		Forwarders: []string{"8.8.8.8"},
+	}
+}
+
+func TestBindZones(t *testing.T) {
+	zones := bindZones(testDNSRecords())
+	expected := []string{"0.17.172.in-addr.arpa", "100.168.192.in-addr.arpa", "example.com", "matchbox.com"}
+	if len(zones) != len(expected) {
+		t.Fatalf("Bind should have %d zones, got %d", len(expected), len(zones))
+	}
+
+	for i, z := range zones {
+		if z.Origin != expected[i] {
+			t.Fatalf("Zone %s is not match with %s", z.Origin, expected[i])
+		}
+	}
+
+	records := map[string]bindRecord{}
+	for _, r := range zones[2].Records {
+		records[r.Name] = r
+	}
+	if records["ctl1"].Data != "172.17.0.21" || records["_etcd-server._tcp"].Data != "0 10 2380 ctl1.example.com." {
+		t.Fatalf("Forward zone records are not correct: %v", zones[2].Records)
+	}
+
+	if r := zones[1].Records[0]; r.Name != "50" || r.Type != "PTR" || r.Data != "ctl1.example.com." {
+		t.Fatalf("Reverse zone record is not correct: %v", r)
+	}
+
+	if zones[0].NS != "ns.example.com" {
+		t.Fatalf("Reverse zone ns %s should be under domain base", zones[0].NS)
+	}
+}
+
+func TestWriteBindZoneSerial(t *testing.T) {
+	now = func() time.Time { return time.Date(2017, 3, 5, 0, 0, 0, 0, time.UTC) }
+	defer func() { now = time.Now }()
+
+	file := filepath.Join(t.TempDir(), "db.example.com")
+	serial := func() uint64 {
+		bs, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return bindZoneSerial(t, bs)
+	}
+
+	z := bindZones(testDNSRecords())[2]
+	if err := writeBindZone(file, z); err != nil {
+		t.Fatal(err)
+	}
+	if s := serial(); s != 2017030500 {
+		t.Fatalf("First serial should be 2017030500, got %d", s)
+	}
+
+	if err := writeBindZone(file, bindZones(testDNSRecords())[2]); err != nil {
+		t.Fatal(err)
+	}
+	if s := serial(); s != 2017030500 {
+		t.Fatalf("Serial should be kept without changes, got %d", s)
+	}
+
+	r := testDNSRecords()
+	r.Hosts[0].IP = "172.17.0.22"
+	if err := writeBindZone(file, bindZones(r)[2]); err != nil {
+		t.Fatal(err)
+	}
+	if s := serial(); s != 2017030501 {
+		t.Fatalf("Serial should be bumped to 2017030501, got %d", s)
+	}
+}
+
+func bindZoneSerial(t *testing.T, bs []byte) uint64 {
+	m := zoneSerialReg.FindSubmatch(bs)
+	if m == nil {
+		t.Fatalf("Zone file does not have serial: %s", bs)
+	}
+
+	s, err := strconv.ParseUint(string(m[1]), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestCorednsGenerate(t *testing.T) {
+	dir := t.TempDir()
+	if err := (corednsDNS{}).generate(dir, testDNSRecords()); err != nil {
+		t.Fatal(err)
+	}
+
+	bs, err := ioutil.ReadFile(filepath.Join(dir, dnsOutputDir, "Corefile"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []string{
+		`match ^_etcd-server\._tcp\.example\.com\.$`,
+		`answer "_etcd-server._tcp.example.com. 60 IN SRV 0 10 2380 ctl1.example.com."`,
+		"forward . 8.8.8.8",
+		"in-addr.arpa {",
+	} {
+		if !strings.Contains(string(bs), s) {
+			t.Fatalf("Corefile does not contain %s:\n%s", s, bs)
+		}
+	}
+
+	bs, err = ioutil.ReadFile(filepath.Join(dir, dnsOutputDir, "hosts.reverse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(bs), "192.168.100.50 ctl1.example.com") {
+		t.Fatalf("Reverse hosts does not contain pointer record:\n%s", bs)
+	}
+}
+
+func TestHostsLines(t *testing.T) {
+	lines := testDNSRecords().hostsLines()
+	expected := []string{
+		"127.0.0.1 localhost",
+		"::1 localhost",
+		"172.17.0.2 matchbox.com",
+		"172.17.0.21 ctl1.example.com",
+	}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Hosts lines %v are not match with %v", lines, expected)
+	}
+}
+
+func TestDnsmasqGenerate(t *testing.T) {
+	c := loadTestConfig(t, testINIConfig)
+	if c.D.Driver != dnsmasqDNSDriver {
+		t.Fatalf("Default dns driver should be dnsmasq, got %s", c.D.Driver)
+	}
+
+	dir := t.TempDir()
+	if err := c.Generate(dir); err != nil {
+		t.Fatal(err)
+	}
+	bs, err := ioutil.ReadFile(filepath.Join(dir, "dnsmasq.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []string{
+		"address=/matchbox.com/172.17.0.2",
+		"address=/vip.cluster.com/172.17.0.30",
+		"host-record=ctl1.example.com,172.17.0.21",
+		"host-record=work1.example.com,172.17.0.25",
+		"host-record=work2.example.com,172.17.0.22",
+		"ptr-record=50.100.168.192.in-addr.arpa,ctl1.example.com",
+		"srv-host=_etcd-server._tcp.example.com,ctl1.example.com,2380,0,10",
+		"srv-host=_etcd-client._tcp.example.com,ctl1.example.com,2379,0,10",
+		"server=8.8.8.8",
+	} {
+		if !strings.Contains(string(bs), s+"\n") {
+			t.Fatalf("dnsmasq.conf does not contain %s:\n%s", s, bs)
+		}
+	}
+
+	// other drivers leave dns records out of dnsmasq.conf
+	c.dns = corednsDNS{}
+	if err = c.Generate(dir); err != nil {
+		t.Fatal(err)
+	}
+	if bs, err = ioutil.ReadFile(filepath.Join(dir, "dnsmasq.conf")); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(bs), "host-record=") || strings.Contains(string(bs), "srv-host=") {
+		t.Fatalf("dnsmasq.conf should not contain dns records of coredns driver:\n%s", bs)
+	}
+}
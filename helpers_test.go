@@ -0,0 +1,132 @@
+package lazy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// fakeAPIServer serves health, nodes and kube-dns endpoints of cluster,
+// or members of etcd.
+type fakeAPIServer struct {
+	mu      sync.Mutex
+	healthy bool
+	ready   map[string]bool
+	dns     []string
+	members string
+}
+
+func (s *fakeAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/healthz":
+		if !s.healthy {
+			http.Error(w, "etcd failed", http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("ok"))
+	case "/api/v1/nodes":
+		items := make([]interface{}, 0, len(s.ready))
+		for name, ready := range s.ready {
+			status := "False"
+			if ready {
+				status = "True"
+			}
+			items = append(items, map[string]interface{}{
+				"metadata": map[string]string{"name": name},
+				"status": map[string]interface{}{
+					"conditions": []map[string]string{{"type": "Ready", "status": status}},
+				},
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+	case "/v2/members":
+		w.Write([]byte(s.members))
+	case "/api/v1/namespaces/kube-system/endpoints/kube-dns":
+		addrs := make([]map[string]string, 0, len(s.dns))
+		for _, ip := range s.dns {
+			addrs = append(addrs, map[string]string{"ip": ip})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"subsets": []interface{}{map[string]interface{}{"addresses": addrs}},
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func startFakeAPIServer(t *testing.T, c *Config, h http.Handler) *httptest.Server {
+	tlsDir := filepath.Join(c.A.Dir, tlsAssetsDir)
+	cert, err := tls.LoadX509KeyPair(filepath.Join(tlsDir, "apiserver.pem"), filepath.Join(tlsDir, "apiserver-key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	caDER, err := readPEM(filepath.Join(tlsDir, caCertFile), "CERTIFICATE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	srv := httptest.NewUnstartedServer(h)
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// startFakeCluster generates certs of c, then resolves VIP to api, master
+// ctl1 to master and etcd of ctl1 to etcd, whose members are the initial
+// cluster.
+func startFakeCluster(t *testing.T, c *Config) (api, master, etcd *fakeAPIServer) {
+	dir := t.TempDir()
+	c.A.Dir = filepath.Join(dir, "assets")
+	c.K.PKI = filepath.Join(dir, "pki")
+	c.S.State = filepath.Join(dir, "nodes.json")
+	if err := c.GenerateCerts(); err != nil {
+		t.Fatal(err)
+	}
+
+	api = &fakeAPIServer{
+		healthy: true,
+		ready:   map[string]bool{"ctl1.example.com": true, "work1.example.com": false},
+	}
+	master = &fakeAPIServer{}
+	etcd = &fakeAPIServer{members: `{"members":[{"name":"ctl1","peerURLs":["http://ctl1.example.com:2380"]}]}`}
+	vipSrv := startFakeAPIServer(t, c, api)
+	masterSrv := startFakeAPIServer(t, c, master)
+	etcdSrv := httptest.NewServer(etcd)
+	t.Cleanup(etcdSrv.Close)
+
+	hosts := map[string]string{
+		"vip.cluster.com:443":   vipSrv.Listener.Addr().String(),
+		"ctl1.example.com:443":  masterSrv.Listener.Addr().String(),
+		"ctl1.example.com:2379": etcdSrv.Listener.Addr().String(),
+	}
+	dial := verifyDialContext
+	t.Cleanup(func() { verifyDialContext = dial })
+	verifyDialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if a, ok := hosts[addr]; ok {
+			addr = a
+		}
+		return (&net.Dialer{}).DialContext(ctx, network, addr)
+	}
+	return api, master, etcd
+}
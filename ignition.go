@@ -0,0 +1,508 @@
+package lazy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+const (
+	ignitionVersion   = "3.3.0"
+	ignitionOutputDir = "ignition"
+	// networkd units of Container Linux Config are written as files
+	networkdUnitDir = "/etc/systemd/network"
+)
+
+var (
+	ignitionUnitSuffixes = []string{".service", ".socket", ".target", ".timer", ".mount",
+		".automount", ".path", ".device", ".slice", ".scope", ".swap"}
+	ignitionFormats = []string{"ext4", "xfs", "btrfs", "vfat", "swap", "none"}
+	ignitionSchemes = []string{"data", "http", "https", "tftp", "s3", "gs"}
+)
+
+type IgnitionConfig struct {
+	Render    bool   `ini:"render"`
+	Templates string `ini:"templates"`
+}
+
+type ignitionDocument struct {
+	Ignition ignitionMeta    `json:"ignition"`
+	Storage  ignitionStorage `json:"storage"`
+	Systemd  ignitionSystemd `json:"systemd"`
+	Passwd   ignitionPasswd  `json:"passwd"`
+}
+
+type ignitionMeta struct {
+	Version string `json:"version"`
+}
+
+type ignitionStorage struct {
+	Disks       []ignitionDisk       `json:"disks,omitempty"`
+	Filesystems []ignitionFilesystem `json:"filesystems,omitempty"`
+	Files       []ignitionFile       `json:"files,omitempty"`
+}
+
+type ignitionDisk struct {
+	Device     string              `json:"device"`
+	WipeTable  bool                `json:"wipeTable,omitempty"`
+	Partitions []ignitionPartition `json:"partitions,omitempty"`
+}
+
+type ignitionPartition struct {
+	Label    string `json:"label,omitempty"`
+	Number   int    `json:"number,omitempty"`
+	SizeMiB  *int   `json:"sizeMiB,omitempty"`
+	StartMiB *int   `json:"startMiB,omitempty"`
+	TypeGUID string `json:"typeGuid,omitempty"`
+}
+
+type ignitionFilesystem struct {
+	Device         string   `json:"device"`
+	Format         string   `json:"format"`
+	Label          string   `json:"label,omitempty"`
+	WipeFilesystem bool     `json:"wipeFilesystem,omitempty"`
+	Options        []string `json:"options,omitempty"`
+}
+
+type ignitionFile struct {
+	Path      string           `json:"path"`
+	Overwrite bool             `json:"overwrite"`
+	Mode      *int             `json:"mode,omitempty"`
+	Contents  ignitionResource `json:"contents"`
+}
+
+type ignitionResource struct {
+	Source string `json:"source"`
+}
+
+type ignitionSystemd struct {
+	Units []ignitionUnit `json:"units,omitempty"`
+}
+
+type ignitionUnit struct {
+	Name     string           `json:"name"`
+	Enabled  *bool            `json:"enabled,omitempty"`
+	Mask     bool             `json:"mask,omitempty"`
+	Contents string           `json:"contents,omitempty"`
+	Dropins  []ignitionDropin `json:"dropins,omitempty"`
+}
+
+type ignitionDropin struct {
+	Name     string `json:"name"`
+	Contents string `json:"contents"`
+}
+
+type ignitionPasswd struct {
+	Users []ignitionUser `json:"users,omitempty"`
+}
+
+type ignitionUser struct {
+	Name              string   `json:"name"`
+	PasswordHash      string   `json:"passwordHash,omitempty"`
+	SSHAuthorizedKeys []string `json:"sshAuthorizedKeys,omitempty"`
+	Groups            []string `json:"groups,omitempty"`
+}
+
+// generateIgnition renders Ignition v3 document of every node whose
+// profile has Container Linux Config template.
+func (c *Config) generateIgnition(outputPath string) error {
+	dir := filepath.Join(outputPath, ignitionOutputDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	// every document is validated before any of them is written
+	docs := make(map[string]*ignitionDocument)
+	for _, n := range c.Nodes {
+		doc, err := c.renderIgnition(n)
+		if err != nil {
+			return fmt.Errorf("Render ignition of node %s failed: %v", n.ID, err)
+		}
+		if doc != nil {
+			docs[n.ID] = doc
+		}
+	}
+
+	for id, doc := range docs {
+		bs, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		if err = ioutil.WriteFile(filepath.Join(dir, id+".ign"), bs, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renderIgnition executes template of node profile with node metadata,
+// it returns nil when the profile has no template.
+func (c *Config) renderIgnition(n *Node) (*ignitionDocument, error) {
+	tmpl, name := n.groupTemplate()
+	bs, err := renderTemplate(tmpl, name, n)
+	if err != nil {
+		return nil, err
+	}
+
+	group := struct {
+		Profile  string                 `json:"profile"`
+		Metadata map[string]interface{} `json:"metadata"`
+	}{}
+	if err = json.Unmarshal(bs, &group); err != nil {
+		return nil, err
+	}
+
+	file := filepath.Join(c.I.Templates, group.Profile+".yaml")
+	content, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		log.Println("Skip ignition of node", n.ID, "without template", file)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Templates are written for matchbox, which does not register any
+	// function into template.
+	t, err := template.New(group.Profile).Parse(string(content))
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err = t.Execute(&buf, group.Metadata); err != nil {
+		return nil, err
+	}
+
+	clc, err := parseYAML(buf.String())
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := newIgnitionDocument(clc)
+	if err != nil {
+		return nil, err
+	}
+	return doc, doc.validate()
+}
+
+// parseYAML decodes yaml document, mappings are converted to
+// map[string]interface{} so the document is walked as json value.
+func parseYAML(content string) (interface{}, error) {
+	var v interface{}
+	if err := yaml.Unmarshal([]byte(content), &v); err != nil {
+		return nil, err
+	}
+	return yamlValue(v), nil
+}
+
+func yamlValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			m[fmt.Sprint(k)] = yamlValue(e)
+		}
+		return m
+	case []interface{}:
+		for i, e := range t {
+			t[i] = yamlValue(e)
+		}
+	}
+	return v
+}
+
+// clcNode wraps value of parsed Container Linux Config, the first type
+// error is kept and returned by newIgnitionDocument.
+type clcNode struct {
+	v    interface{}
+	path string
+	err  *error
+}
+
+func (n clcNode) fail(format string, args ...interface{}) {
+	if *n.err == nil {
+		*n.err = fmt.Errorf("Container Linux Config %s: %s", n.path, fmt.Sprintf(format, args...))
+	}
+}
+
+func (n clcNode) get(key string) clcNode {
+	child := clcNode{path: strings.TrimPrefix(n.path+"."+key, "."), err: n.err}
+	if n.v == nil {
+		return child
+	}
+
+	m, ok := n.v.(map[string]interface{})
+	if !ok {
+		n.fail("should be a mapping")
+		return child
+	}
+	child.v = m[key]
+	return child
+}
+
+func (n clcNode) list() []clcNode {
+	if n.v == nil {
+		return nil
+	}
+
+	l, ok := n.v.([]interface{})
+	if !ok {
+		n.fail("should be a sequence")
+		return nil
+	}
+
+	nodes := make([]clcNode, 0, len(l))
+	for i, v := range l {
+		nodes = append(nodes, clcNode{v: v, path: fmt.Sprintf("%s[%d]", n.path, i), err: n.err})
+	}
+	return nodes
+}
+
+func (n clcNode) str() string {
+	switch v := n.v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int, bool:
+		return fmt.Sprint(v)
+	}
+	n.fail("should be a string")
+	return ""
+}
+
+func (n clcNode) strs() []string {
+	var ss []string
+	for _, item := range n.list() {
+		ss = append(ss, item.str())
+	}
+	return ss
+}
+
+func (n clcNode) boolean() bool {
+	if n.v == nil {
+		return false
+	}
+
+	b, ok := n.v.(bool)
+	if !ok {
+		n.fail("should be a boolean")
+	}
+	return b
+}
+
+func (n clcNode) integer() *int {
+	if n.v == nil {
+		return nil
+	}
+
+	i, ok := n.v.(int)
+	if !ok {
+		n.fail("should be an integer")
+		return nil
+	}
+	return &i
+}
+
+// newIgnitionDocument translates Container Linux Config into Ignition
+// v3, files of other filesystem than root are not supported.
+func newIgnitionDocument(clc interface{}) (*ignitionDocument, error) {
+	var err error
+	root := clcNode{v: clc, err: &err}
+	doc := &ignitionDocument{Ignition: ignitionMeta{Version: ignitionVersion}}
+
+	storage := root.get("storage")
+	for _, d := range storage.get("disks").list() {
+		disk := ignitionDisk{Device: d.get("device").str(), WipeTable: d.get("wipe_table").boolean()}
+		for _, p := range d.get("partitions").list() {
+			disk.Partitions = append(disk.Partitions, ignitionPartition{
+				Label:    p.get("label").str(),
+				Number:   intValue(p.get("number").integer()),
+				SizeMiB:  p.get("size_mib").integer(),
+				StartMiB: p.get("start_mib").integer(),
+				TypeGUID: p.get("type_guid").str(),
+			})
+		}
+		doc.Storage.Disks = append(doc.Storage.Disks, disk)
+	}
+
+	for _, f := range storage.get("filesystems").list() {
+		mount := f.get("mount")
+		fs := ignitionFilesystem{
+			Device:         mount.get("device").str(),
+			Format:         mount.get("format").str(),
+			Label:          mount.get("label").str(),
+			WipeFilesystem: mount.get("create").get("force").boolean(),
+			Options:        mount.get("create").get("options").strs(),
+		}
+		if name := f.get("name").str(); name != "root" && len(name) != 0 {
+			f.fail("filesystem %s is not supported, only root is", name)
+		}
+		doc.Storage.Filesystems = append(doc.Storage.Filesystems, fs)
+	}
+
+	for _, f := range storage.get("files").list() {
+		if fs := f.get("filesystem").str(); fs != "root" && len(fs) != 0 {
+			f.fail("filesystem %s is not supported, only root is", fs)
+		}
+
+		file := ignitionFile{Path: f.get("path").str(), Overwrite: true, Mode: f.get("mode").integer()}
+		contents := f.get("contents")
+		if remote := contents.get("remote").get("url").str(); len(remote) != 0 {
+			file.Contents.Source = remote
+		} else {
+			file.Contents.Source = dataURL(contents.get("inline").str())
+		}
+		doc.Storage.Files = append(doc.Storage.Files, file)
+	}
+
+	for _, u := range root.get("systemd").get("units").list() {
+		unit := ignitionUnit{
+			Name:     u.get("name").str(),
+			Mask:     u.get("mask").boolean(),
+			Contents: u.get("contents").str(),
+		}
+		for _, key := range []string{"enable", "enabled"} {
+			if u.get(key).v != nil {
+				enabled := u.get(key).boolean()
+				unit.Enabled = &enabled
+			}
+		}
+		for _, d := range u.get("dropins").list() {
+			unit.Dropins = append(unit.Dropins, ignitionDropin{Name: d.get("name").str(), Contents: d.get("contents").str()})
+		}
+		doc.Systemd.Units = append(doc.Systemd.Units, unit)
+	}
+
+	for _, u := range root.get("networkd").get("units").list() {
+		mode := 0644
+		doc.Storage.Files = append(doc.Storage.Files, ignitionFile{
+			Path:      path.Join(networkdUnitDir, u.get("name").str()),
+			Overwrite: true,
+			Mode:      &mode,
+			Contents:  ignitionResource{Source: dataURL(u.get("contents").str())},
+		})
+	}
+
+	for _, u := range root.get("passwd").get("users").list() {
+		doc.Passwd.Users = append(doc.Passwd.Users, ignitionUser{
+			Name:              u.get("name").str(),
+			PasswordHash:      u.get("password_hash").str(),
+			SSHAuthorizedKeys: u.get("ssh_authorized_keys").strs(),
+			Groups:            u.get("groups").strs(),
+		})
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func intValue(i *int) int {
+	if i == nil {
+		return 0
+	}
+	return *i
+}
+
+// dataURL encodes content as RFC 2397 data url without media type.
+func dataURL(content string) string {
+	return "data:," + strings.Replace(url.QueryEscape(content), "+", "%20", -1)
+}
+
+func (doc *ignitionDocument) validate() error {
+	if doc.Ignition.Version != ignitionVersion {
+		return fmt.Errorf("Ignition version %s is not supported", doc.Ignition.Version)
+	}
+
+	for _, d := range doc.Storage.Disks {
+		if !path.IsAbs(d.Device) {
+			return fmt.Errorf("Disk device %s should be absolute path", d.Device)
+		}
+	}
+
+	for _, fs := range doc.Storage.Filesystems {
+		if !path.IsAbs(fs.Device) {
+			return fmt.Errorf("Filesystem device %s should be absolute path", fs.Device)
+		}
+		if !containString(ignitionFormats, fs.Format) {
+			return fmt.Errorf("Filesystem format %s of %s is not supported", fs.Format, fs.Device)
+		}
+	}
+
+	paths := make(map[string]bool)
+	for _, f := range doc.Storage.Files {
+		if !path.IsAbs(f.Path) || path.Clean(f.Path) != f.Path {
+			return fmt.Errorf("File path %s should be clean absolute path", f.Path)
+		}
+		if paths[f.Path] {
+			return fmt.Errorf("File %s is duplicated", f.Path)
+		}
+		paths[f.Path] = true
+
+		if f.Mode != nil && (*f.Mode < 0 || *f.Mode > 07777) {
+			return fmt.Errorf("File mode %o of %s is not correct", *f.Mode, f.Path)
+		}
+
+		u, err := url.Parse(f.Contents.Source)
+		if err != nil || !containString(ignitionSchemes, u.Scheme) {
+			return fmt.Errorf("File source of %s is not correct: %s", f.Path, f.Contents.Source)
+		}
+	}
+
+	units := make(map[string]bool)
+	for _, u := range doc.Systemd.Units {
+		if !hasSuffix(u.Name, ignitionUnitSuffixes) || strings.Contains(u.Name, "/") {
+			return fmt.Errorf("Systemd unit name %s is not correct", u.Name)
+		}
+		if units[u.Name] {
+			return fmt.Errorf("Systemd unit %s is duplicated", u.Name)
+		}
+		units[u.Name] = true
+
+		for _, d := range u.Dropins {
+			if !strings.HasSuffix(d.Name, ".conf") || strings.Contains(d.Name, "/") {
+				return fmt.Errorf("Dropin name %s of unit %s is not correct", d.Name, u.Name)
+			}
+		}
+	}
+
+	users := make(map[string]bool)
+	for _, u := range doc.Passwd.Users {
+		if len(u.Name) == 0 || users[u.Name] {
+			return fmt.Errorf("User name %q is empty or duplicated", u.Name)
+		}
+		users[u.Name] = true
+	}
+	return nil
+}
+
+func containString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func hasSuffix(s string, suffixes []string) bool {
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(s, suffix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,185 @@
+package lazy
+
+import (
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testCLC = `---
+systemd:
+  units:
+    - name: etcd2.service
+      enable: true
+      dropins:
+        - name: 40-etcd-cluster.conf
+          contents: |
+            [Service]
+            Environment="ETCD_PROXY=on"
+storage:
+  disks:
+    - device: /dev/sda
+      wipe_table: true
+      partitions:
+        - label: ROOT
+  filesystems:
+    - name: root
+      mount:
+        device: "/dev/sda1"
+        format: "ext4"
+        create:
+          force: true
+          options:
+            - "-LROOT"
+  files:
+    - path: /etc/hostname
+      filesystem: root
+      mode: 0644
+      contents:
+        inline:
+          ctl1.example.com
+    - path: /opt/init
+      filesystem: root
+      mode: 0544
+      contents:
+        inline: |
+          #!/bin/bash -ex
+          # comment: inside block
+          - not a sequence
+
+          echo done
+networkd:
+  units:
+    - name: "00-eth0.network"
+      contents: |
+        [Match]
+        Name=eth0
+passwd:
+  users:
+    - name: core
+      ssh_authorized_keys:
+        - ssh-rsa AAAA user@host
+`
+
+func TestParseYAML(t *testing.T) {
+	v, err := parseYAML(testCLC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := v.(map[string]interface{})["storage"].(map[string]interface{})["files"].([]interface{})
+	init := files[1].(map[string]interface{})
+	if init["mode"] != 0544 {
+		t.Fatalf("Octal mode should be parsed, got %v", init["mode"])
+	}
+
+	expected := "#!/bin/bash -ex\n# comment: inside block\n- not a sequence\n\necho done\n"
+	if content := init["contents"].(map[string]interface{})["inline"]; content != expected {
+		t.Fatalf("Block scalar should be %q, got %q", expected, content)
+	}
+
+	if hostname := files[0].(map[string]interface{})["contents"].(map[string]interface{})["inline"]; hostname != "ctl1.example.com" {
+		t.Fatalf("Plain scalar on next line is not correct: %q", hostname)
+	}
+
+	if _, err = parseYAML("a:\n  b: 1\n c: 2\n"); err == nil {
+		t.Fatal("Wrong indentation should not be parsed")
+	}
+
+	v, err = parseYAML("preferences: {}\nnames: [a, b]\n")
+	if m, ok := v.(map[string]interface{})["preferences"].(map[string]interface{}); err != nil || !ok || len(m) != 0 {
+		t.Fatalf("Flow mapping should be parsed, got %v", v)
+	}
+}
+
+func TestNewIgnitionDocument(t *testing.T) {
+	v, err := parseYAML(testCLC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := newIgnitionDocument(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = doc.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if u := doc.Systemd.Units[0]; u.Enabled == nil || !*u.Enabled || u.Dropins[0].Name != "40-etcd-cluster.conf" {
+		t.Fatalf("Unit is not correct: %v", u)
+	}
+
+	fs := doc.Storage.Filesystems[0]
+	if fs.Device != "/dev/sda1" || !fs.WipeFilesystem || fs.Options[0] != "-LROOT" {
+		t.Fatalf("Filesystem is not correct: %v", fs)
+	}
+
+	files := doc.Storage.Files
+	if len(files) != 3 || files[2].Path != "/etc/systemd/network/00-eth0.network" {
+		t.Fatalf("Networkd units should be written as files: %v", files)
+	}
+
+	content, _ := url.PathUnescape(strings.TrimPrefix(files[0].Contents.Source, "data:,"))
+	if content != "ctl1.example.com" || *files[0].Mode != 0644 {
+		t.Fatalf("File %s is not correct: %s %o", files[0].Path, content, *files[0].Mode)
+	}
+
+	if u := doc.Passwd.Users[0]; u.Name != "core" || u.SSHAuthorizedKeys[0] != "ssh-rsa AAAA user@host" {
+		t.Fatalf("User is not correct: %v", u)
+	}
+
+	doc.Storage.Files = append(doc.Storage.Files, files[0])
+	if err = doc.validate(); err == nil {
+		t.Fatal("Duplicated file should not be valid")
+	}
+
+	v, _ = parseYAML("systemd:\n  units:\n    - name: kubelet\n")
+	if doc, err = newIgnitionDocument(v); err != nil || doc.validate() == nil {
+		t.Fatal("Unit without suffix should not be valid")
+	}
+
+	v, _ = parseYAML("storage:\n  files: /etc/hostname\n")
+	if _, err = newIgnitionDocument(v); err == nil {
+		t.Fatal("Files should be a sequence")
+	}
+}
+
+func TestRenderIgnition(t *testing.T) {
+	c := loadTestConfig(t, testINIConfig)
+	for _, n := range c.Nodes {
+		doc, err := c.renderIgnition(n)
+		if err != nil {
+			t.Fatalf("Render ignition of %s failed: %v", n.ID, err)
+		}
+
+		found := false
+		for _, f := range doc.Storage.Files {
+			if f.Path == "/etc/hostname" {
+				found = f.Contents.Source == dataURL(n.Domain)
+			}
+		}
+		if !found {
+			t.Fatalf("Hostname of %s should be %s", n.ID, n.Domain)
+		}
+	}
+}
+
+func TestGenerateInvalidIgnition(t *testing.T) {
+	c := loadTestConfig(t, testINIConfig)
+	dir := t.TempDir()
+	c.I.Render = true
+	c.I.Templates = filepath.Join(dir, "templates")
+	for _, name := range []string{"install-reboot", "k8s-controller", "k8s-worker"} {
+		writeTestFile(t, filepath.Join(c.I.Templates, name+".yaml"), "storage:\n  files: /etc/hostname\n")
+	}
+
+	output := filepath.Join(dir, "_output")
+	if err := c.Generate(output); err == nil || !strings.Contains(err.Error(), "Write ignition failed") {
+		t.Fatal("Generate should fail with invalid ignition, got", err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(output, ignitionOutputDir, "*.ign")); len(files) != 0 {
+		t.Fatalf("Invalid ignition should not be written: %v", files)
+	}
+}
@@ -0,0 +1,157 @@
+package lazy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"text/tabwriter"
+)
+
+type IPAMPool struct {
+	CIDR      string        `json:"cidr"`
+	Start     string        `json:"start"`
+	End       string        `json:"end"`
+	KeepStart string        `json:"keep_start"`
+	KeepEnd   string        `json:"keep_end"`
+	Assigned  []IPAMAddress `json:"assigned"`
+	Excluded  []IPAMAddress `json:"excluded"`
+	Free      int           `json:"free"`
+}
+
+type IPAMAddress struct {
+	IP        string `json:"ip"`
+	Node      string `json:"node,omitempty"`
+	MAC       string `json:"mac,omitempty"`
+	Interface string `json:"interface,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// IPAM reports the usage of every network pool, addresses which are
+// used by infrastructure such as gateway or VIP are listed as excluded,
+// they are reserved by allocator too.
+func (c *Config) IPAM() []IPAMPool {
+	nics := make(map[string]NodeInterface)
+	for _, node := range c.Nodes {
+		for _, nic := range node.Nics {
+			nics[nic.IP] = nic
+		}
+	}
+
+	excluded := c.excludedIPs()
+	reports := make([]IPAMPool, 0, len(c.Cls.pools))
+	for _, np := range c.Cls.pools {
+		ir := np.getKeepIPRange()
+		p := IPAMPool{
+			CIDR:      np.IPNet.String(),
+			Start:     np.startIP.String(),
+			End:       np.endIP.String(),
+			KeepStart: ir.Start.String(),
+			KeepEnd:   ir.End.String(),
+			Assigned:  make([]IPAMAddress, 0, len(np.pools)),
+			Excluded:  make([]IPAMAddress, 0),
+		}
+
+		used := make(map[uint32]bool)
+		for n, owner := range np.pools {
+			ip := uint32ToIPv4(n).String()
+			if isExcluded(excluded, ip) {
+				continue
+			}
+			nic := nics[ip]
+			p.Assigned = append(p.Assigned, IPAMAddress{
+				IP:        ip,
+				Node:      owner,
+				MAC:       nic.MAC,
+				Interface: nic.Interface,
+			})
+			used[n] = true
+		}
+
+		for _, e := range excluded {
+			ip := net.ParseIP(e.IP)
+			if !np.Contains(ip) {
+				continue
+			}
+			p.Excluded = append(p.Excluded, e)
+			used[ipv4ToUint32(ip)] = true
+		}
+
+		for n := np.startUint32; n+np.keep <= np.endUint32; n++ {
+			if !used[n] {
+				p.Free++
+			}
+		}
+
+		sortIPAMAddresses(p.Assigned)
+		sortIPAMAddresses(p.Excluded)
+		reports = append(reports, p)
+	}
+	return reports
+}
+
+func (c *Config) excludedIPs() []IPAMAddress {
+	ips := make([]IPAMAddress, 0, 3)
+	if len(c.N.Gateway) != 0 {
+		ips = append(ips, IPAMAddress{IP: c.N.Gateway, Reason: "gateway"})
+	}
+
+	if c.V != nil && c.V.Enable && len(c.V.VIP) != 0 {
+		ips = append(ips, IPAMAddress{IP: c.V.VIP, Reason: "vip"})
+	}
+
+	if c.M != nil && len(c.M.IP) != 0 {
+		ips = append(ips, IPAMAddress{IP: c.M.IP, Reason: "matchbox"})
+	}
+	return ips
+}
+
+func isExcluded(excluded []IPAMAddress, ip string) bool {
+	for _, e := range excluded {
+		if e.IP == ip {
+			return true
+		}
+	}
+	return false
+}
+
+func sortIPAMAddresses(addrs []IPAMAddress) {
+	sort.Slice(addrs, func(i, j int) bool {
+		return ipv4ToUint32(net.ParseIP(addrs[i].IP)) < ipv4ToUint32(net.ParseIP(addrs[j].IP))
+	})
+}
+
+func WriteIPAMJSON(w io.Writer, pools []IPAMPool) error {
+	bs, err := json.MarshalIndent(pools, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(w, string(bs))
+	return err
+}
+
+func WriteIPAMTable(w io.Writer, pools []IPAMPool) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for i, p := range pools {
+		if i != 0 {
+			fmt.Fprintln(tw)
+		}
+
+		fmt.Fprintf(tw, "CIDR:\t%s\n", p.CIDR)
+		fmt.Fprintf(tw, "Range:\t%s-%s\n", p.Start, p.End)
+		fmt.Fprintf(tw, "DHCP keep:\t%s-%s\n", p.KeepStart, p.KeepEnd)
+		fmt.Fprintf(tw, "Free:\t%d\n", p.Free)
+		fmt.Fprintln(tw)
+		fmt.Fprintln(tw, "IP\tNODE\tMAC\tINTERFACE")
+		for _, a := range p.Assigned {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", a.IP, a.Node, a.MAC, a.Interface)
+		}
+
+		for _, a := range p.Excluded {
+			fmt.Fprintf(tw, "%s\t(%s)\n", a.IP, a.Reason)
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,132 @@
+package lazy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testINIConfig = `
+domain_base=example.com
+nodes=ctl1,work1,work2
+
+[matchbox]
+ip=172.17.0.2
+url=http://matchbox.com:8080
+domain=matchbox.com
+
+[network]
+gateway=172.17.0.1
+ips=172.17.0.0/24:172.17.0.21-172.17.0.40,192.168.100.0/24:192.168.100.50
+dhcp_keep=10
+
+[vip]
+vip=172.17.0.30
+enable=true
+domain=vip.cluster.com
+
+[dns]
+dns=8.8.8.8
+
+[ctl1]
+mac=52:54:00:a1:9c:ae,52:54:00:a1:9c:af
+role=master
+
+[work1]
+mac=52:54:00:d7:99:c7
+ip=172.17.0.25
+role=minion
+
+[work2]
+mac=52:54:00:e7:0f:c7
+role=minion
+`
+
+func loadTestConfig(t *testing.T, content string) *Config {
+	file := filepath.Join(t.TempDir(), "lazy.ini")
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestIPAM(t *testing.T) {
+	c := loadTestConfig(t, testINIConfig)
+	pools := c.IPAM()
+	if len(pools) != 2 {
+		t.Fatalf("IPAM should report 2 pools, got %d", len(pools))
+	}
+
+	p := pools[0]
+	if p.CIDR != "172.17.0.0/24" || p.Start != "172.17.0.21" || p.End != "172.17.0.40" {
+		t.Fatalf("Pool range %s %s-%s is not correct", p.CIDR, p.Start, p.End)
+	}
+
+	if p.KeepStart != "172.17.0.31" || p.KeepEnd != "172.17.0.40" {
+		t.Fatalf("Pool keep range %s-%s is not correct", p.KeepStart, p.KeepEnd)
+	}
+
+	expected := map[string]string{
+		"172.17.0.21": "ctl1",
+		"172.17.0.22": "work2",
+		"172.17.0.25": "work1",
+	}
+	if len(p.Assigned) != len(expected) {
+		t.Fatalf("Pool should assign %d ips, got %v", len(expected), p.Assigned)
+	}
+	for _, a := range p.Assigned {
+		if expected[a.IP] != a.Node {
+			t.Fatalf("%s should be assigned to %s, not %s", a.IP, expected[a.IP], a.Node)
+		}
+	}
+
+	if len(p.Excluded) != 3 {
+		t.Fatalf("Gateway, vip and matchbox should be excluded, got %v", p.Excluded)
+	}
+
+	// 21-30 is allocatable, 3 assigned and vip excluded
+	if p.Free != 6 {
+		t.Fatalf("Pool should have 6 free ips, got %d", p.Free)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteIPAMJSON(&buf, pools); err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded []IPAMPool
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 2 || decoded[1].Assigned[0].MAC != "52:54:00:a1:9c:af" {
+		t.Fatalf("IPAM json is not correct: %s", buf.String())
+	}
+}
+
+func TestIPAMExcludedIPs(t *testing.T) {
+	content := strings.Replace(testINIConfig, "172.17.0.21-172.17.0.40", "172.17.0.29-172.17.0.40", 1)
+	content = strings.Replace(content, "dhcp_keep=10", "dhcp_keep=5", 1)
+	c := loadTestConfig(t, content)
+
+	for _, n := range c.Nodes {
+		if ip := n.Nics[0].IP; ip == c.V.VIP || ip == c.N.Gateway || ip == c.M.IP {
+			t.Fatalf("Excluded ip %s should not be allocated to %s", ip, n.ID)
+		}
+	}
+	if ip := c.Nodes[2].Nics[0].IP; ip != "172.17.0.31" {
+		t.Fatalf("work2 should skip vip and get 172.17.0.31, got %s", ip)
+	}
+
+	p := c.IPAM()[0]
+	if len(p.Assigned) != 3 || len(p.Excluded) != 3 || p.Free != 4 {
+		t.Fatalf("IPAM should assign 3 ips, exclude 3 and have 4 free, got %+v", p)
+	}
+}
@@ -0,0 +1,294 @@
+package lazy
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+const (
+	libvirtOutputDir = "libvirt"
+
+	defaultNodeMemory = 2048
+	defaultNodeCPU    = 2
+	defaultNodeDisk   = 15
+)
+
+type VMConfig struct {
+	// Driver is vm backend, libvirt or qemu
+	Driver string `ini:"driver"`
+	// URI is libvirt connection uri
+	URI string `ini:"uri"`
+	// Bridges are existing host bridges of each pool, pools without
+	// bridge get bridge created by libvirt.
+	Bridges []string `ini:"bridges"`
+	// Pool is libvirt storage pool of node disks
+	Pool string `ini:"pool"`
+	// Dir keeps disk, pid, monitor and serial log of qemu driver nodes
+	Dir string `ini:"dir"`
+	// OVMF is firmware of uefi nodes of qemu driver
+	OVMF string `ini:"ovmf"`
+}
+
+type libvirtNetwork struct {
+	XMLName xml.Name        `xml:"network"`
+	Name    string          `xml:"name"`
+	Forward *libvirtForward `xml:"forward,omitempty"`
+	Bridge  libvirtBridge   `xml:"bridge"`
+	IP      *libvirtIP      `xml:"ip,omitempty"`
+}
+
+type libvirtForward struct {
+	Mode string `xml:"mode,attr"`
+}
+
+type libvirtBridge struct {
+	Name  string `xml:"name,attr"`
+	STP   string `xml:"stp,attr,omitempty"`
+	Delay string `xml:"delay,attr,omitempty"`
+}
+
+type libvirtIP struct {
+	Address string `xml:"address,attr"`
+	Netmask string `xml:"netmask,attr"`
+}
+
+type libvirtVolume struct {
+	XMLName  xml.Name        `xml:"volume"`
+	Name     string          `xml:"name"`
+	Capacity libvirtCapacity `xml:"capacity"`
+	Format   libvirtFormat   `xml:"target>format"`
+}
+
+type libvirtCapacity struct {
+	Unit  string `xml:"unit,attr"`
+	Value int    `xml:",chardata"`
+}
+
+type libvirtFormat struct {
+	Type string `xml:"type,attr"`
+}
+
+type libvirtDomain struct {
+	XMLName    xml.Name        `xml:"domain"`
+	Type       string          `xml:"type,attr"`
+	Name       string          `xml:"name"`
+	Memory     libvirtCapacity `xml:"memory"`
+	VCPU       int             `xml:"vcpu"`
+	OS         libvirtOS       `xml:"os"`
+	Features   libvirtFeatures `xml:"features"`
+	CPU        libvirtCPU      `xml:"cpu"`
+	OnPoweroff string          `xml:"on_poweroff"`
+	Devices    libvirtDevices  `xml:"devices"`
+}
+
+type libvirtOS struct {
+	Firmware string        `xml:"firmware,attr,omitempty"`
+	Type     libvirtOSType `xml:"type"`
+}
+
+type libvirtOSType struct {
+	Arch  string `xml:"arch,attr"`
+	Value string `xml:",chardata"`
+}
+
+type libvirtFeatures struct {
+	ACPI struct{} `xml:"acpi"`
+	APIC struct{} `xml:"apic"`
+}
+
+type libvirtCPU struct {
+	Mode string `xml:"mode,attr"`
+}
+
+type libvirtDevices struct {
+	Disks      []libvirtDisk      `xml:"disk"`
+	Interfaces []libvirtInterface `xml:"interface"`
+	Serial     libvirtSerial      `xml:"serial"`
+	Console    libvirtConsole     `xml:"console"`
+}
+
+type libvirtDisk struct {
+	Type   string            `xml:"type,attr"`
+	Device string            `xml:"device,attr"`
+	Driver libvirtDriver     `xml:"driver"`
+	Source libvirtDiskSource `xml:"source"`
+	Target libvirtTarget     `xml:"target"`
+	Boot   *libvirtBoot      `xml:"boot,omitempty"`
+}
+
+type libvirtDriver struct {
+	Name string `xml:"name,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type libvirtDiskSource struct {
+	Pool   string `xml:"pool,attr"`
+	Volume string `xml:"volume,attr"`
+}
+
+type libvirtTarget struct {
+	Dev string `xml:"dev,attr"`
+	Bus string `xml:"bus,attr"`
+}
+
+type libvirtBoot struct {
+	Order int `xml:"order,attr"`
+}
+
+type libvirtInterface struct {
+	Type   string                 `xml:"type,attr"`
+	Source libvirtInterfaceSource `xml:"source"`
+	MAC    libvirtMAC             `xml:"mac"`
+	Model  libvirtModel           `xml:"model"`
+	Boot   *libvirtBoot           `xml:"boot,omitempty"`
+}
+
+type libvirtInterfaceSource struct {
+	Network string `xml:"network,attr"`
+}
+
+type libvirtMAC struct {
+	Address string `xml:"address,attr"`
+}
+
+type libvirtModel struct {
+	Type string `xml:"type,attr"`
+}
+
+type libvirtSerial struct {
+	Type   string            `xml:"type,attr"`
+	Target libvirtSerialPort `xml:"target"`
+}
+
+type libvirtSerialPort struct {
+	Type string `xml:"type,attr,omitempty"`
+	Port int    `xml:"port,attr"`
+}
+
+type libvirtConsole libvirtSerial
+
+func libvirtNetworkName(poolIndex int) string {
+	return fmt.Sprintf("lazykube-pool%d", poolIndex)
+}
+
+func libvirtVolumeName(n *Node) string {
+	return n.ID + ".qcow2"
+}
+
+// libvirtNetworks returns network of each pool. Network of existing
+// bridge only forwards to it, otherwise libvirt creates the bridge and
+// gives gateway of pool to host, dhcp is still served by lazykube.
+func (c *Config) libvirtNetworks() []libvirtNetwork {
+	networks := make([]libvirtNetwork, 0, len(c.Cls.pools))
+	for i, np := range c.Cls.pools {
+		ln := libvirtNetwork{Name: libvirtNetworkName(i)}
+		if i < len(c.VM.Bridges) && len(c.VM.Bridges[i]) != 0 {
+			ln.Forward = &libvirtForward{Mode: "bridge"}
+			ln.Bridge = libvirtBridge{Name: c.VM.Bridges[i]}
+			networks = append(networks, ln)
+			continue
+		}
+
+		ln.Bridge = libvirtBridge{Name: fmt.Sprintf("lazykube%d", i), STP: "on", Delay: "0"}
+		if len(c.N.Gateway) != 0 && np.Contains(net.ParseIP(c.N.Gateway)) {
+			ln.Forward = &libvirtForward{Mode: "nat"}
+			ln.IP = &libvirtIP{Address: c.N.Gateway, Netmask: net.IP(np.Mask).String()}
+		}
+		networks = append(networks, ln)
+	}
+	return networks
+}
+
+func (c *Config) libvirtVolume(n *Node) libvirtVolume {
+	return libvirtVolume{
+		Name:     libvirtVolumeName(n),
+		Capacity: libvirtCapacity{Unit: "GiB", Value: n.Disk},
+		Format:   libvirtFormat{Type: "qcow2"},
+	}
+}
+
+// libvirtDomain returns domain of node, which boots from network of
+// the first interface before disk. Disk is sata, so it is /dev/sda as
+// install templates expect.
+func (c *Config) libvirtDomain(n *Node) libvirtDomain {
+	d := libvirtDomain{
+		Type:       "kvm",
+		Name:       n.ID,
+		Memory:     libvirtCapacity{Unit: "MiB", Value: n.Memory},
+		VCPU:       n.CPU,
+		OS:         libvirtOS{Type: libvirtOSType{Arch: "x86_64", Value: "hvm"}},
+		CPU:        libvirtCPU{Mode: "host-passthrough"},
+		OnPoweroff: "preserve",
+	}
+	if n.Firmware == uefiFirmware {
+		d.OS.Firmware = "efi"
+	}
+
+	d.Devices.Disks = []libvirtDisk{{
+		Type:   "volume",
+		Device: "disk",
+		Driver: libvirtDriver{Name: "qemu", Type: "qcow2"},
+		Source: libvirtDiskSource{Pool: c.VM.Pool, Volume: libvirtVolumeName(n)},
+		Target: libvirtTarget{Dev: "sda", Bus: "sata"},
+		Boot:   &libvirtBoot{Order: 2},
+	}}
+
+	for i, mac := range n.MAC {
+		iface := libvirtInterface{
+			Type:   "network",
+			Source: libvirtInterfaceSource{Network: libvirtNetworkName(i)},
+			MAC:    libvirtMAC{Address: mac},
+			Model:  libvirtModel{Type: "virtio"},
+		}
+		if i == 0 {
+			iface.Boot = &libvirtBoot{Order: 1}
+		}
+		d.Devices.Interfaces = append(d.Devices.Interfaces, iface)
+	}
+
+	d.Devices.Serial = libvirtSerial{Type: "pty", Target: libvirtSerialPort{Port: 0}}
+	d.Devices.Console = libvirtConsole{Type: "pty", Target: libvirtSerialPort{Type: "serial", Port: 0}}
+	return d
+}
+
+func marshalLibvirtXML(v interface{}) ([]byte, error) {
+	bs, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(bs, '\n'), nil
+}
+
+// RenderVM writes libvirt network of each pool, volume and domain of
+// each node into libvirt of output path.
+func (c *Config) RenderVM(outputPath string) error {
+	dir := filepath.Join(outputPath, libvirtOutputDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	files := make(map[string]interface{})
+	for _, ln := range c.libvirtNetworks() {
+		files["network-"+ln.Name+".xml"] = ln
+	}
+	for _, n := range c.Nodes {
+		files[n.ID+"-volume.xml"] = c.libvirtVolume(n)
+		files[n.ID+".xml"] = c.libvirtDomain(n)
+	}
+
+	for name, v := range files {
+		bs, err := marshalLibvirtXML(v)
+		if err != nil {
+			return errors.New("Render " + name + " failed: " + err.Error())
+		}
+		if err = ioutil.WriteFile(filepath.Join(dir, name), bs, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
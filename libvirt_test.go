@@ -0,0 +1,64 @@
+package lazy
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "Update golden files of testdata")
+
+func TestRenderVM(t *testing.T) {
+	content := strings.Replace(testINIConfig, "role=master", "role=master\nfirmware=uefi\nmemory=4096\ncpu=4", 1)
+	c := loadTestConfig(t, content)
+
+	output := t.TempDir()
+	if err := c.RenderVM(output); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"ctl1.xml", "work1.xml", "work1-volume.xml",
+		"network-lazykube-pool0.xml", "network-lazykube-pool1.xml"} {
+		bs, err := ioutil.ReadFile(filepath.Join(output, libvirtOutputDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		golden := filepath.Join("testdata", "libvirt", name)
+		if *updateGolden {
+			if err = os.MkdirAll(filepath.Dir(golden), 0755); err == nil {
+				err = ioutil.WriteFile(golden, bs, 0644)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+
+		expected, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(bs) != string(expected) {
+			t.Fatalf("%s is not the same as golden file:\n%s", name, bs)
+		}
+	}
+}
+
+func TestLibvirtBridges(t *testing.T) {
+	c := loadTestConfig(t, testINIConfig+"\n[vm]\nbridges=docker0\n")
+	networks := c.libvirtNetworks()
+	if len(networks) != 2 {
+		t.Fatalf("Network of each pool should be rendered, got %v", networks)
+	}
+
+	if n := networks[0]; n.Forward.Mode != "bridge" || n.Bridge.Name != "docker0" || n.IP != nil {
+		t.Fatalf("Network of existing bridge is not correct: %v", n)
+	}
+	if n := networks[1]; n.Forward != nil || n.Bridge.Name != "lazykube1" || n.IP != nil {
+		t.Fatalf("Isolated network is not correct: %v", n)
+	}
+}
@@ -0,0 +1,243 @@
+package lazy
+
+import (
+	"errors"
+	"github.com/digitalocean/go-libvirt"
+	"net/url"
+)
+
+// libvirtRPC is the part of go-libvirt client which is used by rpcConn,
+// it is implemented by *libvirt.Libvirt.
+type libvirtRPC interface {
+	DomainLookupByName(name string) (libvirt.Domain, error)
+	DomainGetState(dom libvirt.Domain, flags uint32) (int32, int32, error)
+	DomainDefineXML(xml string) (libvirt.Domain, error)
+	DomainCreate(dom libvirt.Domain) error
+	DomainReboot(dom libvirt.Domain, flags libvirt.DomainRebootFlagValues) error
+	DomainShutdown(dom libvirt.Domain) error
+	DomainDestroy(dom libvirt.Domain) error
+	DomainUndefineFlags(dom libvirt.Domain, flags libvirt.DomainUndefineFlagsValues) error
+
+	NetworkLookupByName(name string) (libvirt.Network, error)
+	NetworkIsActive(net libvirt.Network) (int32, error)
+	NetworkDefineXML(xml string) (libvirt.Network, error)
+	NetworkCreate(net libvirt.Network) error
+	NetworkSetAutostart(net libvirt.Network, autostart int32) error
+
+	StoragePoolLookupByName(name string) (libvirt.StoragePool, error)
+	StoragePoolRefresh(pool libvirt.StoragePool, flags uint32) error
+	StorageVolLookupByName(pool libvirt.StoragePool, name string) (libvirt.StorageVol, error)
+	StorageVolCreateXML(pool libvirt.StoragePool, xml string, flags libvirt.StorageVolCreateFlags) (libvirt.StorageVol, error)
+	StorageVolDelete(vol libvirt.StorageVol, flags libvirt.StorageVolDeleteFlags) error
+}
+
+// libvirtDial connects libvirt daemon of uri by its rpc protocol, so
+// neither virsh nor cgo binding of libvirt is needed.
+var libvirtDial = func(uri string) (libvirtRPC, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, errors.New("Libvirt uri is not correct: " + err.Error())
+	}
+	return libvirt.ConnectToURI(u)
+}
+
+// rpcConn calls libvirt api by go-libvirt. Daemon is connected by the
+// first call, so backend can be created without libvirt. Missing objects
+// are found by error codes of libvirt.
+type rpcConn struct {
+	URI string
+	l   libvirtRPC
+}
+
+func (r *rpcConn) rpc() (libvirtRPC, error) {
+	if r.l != nil {
+		return r.l, nil
+	}
+
+	l, err := libvirtDial(r.URI)
+	if err != nil {
+		return nil, errors.New("Connect libvirt " + r.URI + " failed: " + err.Error())
+	}
+	r.l = l
+	return l, nil
+}
+
+// libvirtNotFound returns whether err is libvirt error of code.
+func libvirtNotFound(err error, code libvirt.ErrorNumber) bool {
+	var e libvirt.Error
+	return errors.As(err, &e) && e.Code == uint32(code)
+}
+
+func (r *rpcConn) domain(name string) (libvirtRPC, libvirt.Domain, error) {
+	l, err := r.rpc()
+	if err != nil {
+		return nil, libvirt.Domain{}, err
+	}
+	dom, err := l.DomainLookupByName(name)
+	return l, dom, err
+}
+
+// domainState treats running, paused and shutting down domains, which
+// are active, as running.
+func (r *rpcConn) domainState(name string) (vmState, error) {
+	l, dom, err := r.domain(name)
+	if libvirtNotFound(err, libvirt.ErrNoDomain) {
+		return vmNotFound, nil
+	}
+	if err != nil {
+		return vmNotFound, err
+	}
+
+	state, _, err := l.DomainGetState(dom, 0)
+	if err != nil {
+		return vmNotFound, err
+	}
+	switch libvirt.DomainState(state) {
+	case libvirt.DomainShutoff, libvirt.DomainCrashed:
+		return vmStopped, nil
+	}
+	return vmRunning, nil
+}
+
+func (r *rpcConn) defineDomain(xml []byte) error {
+	l, err := r.rpc()
+	if err != nil {
+		return err
+	}
+	_, err = l.DomainDefineXML(string(xml))
+	return err
+}
+
+func (r *rpcConn) startDomain(name string) error {
+	l, dom, err := r.domain(name)
+	if err != nil {
+		return err
+	}
+	return l.DomainCreate(dom)
+}
+
+func (r *rpcConn) rebootDomain(name string) error {
+	l, dom, err := r.domain(name)
+	if err != nil {
+		return err
+	}
+	return l.DomainReboot(dom, 0)
+}
+
+func (r *rpcConn) shutdownDomain(name string) error {
+	l, dom, err := r.domain(name)
+	if err != nil {
+		return err
+	}
+	return l.DomainShutdown(dom)
+}
+
+func (r *rpcConn) destroyDomain(name string) error {
+	l, dom, err := r.domain(name)
+	if err != nil {
+		return err
+	}
+	return l.DomainDestroy(dom)
+}
+
+// undefineDomain also removes nvram of uefi domain.
+func (r *rpcConn) undefineDomain(name string) error {
+	l, dom, err := r.domain(name)
+	if err != nil {
+		return err
+	}
+	return l.DomainUndefineFlags(dom, libvirt.DomainUndefineNvram)
+}
+
+func (r *rpcConn) network(name string) (libvirtRPC, libvirt.Network, error) {
+	l, err := r.rpc()
+	if err != nil {
+		return nil, libvirt.Network{}, err
+	}
+	net, err := l.NetworkLookupByName(name)
+	return l, net, err
+}
+
+func (r *rpcConn) networkState(name string) (vmState, error) {
+	l, net, err := r.network(name)
+	if libvirtNotFound(err, libvirt.ErrNoNetwork) {
+		return vmNotFound, nil
+	}
+	if err != nil {
+		return vmNotFound, err
+	}
+
+	active, err := l.NetworkIsActive(net)
+	if err != nil || active == 0 {
+		return vmStopped, err
+	}
+	return vmRunning, nil
+}
+
+func (r *rpcConn) defineNetwork(xml []byte) error {
+	l, err := r.rpc()
+	if err != nil {
+		return err
+	}
+	_, err = l.NetworkDefineXML(string(xml))
+	return err
+}
+
+func (r *rpcConn) startNetwork(name string) error {
+	l, net, err := r.network(name)
+	if err != nil {
+		return err
+	}
+	if err = l.NetworkCreate(net); err != nil {
+		return err
+	}
+	return l.NetworkSetAutostart(net, 1)
+}
+
+func (r *rpcConn) pool(name string) (libvirtRPC, libvirt.StoragePool, error) {
+	l, err := r.rpc()
+	if err != nil {
+		return nil, libvirt.StoragePool{}, err
+	}
+	pool, err := l.StoragePoolLookupByName(name)
+	return l, pool, err
+}
+
+// volumeExists refreshes pool first, so volumes removed out of libvirt
+// are not found.
+func (r *rpcConn) volumeExists(pool, name string) (bool, error) {
+	l, p, err := r.pool(pool)
+	if err != nil {
+		return false, err
+	}
+	if err = l.StoragePoolRefresh(p, 0); err != nil {
+		return false, err
+	}
+
+	_, err = l.StorageVolLookupByName(p, name)
+	if libvirtNotFound(err, libvirt.ErrNoStorageVol) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (r *rpcConn) createVolume(pool string, xml []byte) error {
+	l, p, err := r.pool(pool)
+	if err != nil {
+		return err
+	}
+	_, err = l.StorageVolCreateXML(p, string(xml), 0)
+	return err
+}
+
+func (r *rpcConn) deleteVolume(pool, name string) error {
+	l, p, err := r.pool(pool)
+	if err != nil {
+		return err
+	}
+	vol, err := l.StorageVolLookupByName(p, name)
+	if err != nil {
+		return err
+	}
+	return l.StorageVolDelete(vol, 0)
+}
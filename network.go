@@ -3,9 +3,12 @@ package lazy
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"hash/fnv"
 	"log"
 	"net"
 	"regexp"
+	"strings"
 )
 
 const bytePattern = "(?:[1-9]?[[:digit:]]|1[[:digit:]]{2}|2[0-4][[:digit:]]|25[0-5])"
@@ -14,6 +17,12 @@ const netmaskPattern = "(?:[1-2]?[[:digit:]]|3[0-2])"
 const cidrPattern = ipPattern + "(?:/" + netmaskPattern + ")"
 const ipPoolPattern = "^(?P<ip>" + ipPattern + ")(?:/(?P<netmask>" + netmaskPattern + "))?(?::(?P<startIP>" + ipPattern + ")(?:-(?P<endIP>" + ipPattern + "))?)?$"
 
+const (
+	sequentialAllocation = "sequential"
+	hashAllocation       = "hash"
+	defaultDHCPLease     = "1h"
+)
+
 var (
 	ipPoolKeepIP     = uint32(20)
 	ipReg            = regexp.MustCompile("^" + ipPattern + "$")
@@ -31,6 +40,13 @@ func validateIPv4(ip string) bool {
 	return ipReg.MatchString(ip)
 }
 
+func minIndex(i, length int) int {
+	if i < length {
+		return i
+	}
+	return length - 1
+}
+
 func ipv4ToUint32(ip net.IP) (n uint32) {
 	if ip == nil || ip.To4() == nil {
 		return n
@@ -50,6 +66,16 @@ func cidrLastIP(cidr net.IPNet) (ip net.IP) {
 	return uint32ToIPv4(ipv4ToUint32(cidr.IP) + uint32((1<<uint32(bits-ones))-1))
 }
 
+// reverseIPv4 returns the in-addr.arpa name of ip, which is used by
+// reverse dns lookup.
+func reverseIPv4(ip string) string {
+	ip4 := net.ParseIP(ip).To4()
+	if ip4 == nil {
+		return ""
+	}
+	return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa", ip4[3], ip4[2], ip4[1], ip4[0])
+}
+
 func sameCIDR(s, t string) bool {
 	cidr := t
 	if !cidrReg.MatchString(t) {
@@ -75,14 +101,22 @@ type Network struct {
 }
 
 func newNetwork(nc *NetworkConfig) (*Network, error) {
+	switch nc.Allocation {
+	case "":
+		nc.Allocation = sequentialAllocation
+	case sequentialAllocation, hashAllocation:
+	default:
+		return nil, errors.New("Unknown ip allocation mode: " + nc.Allocation)
+	}
+
 	n := &Network{
 		NetworkConfig: nc,
 		pools:         make([]networkPool, 0, len(nc.IPs)),
 	}
-	for _, pool := range nc.IPs {
-		keep := uint32(nc.DHCP_keep)
-		if nc.DHCP_keep <= 0 {
-			keep = ipPoolKeepIP
+	for i, pool := range nc.IPs {
+		keep := ipPoolKeepIP
+		if k := nc.DHCP_keep; len(k) != 0 && k[minIndex(i, len(k))] > 0 {
+			keep = uint32(k[minIndex(i, len(k))])
 		}
 
 		np, err := newNetworkPool(pool, keep)
@@ -95,17 +129,49 @@ func newNetwork(nc *NetworkConfig) (*Network, error) {
 			log.Println("Parse pool failed with: ", err)
 		}
 
+		np.lease = defaultDHCPLease
+		if l := nc.DHCP_lease; len(l) != 0 && len(l[minIndex(i, len(l))]) != 0 {
+			np.lease = l[minIndex(i, len(l))]
+		}
+
+		if i < len(nc.DHCP_interfaces) {
+			np.dhcpInterface = nc.DHCP_interfaces[i]
+		}
+
 		n.pools = append(n.pools, np)
 	}
 	return n, nil
 }
 
-func (n *Network) requestIP(mac string, poolIndex int) (net.IP, error) {
+// requestIP allocates one ip of the pool for the interface with mac,
+// the allocation mode decides how the ip is chosen.
+func (n *Network) requestIP(mac, owner string, poolIndex int) (net.IP, error) {
 	if poolIndex >= len(n.pools) {
-		return nil, errors.New("Only support for " + string(len(n.pools)) + " pools")
+		return nil, fmt.Errorf("Only support for %d pools", len(n.pools))
 	}
 
-	return n.pools[poolIndex].requestIP(mac)
+	if n.Allocation == hashAllocation {
+		return n.pools[poolIndex].hashIP(mac, owner)
+	}
+	return n.pools[poolIndex].requestIP(owner)
+}
+
+func (n *Network) reserveIP(ip string, poolIndex int, owner string) error {
+	if poolIndex >= len(n.pools) {
+		return fmt.Errorf("Only support for %d pools", len(n.pools))
+	}
+
+	return n.pools[poolIndex].reserveIP(net.ParseIP(ip), owner)
+}
+
+// excludeIP marks infrastructure ip such as gateway or VIP as used by
+// reason, so it is never allocated to nodes.
+func (n *Network) excludeIP(ip, reason string) {
+	for _, np := range n.pools {
+		if ipv4 := net.ParseIP(ip); np.Contains(ipv4) {
+			np.pools[ipv4ToUint32(ipv4)] = reason
+		}
+	}
 }
 
 func (n *Network) ContainIP(ip string, i int) bool {
@@ -116,11 +182,49 @@ func (n *Network) ContainIP(ip string, i int) bool {
 	return n.pools[i].Contains(net.ParseIP(ip))
 }
 
-func (n *Network) GetKeepIPRange() (ir ipRange, err error) {
-	if len(n.pools) == 0 {
-		return ir, errors.New("Do not have any ip pool")
+// GetDHCPRanges returns the dhcp dynamic range of every pool, each
+// range is tagged with the pool it belongs to.
+func (n *Network) GetDHCPRanges() []dhcpRange {
+	drs := make([]dhcpRange, 0, len(n.pools))
+	for i, np := range n.pools {
+		ir := np.getKeepIPRange()
+		dr := dhcpRange{
+			ipRange:   ir,
+			Tag:       fmt.Sprintf("pool%d", i),
+			Interface: np.dhcpInterface,
+			CIDR:      np.IPNet.String(),
+			Network:   np.IP.String(),
+			Netmask:   net.IP(np.Mask).String(),
+			Lease:     np.lease,
+		}
+
+		if len(n.Gateway) != 0 && np.Contains(net.ParseIP(n.Gateway)) {
+			dr.Gateway = n.Gateway
+		}
+		drs = append(drs, dr)
+	}
+	return drs
+}
+
+// LeaseOf returns the lease time of the pool which contains ip.
+func (n *Network) LeaseOf(ip string) string {
+	for _, np := range n.pools {
+		if np.Contains(net.ParseIP(ip)) {
+			return np.lease
+		}
+	}
+	return defaultDHCPLease
+}
+
+// PrefixOf returns the prefix length of the pool which contains ip.
+func (n *Network) PrefixOf(ip string) int {
+	for _, np := range n.pools {
+		if np.Contains(net.ParseIP(ip)) {
+			ones, _ := np.Mask.Size()
+			return ones
+		}
 	}
-	return n.pools[0].getKeepIPRange(), nil
+	return 24
 }
 
 type ipRange struct {
@@ -128,6 +232,21 @@ type ipRange struct {
 	End   net.IP
 }
 
+type dhcpRange struct {
+	ipRange
+	Tag       string
+	Interface string
+	CIDR      string
+	Network   string
+	Netmask   string
+	Gateway   string
+	Lease     string
+}
+
+func (ir ipRange) contains(n uint32) bool {
+	return ipv4ToUint32(ir.Start) <= n && n <= ipv4ToUint32(ir.End)
+}
+
 type networkPool struct {
 	net.IPNet
 	startIP     net.IP
@@ -135,8 +254,11 @@ type networkPool struct {
 	startUint32 uint32
 	endUint32   uint32
 	currentIP   uint32
-	pools       map[uint32]bool
+	pools       map[uint32]string
 	keep        uint32
+
+	lease         string
+	dhcpInterface string
 }
 
 func newNetworkPool(pool string, keep uint32) (np networkPool, err error) {
@@ -192,13 +314,17 @@ func newNetworkPool(pool string, keep uint32) (np networkPool, err error) {
 	}
 
 	if np.endIP == nil {
+		// broadcast address of network can not be handed out
 		np.endIP = cidrLastIP(np.IPNet)
+		if ones, bits := np.Mask.Size(); bits-ones > 1 {
+			np.endIP = uint32ToIPv4(ipv4ToUint32(np.endIP) - 1)
+		}
 	}
 
 	np.startUint32 = ipv4ToUint32(np.startIP)
 	np.endUint32 = ipv4ToUint32(np.endIP)
 	np.currentIP = np.startUint32
-	np.pools = make(map[uint32]bool)
+	np.pools = make(map[uint32]string)
 	np.keep = keep
 
 	if err == nil && keep > (np.endUint32-np.startUint32+1) {
@@ -208,25 +334,69 @@ func newNetworkPool(pool string, keep uint32) (np networkPool, err error) {
 	return np, err
 }
 
-func (np *networkPool) requestIP(mac string) (net.IP, error) {
-	if np.currentIP > (np.endUint32 - np.keep) {
-		for uint32IP, used := range np.pools {
-			if !used {
-				return uint32ToIPv4(uint32IP), nil
-			}
+// requestIP hands out the next address which is neither reserved by
+// a static node IP nor inside the DHCP keep range.
+func (np *networkPool) requestIP(owner string) (net.IP, error) {
+	for ; np.currentIP+np.keep <= np.endUint32; np.currentIP++ {
+		if _, used := np.pools[np.currentIP]; used {
+			continue
 		}
+
+		ip := uint32ToIPv4(np.currentIP)
+		np.pools[np.currentIP] = owner
+		np.currentIP = np.currentIP + 1
+		return ip, nil
+	}
+	return nil, ipIsNotEnough
+}
+
+// hashIP derives the ip from a stable hash of key, so the same key
+// always gets the same ip. Collisions are resolved by linear probing.
+func (np *networkPool) hashIP(key, owner string) (net.IP, error) {
+	if np.startUint32+np.keep > np.endUint32 {
 		return nil, ipIsNotEnough
 	}
 
-	ip := uint32ToIPv4(np.currentIP)
-	np.pools[np.currentIP] = true
-	np.currentIP = np.currentIP + 1
-	return ip, nil
+	size := np.endUint32 - np.keep - np.startUint32 + 1
+	h := fnv.New32a()
+	h.Write([]byte(strings.ToLower(key)))
+	offset := h.Sum32() % size
+	for i := uint32(0); i < size; i++ {
+		n := np.startUint32 + (offset+i)%size
+		if _, used := np.pools[n]; used {
+			continue
+		}
+
+		np.pools[n] = owner
+		return uint32ToIPv4(n), nil
+	}
+	return nil, ipIsNotEnough
+}
+
+// reserveIP marks a static IP as used by owner, it should be called
+// before any dynamic allocation of the pool.
+func (np *networkPool) reserveIP(ip net.IP, owner string) error {
+	if ip == nil || !np.Contains(ip) {
+		return fmt.Errorf("IP %v of node %s is not in network %v", ip, owner, np.IPNet)
+	}
+
+	n := ipv4ToUint32(ip)
+	if ir := np.getKeepIPRange(); ir.contains(n) {
+		return fmt.Errorf("IP %v of node %s overlaps with DHCP keep range %v-%v",
+			ip, owner, ir.Start, ir.End)
+	}
+
+	if o, used := np.pools[n]; used {
+		return fmt.Errorf("IP %v of node %s conflicts with node %s", ip, owner, o)
+	}
+
+	np.pools[n] = owner
+	return nil
 }
 
 func (np *networkPool) getKeepIPRange() (ir ipRange) {
 	return ipRange{
-		Start: uint32ToIPv4(np.endUint32 - np.keep),
+		Start: uint32ToIPv4(np.endUint32 - np.keep + 1),
 		End:   uint32ToIPv4(np.endUint32),
 	}
 }
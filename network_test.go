@@ -156,35 +156,35 @@ func TestNewNetworkPool(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	testPoolResult(t, p, "8.0.0.0/8", "8.0.0.1", "8.255.255.255")
+	testPoolResult(t, p, "8.0.0.0/8", "8.0.0.1", "8.255.255.254")
 
 	// Test default class B netmask
 	p, err = newNetworkPool("172.32.200.5", ipPoolKeepIP)
 	if err != nil {
 		t.Fatal(err)
 	}
-	testPoolResult(t, p, "172.32.0.0/16", "172.32.0.1", "172.32.255.255")
+	testPoolResult(t, p, "172.32.0.0/16", "172.32.0.1", "172.32.255.254")
 
 	// Test default class C netmask
 	p, err = newNetworkPool("192.168.0.5", ipPoolKeepIP)
 	if err != nil {
 		t.Fatal(err)
 	}
-	testPoolResult(t, p, "192.168.0.0/24", "192.168.0.1", "192.168.0.255")
+	testPoolResult(t, p, "192.168.0.0/24", "192.168.0.1", "192.168.0.254")
 
 	// Test custom netmask
 	p, err = newNetworkPool("192.168.0.5/14", ipPoolKeepIP)
 	if err != nil {
 		t.Fatal(err)
 	}
-	testPoolResult(t, p, "192.168.0.0/14", "192.168.0.1", "192.171.255.255")
+	testPoolResult(t, p, "192.168.0.0/14", "192.168.0.1", "192.171.255.254")
 
 	// Test custom netmask with start ip
 	p, err = newNetworkPool("192.168.0.5/16:192.168.250.87", ipPoolKeepIP)
 	if err != nil {
 		t.Fatal(err)
 	}
-	testPoolResult(t, p, "192.168.0.0/16", "192.168.250.87", "192.168.255.255")
+	testPoolResult(t, p, "192.168.0.0/16", "192.168.250.87", "192.168.255.254")
 
 	// Test custom netmask with end ip
 	p, err = newNetworkPool("192.168.0.5/16:192.168.0.87-192.168.5.144", ipPoolKeepIP)
@@ -199,7 +199,7 @@ func TestNewNetworkPool(t *testing.T) {
 	if err != startIPNotInCIDR {
 		t.Fatalf("%s should have startIPNotInCIDR error\n", s)
 	}
-	testPoolResult(t, p, "192.168.56.0/24", "192.168.56.1", "192.168.56.255")
+	testPoolResult(t, p, "192.168.56.0/24", "192.168.56.1", "192.168.56.254")
 
 	// Test netmask with wrong start ip
 	s = "192.168.0.5/16:172.0.0.5"
@@ -207,7 +207,7 @@ func TestNewNetworkPool(t *testing.T) {
 	if err != startIPNotInCIDR {
 		t.Fatalf("%s should have startIPNotInCIDR error\n", s)
 	}
-	testPoolResult(t, p, "192.168.0.0/16", "192.168.0.1", "192.168.255.255")
+	testPoolResult(t, p, "192.168.0.0/16", "192.168.0.1", "192.168.255.254")
 
 	// Test default netmask with wrong end ip
 	s = "192.168.0.5:192.168.0.2-192.168.2.5"
@@ -215,7 +215,7 @@ func TestNewNetworkPool(t *testing.T) {
 	if err != endIPNotInCIDR {
 		t.Fatalf("%s should have endIPNotInCIDR error\n", s)
 	}
-	testPoolResult(t, p, "192.168.0.0/24", "192.168.0.2", "192.168.0.255")
+	testPoolResult(t, p, "192.168.0.0/24", "192.168.0.2", "192.168.0.254")
 
 	// Test netmask with wrong end ip
 	s = "192.168.0.5/16:192.168.128.2-192.169.2.5"
@@ -223,7 +223,7 @@ func TestNewNetworkPool(t *testing.T) {
 	if err != endIPNotInCIDR {
 		t.Fatalf("%s should have endIPNotInCIDR error\n", s)
 	}
-	testPoolResult(t, p, "192.168.0.0/16", "192.168.128.2", "192.168.255.255")
+	testPoolResult(t, p, "192.168.0.0/16", "192.168.128.2", "192.168.255.254")
 
 	// Test netmask with smaller end ip
 	s = "192.168.0.5/16:192.168.128.10-192.168.128.1"
@@ -231,7 +231,7 @@ func TestNewNetworkPool(t *testing.T) {
 	if err != endIPTooSmall {
 		t.Fatalf("%s should have endIPTooSmall error\n", s)
 	}
-	testPoolResult(t, p, "192.168.0.0/16", "192.168.128.10", "192.168.255.255")
+	testPoolResult(t, p, "192.168.0.0/16", "192.168.128.10", "192.168.255.254")
 }
 
 func TestNetworkPoolRequestIP(t *testing.T) {
@@ -286,3 +286,161 @@ func TestNetworkPoolRequestIP(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestNetworkPoolReserveIP(t *testing.T) {
+	s := "10.0.0.0/24:10.0.0.10-10.0.0.20"
+	p, err := newNetworkPool(s, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = p.reserveIP(net.ParseIP("10.0.0.11"), "n1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = p.reserveIP(net.ParseIP("10.0.0.11"), "n2"); err == nil {
+		t.Fatalf("%s should not reserve same ip twice", s)
+	}
+
+	// 10.0.0.16-10.0.0.20 is kept for dhcp
+	if err = p.reserveIP(net.ParseIP("10.0.0.16"), "n3"); err == nil {
+		t.Fatalf("%s should not reserve ip in dhcp keep range", s)
+	}
+
+	if err = p.reserveIP(net.ParseIP("10.0.1.5"), "n4"); err == nil {
+		t.Fatalf("%s should not reserve ip out of network", s)
+	}
+
+	for _, expected := range []string{"10.0.0.10", "10.0.0.12", "10.0.0.13", "10.0.0.14", "10.0.0.15"} {
+		ip, err := p.requestIP("")
+		if err != nil {
+			t.Fatalf("%s request ip %s failed: %s", s, expected, err)
+		}
+		if ip.String() != expected {
+			t.Fatalf("%s request %s is not match with %s", s, ip, expected)
+		}
+	}
+
+	if _, err = p.requestIP(""); err != ipIsNotEnough {
+		t.Fatalf("%s should not request ip in dhcp keep range", s)
+	}
+}
+
+func TestNetworkPoolKeepIPRange(t *testing.T) {
+	p, err := newNetworkPool("10.0.0.0/24:10.0.0.10-10.0.0.20", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ir := p.getKeepIPRange()
+	if ir.Start.String() != "10.0.0.16" || ir.End.String() != "10.0.0.20" {
+		t.Fatalf("keep range %v-%v is not 10.0.0.16-10.0.0.20", ir.Start, ir.End)
+	}
+}
+
+func TestNetworkPoolHashIP(t *testing.T) {
+	s := "10.0.0.0/24:10.0.0.10-10.0.0.20"
+	newPool := func() networkPool {
+		p, err := newNetworkPool(s, 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	macs := []string{"52:54:00:a1:9c:ae", "52:54:00:b2:2f:86", "52:54:00:c3:61:77"}
+	p1, p2 := newPool(), newPool()
+	for _, mac := range macs {
+		ip1, err := p1.hashIP(mac, mac)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ip2, err := p2.hashIP(mac, mac)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !ip1.Equal(ip2) {
+			t.Fatalf("%s hash ip %s is not stable with %s", mac, ip1, ip2)
+		}
+
+		if n := ipv4ToUint32(ip1); n < p1.startUint32 || n+p1.keep > p1.endUint32 {
+			t.Fatalf("%s hash ip %s is out of allocation range", mac, ip1)
+		}
+	}
+
+	// Only 6 ips could be allocated, so probing must fill all of them
+	p := newPool()
+	used := make(map[string]bool)
+	for i := 0; i < 6; i++ {
+		ip, err := p.hashIP("same-key", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if used[ip.String()] {
+			t.Fatalf("%s hash ip %s is allocated twice", s, ip)
+		}
+		used[ip.String()] = true
+	}
+
+	if _, err := p.hashIP("same-key", ""); err != ipIsNotEnough {
+		t.Fatalf("%s should not have enough ip", s)
+	}
+}
+
+func TestNetworkDHCPRanges(t *testing.T) {
+	n, err := newNetwork(&NetworkConfig{
+		Gateway:         "172.17.0.1",
+		IPs:             []string{"172.17.0.0/24:172.17.0.21-172.17.0.99", "192.168.100.0/24:192.168.100.50", "10.0.0.0/24"},
+		DHCP_keep:       []int{20, 10},
+		DHCP_lease:      []string{"", "12h"},
+		DHCP_interfaces: []string{"eth0", "eth1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []dhcpRange{
+		{Tag: "pool0", Interface: "eth0", Gateway: "172.17.0.1", Lease: "1h"},
+		{Tag: "pool1", Interface: "eth1", Lease: "12h"},
+		{Tag: "pool2", Lease: "12h"},
+	}
+	starts := []string{"172.17.0.80", "192.168.100.245", "10.0.0.245"}
+	ends := []string{"172.17.0.99", "192.168.100.254", "10.0.0.254"}
+	drs := n.GetDHCPRanges()
+	if len(drs) != len(expected) {
+		t.Fatalf("Network should have %d dhcp ranges, got %d", len(expected), len(drs))
+	}
+
+	for i, dr := range drs {
+		e := expected[i]
+		if dr.Tag != e.Tag || dr.Interface != e.Interface || dr.Gateway != e.Gateway || dr.Lease != e.Lease {
+			t.Fatalf("DHCP range %v is not match with %v", dr, e)
+		}
+
+		if dr.Start.String() != starts[i] || dr.Netmask != "255.255.255.0" {
+			t.Fatalf("DHCP range %v should start from %s", dr, starts[i])
+		}
+
+		if dr.End.String() != ends[i] {
+			t.Fatalf("DHCP range %v should end at %s", dr, ends[i])
+		}
+	}
+
+	if l := n.LeaseOf("192.168.100.50"); l != "12h" {
+		t.Fatalf("Lease of 192.168.100.50 should be 12h, got %s", l)
+	}
+}
+
+func TestReverseIPv4(t *testing.T) {
+	testFunc := func(ip, name string) {
+		if reverseIPv4(ip) != name {
+			t.Fatalf("%s reverse name should be %s, got %s", ip, name, reverseIPv4(ip))
+		}
+	}
+
+	testFunc("172.17.0.21", "21.0.17.172.in-addr.arpa")
+	testFunc("192.168.100.5", "5.100.168.192.in-addr.arpa")
+	testFunc("not-an-ip", "")
+}
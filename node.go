@@ -5,6 +5,11 @@ import (
 	"fmt"
 )
 
+const (
+	biosFirmware = "bios"
+	uefiFirmware = "uefi"
+)
+
 type Node struct {
 	*NodeConfig
 	*Cluster
@@ -16,18 +21,34 @@ type Node struct {
 
 type NodeInterfaces []NodeInterface
 
+// groupTemplate returns matchbox group template of node role.
+func (node *Node) groupTemplate() (tmpl, name string) {
+	switch node.Role {
+	case "master":
+		return K8S_CONTROLLER_TMPL, "controller"
+	case "minion":
+		return K8S_WORKER_TMPL, "worker"
+	}
+	return NODE_TMPL, "Node " + node.ID
+}
+
+// staticIP returns the ip of the i-th interface declared in config,
+// only when it belongs to the relative network pool.
+func (node *Node) staticIP(c *Config, i int) (string, bool) {
+	if i < len(node.IP) && len(node.IP[i]) != 0 &&
+		c.Cls.ContainIP(node.IP[i], i) {
+		return node.IP[i], true
+	}
+	return "", false
+}
+
 func (node *Node) makeInterfaces(c *Config) (NodeInterfaces, error) {
 	nics := make(NodeInterfaces, 0, len(node.IP))
 	dhcpChose := false
 	for i, mac := range node.MAC {
-		var ip string
-		if i < len(node.IP) && len(node.IP[i]) != 0 &&
-			c.Cls.ContainIP(node.IP[i], i) {
-			ip = node.IP[i]
-		}
-
+		ip, _ := node.staticIP(c, i)
 		if len(ip) == 0 {
-			ipnet, err := c.Cls.requestIP(mac, i)
+			ipnet, err := c.Cls.requestIP(mac, node.ID, i)
 			if err != nil {
 				return nil, err
 			}
@@ -46,11 +67,18 @@ func (node *Node) makeInterfaces(c *Config) (NodeInterfaces, error) {
 			nic.Gateway = c.N.Gateway
 		}
 
-		if c.DHCP.Enable && !dhcpChose {
-			if len(c.DHCP.Interface) == 0 || nic.Interface == c.DHCP.Interface {
+		// Without dhcp interfaces, only the first interface uses dhcp
+		if c.DHCP.Enable {
+			if len(c.DHCP.Interface) == 0 && !dhcpChose {
 				nic.DHCP = true
 				dhcpChose = true
 			}
+
+			for _, iface := range c.DHCP.Interface {
+				if nic.Interface == iface {
+					nic.DHCP = true
+				}
+			}
 		}
 
 		if len(c.D.DNS) != 0 {
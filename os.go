@@ -0,0 +1,272 @@
+package lazy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+const (
+	coreosOS       = "coreos"
+	flatcarOS      = "flatcar"
+	fedoraCoreOSOS = "fedora-coreos"
+
+	profileOutputDir = "profiles"
+	// matchbox replaces these variables of ipxe in boot args
+	ipxeIgnitionQuery = "uuid=${uuid}&mac=${mac:hexhyp}"
+)
+
+type osProvider interface {
+	// installGroup returns id, name and metadata of install group
+	installGroup(c *Config) osInstallGroup
+	// kernel and initrd return pxe boot asset paths under matchbox
+	kernel(c *Config) string
+	initrd(c *Config) []string
+	// bootArgs returns kernel args of profile, installed is false for
+	// the profile which installs os to disk.
+	bootArgs(c *Config, installed bool) []string
+	// assets returns files which are downloaded into matchbox assets
+	assets(c *Config) osAssets
+}
+
+func newOSProvider(name string) (osProvider, error) {
+	switch name {
+	case coreosOS:
+		return coreosProvider{}, nil
+	case flatcarOS:
+		return flatcarProvider{}, nil
+	case fedoraCoreOSOS:
+		return fedoraCoreOSProvider{}, nil
+	}
+	return nil, errors.New("Unknown os: " + name)
+}
+
+type osMetadata struct {
+	Key   string
+	Value string
+}
+
+type osInstallGroup struct {
+	ID       string
+	Name     string
+	Metadata []osMetadata
+}
+
+// osAssets are files under Dir of matchbox assets, each file is signed
+// by detached signature of the same url with .sig suffix.
+type osAssets struct {
+	Dir     string
+	BaseURL string
+	Files   []string
+}
+
+type osProfile struct {
+	ID         string
+	Name       string
+	Kernel     string
+	Initrd     []string
+	Args       []string
+	IgnitionID string
+}
+
+// osProfiles returns install profile and profiles of kubernetes roles.
+func (c *Config) osProfiles() []osProfile {
+	profile := func(id, name string, installed bool) osProfile {
+		return osProfile{
+			ID:         id,
+			Name:       name,
+			Kernel:     c.os.kernel(c),
+			Initrd:     c.os.initrd(c),
+			Args:       c.os.bootArgs(c, installed),
+			IgnitionID: id + ".yaml",
+		}
+	}
+
+	return []osProfile{
+		profile("install-reboot", "Install OS and Reboot", false),
+		profile("k8s-controller", "Kubernetes Controller", true),
+		profile("k8s-worker", "Kubernetes Worker", true),
+	}
+}
+
+func (c *Config) generateOS(outputPath string) error {
+	err := writeTemplateToFile(OS_INSTALL_TMPL, "install",
+		filepath.Join(outputPath, "install.json"), c.os.installGroup(c))
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(outputPath, profileOutputDir)
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	for _, p := range c.osProfiles() {
+		err = writeTemplateToFile(PROFILE_TMPL, "profile",
+			filepath.Join(dir, p.ID+".json"), p)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// coreosProvider installs CoreOS Container Linux by coreos-install.
+type coreosProvider struct{}
+
+func (coreosProvider) installGroup(c *Config) osInstallGroup {
+	return osInstallGroup{
+		ID:   "coreos-install",
+		Name: "CoreOS Install",
+		Metadata: []osMetadata{
+			{"coreos_channel", c.Channel},
+			{"coreos_version", c.Version},
+			{"ignition_endpoint", c.M.URL + "/ignition"},
+			{"baseurl", c.M.URL + "/assets/coreos"},
+		},
+	}
+}
+
+func (coreosProvider) kernel(c *Config) string {
+	return "/assets/coreos/" + c.Version + "/coreos_production_pxe.vmlinuz"
+}
+
+func (coreosProvider) initrd(c *Config) []string {
+	return []string{"/assets/coreos/" + c.Version + "/coreos_production_pxe_image.cpio.gz"}
+}
+
+func (coreosProvider) bootArgs(c *Config, installed bool) []string {
+	return containerLinuxArgs("coreos", c, installed)
+}
+
+func (coreosProvider) assets(c *Config) osAssets {
+	return osAssets{
+		Dir:     "coreos/" + c.Version,
+		BaseURL: "https://" + c.Channel + ".release.core-os.net/amd64-usr/" + c.Version,
+		Files: []string{
+			"coreos_production_pxe.vmlinuz",
+			"coreos_production_pxe_image.cpio.gz",
+			"coreos_production_image.bin.bz2",
+		},
+	}
+}
+
+// flatcarProvider is drop-in replacement of Container Linux, it is
+// installed by flatcar-install with the same ignition templates.
+type flatcarProvider struct{}
+
+func (flatcarProvider) installGroup(c *Config) osInstallGroup {
+	return osInstallGroup{
+		ID:   "flatcar-install",
+		Name: "Flatcar Install",
+		Metadata: []osMetadata{
+			{"flatcar_channel", c.Channel},
+			{"flatcar_version", c.Version},
+			{"ignition_endpoint", c.M.URL + "/ignition"},
+			{"baseurl", c.M.URL + "/assets/flatcar"},
+		},
+	}
+}
+
+func (flatcarProvider) kernel(c *Config) string {
+	return "/assets/flatcar/" + c.Version + "/flatcar_production_pxe.vmlinuz"
+}
+
+func (flatcarProvider) initrd(c *Config) []string {
+	return []string{"/assets/flatcar/" + c.Version + "/flatcar_production_pxe_image.cpio.gz"}
+}
+
+func (flatcarProvider) bootArgs(c *Config, installed bool) []string {
+	return containerLinuxArgs("flatcar", c, installed)
+}
+
+func (flatcarProvider) assets(c *Config) osAssets {
+	return osAssets{
+		Dir:     "flatcar/" + c.Version,
+		BaseURL: "https://" + c.Channel + ".release.flatcar-linux.net/amd64-usr/" + c.Version,
+		Files: []string{
+			"flatcar_production_pxe.vmlinuz",
+			"flatcar_production_pxe_image.cpio.gz",
+			"flatcar_production_image.bin.bz2",
+		},
+	}
+}
+
+// containerLinuxArgs returns args of Container Linux and its derivation,
+// the installed system is still booted by pxe kernel with disk root.
+func containerLinuxArgs(prefix string, c *Config, installed bool) []string {
+	args := make([]string, 0, 6)
+	if installed {
+		args = append(args, "root=/dev/sda1")
+	}
+	return append(args,
+		prefix+".config.url="+c.M.URL+"/ignition?"+ipxeIgnitionQuery,
+		prefix+".first_boot=yes",
+		"console=tty0",
+		"console=ttyS0",
+		prefix+".autologin")
+}
+
+// fedoraCoreOSProvider installs Fedora CoreOS by coreos-installer of
+// live pxe image, channel is the stream of Fedora CoreOS.
+type fedoraCoreOSProvider struct{}
+
+func (fedoraCoreOSProvider) installGroup(c *Config) osInstallGroup {
+	return osInstallGroup{
+		ID:   "fedora-coreos-install",
+		Name: "Fedora CoreOS Install",
+		Metadata: []osMetadata{
+			{"fcos_stream", c.Channel},
+			{"fcos_version", c.Version},
+			{"ignition_endpoint", c.M.URL + "/ignition"},
+			{"baseurl", c.M.URL + "/assets/fedora-coreos"},
+		},
+	}
+}
+
+func (fedoraCoreOSProvider) assetPrefix(c *Config) string {
+	return "/assets/fedora-coreos/" + c.Version + "/fedora-coreos-" + c.Version + "-live-"
+}
+
+func (p fedoraCoreOSProvider) kernel(c *Config) string {
+	return p.assetPrefix(c) + "kernel-x86_64"
+}
+
+func (p fedoraCoreOSProvider) initrd(c *Config) []string {
+	return []string{p.assetPrefix(c) + "initramfs.x86_64.img"}
+}
+
+// bootArgs of installed profile are only used by nodes which still pxe
+// boot after install, they run the live image with installed ignition.
+func (p fedoraCoreOSProvider) bootArgs(c *Config, installed bool) []string {
+	ignitionURL := c.M.URL + "/ignition?" + ipxeIgnitionQuery + "&os=installed"
+	args := []string{
+		"coreos.live.rootfs_url=" + c.M.URL + p.assetPrefix(c) + "rootfs.x86_64.img",
+		"ignition.firstboot",
+		"ignition.platform.id=metal",
+		"console=tty0",
+		"console=ttyS0",
+	}
+	if installed {
+		return append(args, "ignition.config.url="+ignitionURL)
+	}
+	return append(args,
+		"coreos.inst.install_dev=/dev/sda",
+		"coreos.inst.ignition_url="+ignitionURL)
+}
+
+// assets of Fedora CoreOS are live images, rootfs is also the image
+// which coreos-installer installs.
+func (fedoraCoreOSProvider) assets(c *Config) osAssets {
+	prefix := "fedora-coreos-" + c.Version + "-live-"
+	return osAssets{
+		Dir: "fedora-coreos/" + c.Version,
+		BaseURL: "https://builds.coreos.fedoraproject.org/prod/streams/" + c.Channel +
+			"/builds/" + c.Version + "/x86_64",
+		Files: []string{
+			prefix + "kernel-x86_64",
+			prefix + "initramfs.x86_64.img",
+			prefix + "rootfs.x86_64.img",
+		},
+	}
+}
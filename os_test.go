@@ -0,0 +1,36 @@
+package lazy
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOSProviders(t *testing.T) {
+	testFunc := func(name, installID, kernel, installArg string) {
+		c := loadTestConfig(t, "os="+name+"\nversion=1.2.3\nchannel=stable\n"+testINIConfig)
+		if g := c.os.installGroup(c); g.ID != installID {
+			t.Fatalf("Install group of %s should be %s, got %s", name, installID, g.ID)
+		}
+
+		profiles := c.osProfiles()
+		if len(profiles) != 3 || profiles[0].ID != "install-reboot" {
+			t.Fatalf("Profiles of %s are not correct: %v", name, profiles)
+		}
+
+		if profiles[0].Kernel != kernel {
+			t.Fatalf("Kernel of %s should be %s, got %s", name, kernel, profiles[0].Kernel)
+		}
+
+		if !strings.Contains(strings.Join(profiles[0].Args, " "), installArg) {
+			t.Fatalf("Install args of %s should contain %s: %v", name, installArg, profiles[0].Args)
+		}
+	}
+
+	testFunc("coreos", "coreos-install", "/assets/coreos/1.2.3/coreos_production_pxe.vmlinuz",
+		"coreos.config.url=http://matchbox.com:8080/ignition?uuid=${uuid}&mac=${mac:hexhyp}")
+	testFunc("flatcar", "flatcar-install", "/assets/flatcar/1.2.3/flatcar_production_pxe.vmlinuz",
+		"flatcar.first_boot=yes")
+	testFunc("fedora-coreos", "fedora-coreos-install",
+		"/assets/fedora-coreos/1.2.3/fedora-coreos-1.2.3-live-kernel-x86_64",
+		"coreos.inst.ignition_url=http://matchbox.com:8080/ignition?uuid=${uuid}&mac=${mac:hexhyp}&os=installed")
+}
@@ -0,0 +1,184 @@
+package lazy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strings"
+)
+
+const (
+	// planInfraIPs is the number of ips kept at the beginning of every
+	// planned pool for gateway, vip and other infrastructure services.
+	planInfraIPs    = 10
+	planMinDHCPKeep = 10
+	planVIPDomain   = "vip.cluster.com"
+)
+
+var (
+	planPodNetworks     = []string{defaultPodNetwork, "10.244.0.0/16", "172.30.0.0/16", "100.64.0.0/16"}
+	planServiceIPRanges = []string{defaultServiceIPRange, "10.96.0.0/24", "172.31.0.0/24", "100.65.0.0/24"}
+)
+
+type PlanRequest struct {
+	Supernet string
+	Masters  int
+	Minions  int
+	Others   int
+	// Networks is the number of node networks, every node has one nic
+	// on each of them.
+	Networks int
+	// Headroom is the multiplier of node count used to size pools.
+	Headroom float64
+	// DHCPKeep is the size of dhcp dynamic range, it will be derived
+	// from node count when it is zero.
+	DHCPKeep int
+	// VIPDomain is domain of planned vip, controller endpoint uses it.
+	VIPDomain string
+}
+
+type Plan struct {
+	*PlanRequest
+	Gateway        string
+	Pools          []string
+	DHCPKeep       int
+	VIP            string
+	VIPDomain      string
+	PodNetwork     string
+	ServiceIPRange string
+	DNSServiceIP   string
+}
+
+// NewPlan proposes non-overlapping node pools inside the supernet and
+// kubernetes networks outside of it.
+func NewPlan(req *PlanRequest) (*Plan, error) {
+	_, supernet, err := net.ParseCIDR(req.Supernet)
+	if err != nil {
+		return nil, errors.New("Supernet format is not correct: " + req.Supernet)
+	}
+
+	nodes := req.Masters + req.Minions + req.Others
+	if nodes <= 0 {
+		return nil, errors.New("Plan needs at least one node")
+	}
+
+	if req.Headroom < 1 {
+		return nil, errors.New("Headroom should not be smaller than 1")
+	}
+
+	networks := req.Networks
+	if networks <= 0 {
+		networks = 1
+	}
+
+	p := &Plan{
+		PlanRequest: req,
+		DHCPKeep:    req.DHCPKeep,
+	}
+	if p.DHCPKeep <= 0 {
+		p.DHCPKeep = nodes
+		if p.DHCPKeep < planMinDHCPKeep {
+			p.DHCPKeep = planMinDHCPKeep
+		}
+	}
+
+	// network address, broadcast address, infrastructure ips,
+	// node ips with headroom and dhcp dynamic range
+	need := 2 + planInfraIPs + int(math.Ceil(float64(nodes)*req.Headroom)) + p.DHCPKeep
+	bits := uint32(0)
+	for (1 << bits) < need {
+		bits++
+	}
+
+	ones, _ := supernet.Mask.Size()
+	if 32-int(bits) < ones {
+		return nil, fmt.Errorf("Supernet %v is too small, each pool needs %d ips", supernet, need)
+	}
+
+	size := uint32(1) << bits
+	base := ipv4ToUint32(supernet.IP)
+	last := ipv4ToUint32(cidrLastIP(*supernet))
+	for i := 0; i < networks; i++ {
+		start := base + uint32(i)*size
+		end := start + size - 1
+		if end > last || end < start {
+			return nil, fmt.Errorf("Supernet %v can not hold %d pools of /%d", supernet, networks, 32-bits)
+		}
+
+		pool := fmt.Sprintf("%v/%d:%v-%v", uint32ToIPv4(start), 32-bits,
+			uint32ToIPv4(start+planInfraIPs), uint32ToIPv4(end-1))
+		if _, err = newNetworkPool(pool, uint32(p.DHCPKeep)); err != nil {
+			return nil, fmt.Errorf("Planned pool %s is not valid: %v", pool, err)
+		}
+		p.Pools = append(p.Pools, pool)
+	}
+
+	p.Gateway = uint32ToIPv4(base + 1).String()
+	if req.Masters > 1 {
+		p.VIP = uint32ToIPv4(base + 2).String()
+		p.VIPDomain = req.VIPDomain
+		if len(p.VIPDomain) == 0 {
+			p.VIPDomain = planVIPDomain
+		}
+	}
+
+	if p.PodNetwork, err = planCIDR(planPodNetworks, supernet); err != nil {
+		return nil, err
+	}
+
+	_, podNet, _ := net.ParseCIDR(p.PodNetwork)
+	if p.ServiceIPRange, err = planCIDR(planServiceIPRanges, supernet, podNet); err != nil {
+		return nil, err
+	}
+
+	_, serviceNet, _ := net.ParseCIDR(p.ServiceIPRange)
+	p.DNSServiceIP = uint32ToIPv4(ipv4ToUint32(serviceNet.IP) + 10).String()
+	return p, nil
+}
+
+func planCIDR(candidates []string, used ...*net.IPNet) (string, error) {
+	for _, cidr := range candidates {
+		_, ipnet, _ := net.ParseCIDR(cidr)
+		overlap := false
+		for _, u := range used {
+			if ipnet.Contains(u.IP) || u.Contains(ipnet.IP) {
+				overlap = true
+				break
+			}
+		}
+
+		if !overlap {
+			return cidr, nil
+		}
+	}
+	return "", fmt.Errorf("Can not find cidr which does not overlap with %v", used)
+}
+
+// WriteINI writes plan as a lazy ini fragment.
+func (p *Plan) WriteINI(w io.Writer) error {
+	vip := "enable=false\n"
+	if len(p.VIP) != 0 {
+		vip = fmt.Sprintf("enable=true\nvip=%s\ndomain=%s\n", p.VIP, p.VIPDomain)
+	}
+
+	_, err := fmt.Fprintf(w, `# Planned for %s with %d masters, %d minions and %d other nodes
+
+[network]
+gateway=%s
+ips=%s
+dhcp_keep=%d
+
+[vip]
+%s
+[kubernetes]
+pod_network=%s
+service_ip_range=%s
+dns_service_ip=%s
+`, p.Supernet, p.Masters, p.Minions, p.Others,
+		p.Gateway, strings.Join(p.Pools, ","), p.DHCPKeep,
+		vip,
+		p.PodNetwork, p.ServiceIPRange, p.DNSServiceIP)
+	return err
+}
@@ -0,0 +1,73 @@
+package lazy
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestNewPlan(t *testing.T) {
+	p, err := NewPlan(&PlanRequest{
+		Supernet: "172.17.0.0/16",
+		Masters:  3,
+		Minions:  2,
+		Networks: 2,
+		Headroom: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"172.17.0.0/27:172.17.0.10-172.17.0.30", "172.17.0.32/27:172.17.0.42-172.17.0.62"}
+	if len(p.Pools) != len(expected) || p.Pools[0] != expected[0] || p.Pools[1] != expected[1] {
+		t.Fatalf("Planned pools %v is not match with %v", p.Pools, expected)
+	}
+
+	if p.Gateway != "172.17.0.1" || p.VIP != "172.17.0.2" || p.DHCPKeep != 10 {
+		t.Fatalf("Planned gateway %s, vip %s or dhcp keep %d is not correct", p.Gateway, p.VIP, p.DHCPKeep)
+	}
+
+	if p.PodNetwork != defaultPodNetwork || p.ServiceIPRange != defaultServiceIPRange {
+		t.Fatalf("Planned kubernetes networks %s %s should be default", p.PodNetwork, p.ServiceIPRange)
+	}
+
+	// The ini fragment should be loadable as cluster config
+	var buf bytes.Buffer
+	if err = p.WriteINI(&buf); err != nil {
+		t.Fatal(err)
+	}
+	c := loadTestConfig(t, "nodes=ctl1\n\n[ctl1]\nmac=52:54:00:a1:9c:ae,52:54:00:a1:9c:af\nrole=master\n\n"+buf.String())
+	if c.Nodes[0].Nics[1].IP != "172.17.0.42" {
+		t.Fatalf("Node should get ip from planned pool, got %v", c.Nodes[0].Nics)
+	}
+	if !c.V.Enable || c.V.VIP != "172.17.0.2" || c.Cls.ControllerEndpoint != "https://vip.cluster.com" {
+		t.Fatalf("Planned vip should be controller endpoint, got %v %s", c.V, c.Cls.ControllerEndpoint)
+	}
+}
+
+func TestNewPlanAvoidOverlap(t *testing.T) {
+	p, err := NewPlan(&PlanRequest{Supernet: "10.0.0.0/8", Masters: 1, Minions: 100, Headroom: 1.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.PodNetwork != "172.30.0.0/16" || p.ServiceIPRange != "172.31.0.0/24" || p.DNSServiceIP != "172.31.0.10" {
+		t.Fatalf("Planned kubernetes networks %s %s %s overlap with supernet", p.PodNetwork, p.ServiceIPRange, p.DNSServiceIP)
+	}
+
+	if len(p.VIP) != 0 {
+		t.Fatalf("Single master should not plan vip %s", p.VIP)
+	}
+
+	var buf bytes.Buffer
+	if err = p.WriteINI(&buf); err != nil {
+		t.Fatal(err)
+	}
+	c := loadTestConfig(t, "nodes=ctl1\n\n[ctl1]\nmac=52:54:00:a1:9c:ae\nrole=master\n\n"+buf.String())
+	if c.V.Enable || c.Cls.ControllerEndpoint != "https://ctl1" {
+		t.Fatalf("Controller endpoint without vip should be master, got %s", c.Cls.ControllerEndpoint)
+	}
+
+	if _, err = NewPlan(&PlanRequest{Supernet: "192.168.0.0/28", Masters: 3, Headroom: 2}); err == nil {
+		t.Fatal("Small supernet should not be planned")
+	}
+}
@@ -0,0 +1,267 @@
+package lazy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"log"
+	"net"
+	"sort"
+	"strings"
+)
+
+const (
+	proxyDHCPPort = 67
+	// pxeDHCPPort is the port of pxe boot server discovery
+	pxeDHCPPort = 4011
+
+	dhcpBootRequest = 1
+	dhcpBootReply   = 2
+
+	dhcpDiscover = 1
+	dhcpOffer    = 2
+	dhcpRequest  = 3
+	dhcpAck      = 5
+
+	dhcpOptPad           = 0
+	dhcpOptVendorOpts    = 43
+	dhcpOptMessageType   = 53
+	dhcpOptServerID      = 54
+	dhcpOptVendorClass   = 60
+	dhcpOptUserClass     = 77
+	dhcpOptClientArch    = 93
+	dhcpOptClientMachine = 97
+	dhcpOptEnd           = 255
+
+	pxeVendorClass = "PXEClient"
+	// dhcpHeaderLen is the fixed length before magic cookie
+	dhcpHeaderLen = 236
+)
+
+var dhcpMagicCookie = []byte{99, 130, 83, 99}
+
+type dhcpPacket struct {
+	Op      byte
+	XID     []byte
+	Flags   []byte
+	CIAddr  net.IP
+	YIAddr  net.IP
+	SIAddr  net.IP
+	GIAddr  net.IP
+	CHAddr  net.HardwareAddr
+	File    string
+	Options map[byte][]byte
+}
+
+func parseDHCPPacket(bs []byte) (*dhcpPacket, error) {
+	if len(bs) < dhcpHeaderLen+len(dhcpMagicCookie) ||
+		!bytes.Equal(bs[dhcpHeaderLen:dhcpHeaderLen+4], dhcpMagicCookie) {
+		return nil, errors.New("DHCP packet is not correct")
+	}
+
+	hlen := int(bs[2])
+	if hlen > 16 {
+		return nil, errors.New("DHCP hardware address is too long")
+	}
+
+	p := &dhcpPacket{
+		Op:      bs[0],
+		XID:     bs[4:8],
+		Flags:   bs[10:12],
+		CIAddr:  net.IP(bs[12:16]),
+		YIAddr:  net.IP(bs[16:20]),
+		SIAddr:  net.IP(bs[20:24]),
+		GIAddr:  net.IP(bs[24:28]),
+		CHAddr:  net.HardwareAddr(bs[28 : 28+hlen]),
+		File:    string(bytes.TrimRight(bs[108:236], "\x00")),
+		Options: make(map[byte][]byte),
+	}
+
+	opts := bs[dhcpHeaderLen+4:]
+	for i := 0; i < len(opts); {
+		code := opts[i]
+		if code == dhcpOptEnd {
+			break
+		}
+		if code == dhcpOptPad {
+			i++
+			continue
+		}
+
+		if i+2 > len(opts) || i+2+int(opts[i+1]) > len(opts) {
+			return nil, errors.New("DHCP option is truncated")
+		}
+		n := int(opts[i+1])
+		p.Options[code] = append(p.Options[code], opts[i+2:i+2+n]...)
+		i += 2 + n
+	}
+	return p, nil
+}
+
+func (p *dhcpPacket) marshal() []byte {
+	bs := make([]byte, dhcpHeaderLen, 300)
+	bs[0] = p.Op
+	bs[1] = 1 // ethernet
+	bs[2] = byte(len(p.CHAddr))
+	copy(bs[4:8], p.XID)
+	copy(bs[10:12], p.Flags)
+	copy(bs[12:16], p.CIAddr.To4())
+	copy(bs[16:20], p.YIAddr.To4())
+	copy(bs[20:24], p.SIAddr.To4())
+	copy(bs[24:28], p.GIAddr.To4())
+	copy(bs[28:44], p.CHAddr)
+	copy(bs[108:236], p.File)
+	bs = append(bs, dhcpMagicCookie...)
+
+	// Keep option order stable, message type should be the first one
+	codes := make([]int, 0, len(p.Options))
+	for code := range p.Options {
+		if code != dhcpOptMessageType {
+			codes = append(codes, int(code))
+		}
+	}
+	sort.Ints(codes)
+	if _, ok := p.Options[dhcpOptMessageType]; ok {
+		codes = append([]int{dhcpOptMessageType}, codes...)
+	}
+
+	for _, code := range codes {
+		v := p.Options[byte(code)]
+		bs = append(bs, byte(code), byte(len(v)))
+		bs = append(bs, v...)
+	}
+	return append(bs, dhcpOptEnd)
+}
+
+func (p *dhcpPacket) messageType() byte {
+	if v := p.Options[dhcpOptMessageType]; len(v) == 1 {
+		return v[0]
+	}
+	return 0
+}
+
+// clientArch returns client system architecture of option 93.
+func (p *dhcpPacket) clientArch() (uint16, bool) {
+	if v := p.Options[dhcpOptClientArch]; len(v) >= 2 {
+		return binary.BigEndian.Uint16(v), true
+	}
+	return 0, false
+}
+
+func (p *dhcpPacket) isIPXE() bool {
+	return string(p.Options[dhcpOptUserClass]) == ipxeUserClass
+}
+
+// ProxyDHCPServer only answers boot file of pxe clients, ip addresses
+// are still handed out by other dhcp server.
+type ProxyDHCPServer struct {
+	// ServerIP is the tftp server and the dhcp server identifier
+	ServerIP net.IP
+	records  *dhcpRecords
+}
+
+func newProxyDHCPServer(r *dhcpRecords) (*ProxyDHCPServer, error) {
+	ip := net.ParseIP(r.NextServer).To4()
+	if ip == nil {
+		return nil, errors.New("Next server of proxy dhcp is not correct: " + r.NextServer)
+	}
+	return &ProxyDHCPServer{ServerIP: ip, records: r}, nil
+}
+
+// Serve answers packets of conn until conn is closed.
+func (s *ProxyDHCPServer) Serve(conn net.PacketConn) error {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+
+		p, err := parseDHCPPacket(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		reply := s.reply(p)
+		if reply == nil {
+			continue
+		}
+
+		if _, err = conn.WriteTo(reply.marshal(), proxyDHCPReplyAddr(p, addr)); err != nil {
+			log.Println("Reply proxy dhcp to", p.CHAddr, "failed:", err)
+		}
+	}
+}
+
+// reply returns offer of discover and ack of request, it returns nil
+// when packet should be ignored.
+func (s *ProxyDHCPServer) reply(p *dhcpPacket) *dhcpPacket {
+	if p.Op != dhcpBootRequest ||
+		!strings.HasPrefix(string(p.Options[dhcpOptVendorClass]), pxeVendorClass) {
+		return nil
+	}
+
+	var msgType byte
+	switch p.messageType() {
+	case dhcpDiscover:
+		msgType = dhcpOffer
+	case dhcpRequest:
+		// Requests to real dhcp server carry its server identifier
+		if id := p.Options[dhcpOptServerID]; len(id) != 0 && !net.IP(id).Equal(s.ServerIP) {
+			return nil
+		}
+		msgType = dhcpAck
+	default:
+		return nil
+	}
+
+	h := s.records.hostByMAC(p.CHAddr.String())
+	if h == nil {
+		log.Println("Ignore proxy dhcp of unknown mac", p.CHAddr)
+		return nil
+	}
+
+	arch, hasArch := p.clientArch()
+	file, httpBoot := s.records.bootFile(h, arch, hasArch, p.isIPXE())
+
+	vendorClass := pxeVendorClass
+	if httpBoot {
+		vendorClass = httpClientVendorClass
+	}
+
+	reply := &dhcpPacket{
+		Op:     dhcpBootReply,
+		XID:    p.XID,
+		Flags:  p.Flags,
+		CIAddr: p.CIAddr,
+		YIAddr: net.IPv4zero,
+		SIAddr: s.ServerIP,
+		GIAddr: p.GIAddr,
+		CHAddr: p.CHAddr,
+		File:   file,
+		Options: map[byte][]byte{
+			dhcpOptMessageType: {msgType},
+			dhcpOptServerID:    s.ServerIP.To4(),
+			dhcpOptVendorClass: []byte(vendorClass),
+			// pxe discovery control, boot the file of this reply
+			dhcpOptVendorOpts: {6, 1, 8, dhcpOptEnd},
+		},
+	}
+	if guid, ok := p.Options[dhcpOptClientMachine]; ok {
+		reply.Options[dhcpOptClientMachine] = guid
+	}
+	return reply
+}
+
+// proxyDHCPReplyAddr broadcasts reply to clients without address,
+// relayed packets are replied to relay agent.
+func proxyDHCPReplyAddr(p *dhcpPacket, addr net.Addr) net.Addr {
+	if p.GIAddr != nil && !p.GIAddr.IsUnspecified() {
+		return &net.UDPAddr{IP: p.GIAddr, Port: proxyDHCPPort}
+	}
+
+	if ua, ok := addr.(*net.UDPAddr); ok && (ua.IP == nil || ua.IP.IsUnspecified()) {
+		return &net.UDPAddr{IP: net.IPv4bcast, Port: proxyDHCPPort + 1}
+	}
+	return addr
+}
@@ -0,0 +1,114 @@
+package lazy
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func testPXEDiscover(mac string, arch uint16, userClass string) *dhcpPacket {
+	hw, _ := net.ParseMAC(mac)
+	p := &dhcpPacket{
+		Op:     dhcpBootRequest,
+		XID:    []byte{1, 2, 3, 4},
+		Flags:  []byte{0x80, 0},
+		CHAddr: hw,
+		Options: map[byte][]byte{
+			dhcpOptMessageType: {dhcpDiscover},
+			dhcpOptVendorClass: []byte("PXEClient:Arch:00000:UNDI:002001"),
+			dhcpOptClientArch:  {byte(arch >> 8), byte(arch)},
+		},
+	}
+	if len(userClass) != 0 {
+		p.Options[dhcpOptUserClass] = []byte(userClass)
+	}
+	return p
+}
+
+func TestProxyDHCPServe(t *testing.T) {
+	c := loadTestConfig(t, testINIConfig)
+	r, err := c.dhcpRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := newProxyDHCPServer(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go s.Serve(conn)
+
+	client, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	testFunc := func(p *dhcpPacket, file, vendorClass string) {
+		if _, err := client.WriteTo(p.marshal(), conn.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+
+		buf := make([]byte, 1500)
+		client.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := client.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("Proxy dhcp should reply %v: %v", p.CHAddr, err)
+		}
+
+		reply, err := parseDHCPPacket(buf[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if reply.messageType() != dhcpOffer || !reply.YIAddr.IsUnspecified() ||
+			!reply.SIAddr.Equal(net.ParseIP("172.17.0.2")) {
+			t.Fatalf("Proxy dhcp offer is not correct: %v", reply)
+		}
+
+		if reply.File != file || string(reply.Options[dhcpOptVendorClass]) != vendorClass {
+			t.Fatalf("Boot file should be %s with %s, got %s with %s",
+				file, vendorClass, reply.File, reply.Options[dhcpOptVendorClass])
+		}
+	}
+
+	testFunc(testPXEDiscover("52:54:00:a1:9c:ae", 0, ""), ipxeBootFile, pxeVendorClass)
+	testFunc(testPXEDiscover("52:54:00:a1:9c:ae", 7, ""), ipxeEFIBootFile, pxeVendorClass)
+	testFunc(testPXEDiscover("52:54:00:a1:9c:ae", 16, ""), r.HTTPBootURL, httpClientVendorClass)
+	testFunc(testPXEDiscover("52:54:00:d7:99:c7", 0, "iPXE"), r.BootURL, pxeVendorClass)
+
+	if reply := s.reply(testPXEDiscover("52:54:00:00:00:01", 0, "")); reply != nil {
+		t.Fatalf("Proxy dhcp should ignore unknown mac: %v", reply)
+	}
+
+	request := testPXEDiscover("52:54:00:a1:9c:ae", 0, "")
+	request.Options[dhcpOptMessageType] = []byte{dhcpRequest}
+	request.Options[dhcpOptServerID] = net.ParseIP("172.17.0.1").To4()
+	if reply := s.reply(request); reply != nil {
+		t.Fatalf("Proxy dhcp should ignore request of other server: %v", reply)
+	}
+
+	delete(request.Options, dhcpOptServerID)
+	if reply := s.reply(request); reply == nil || reply.messageType() != dhcpAck {
+		t.Fatalf("Proxy dhcp should ack boot server request: %v", reply)
+	}
+}
+
+func TestProxyDHCPReplyAddr(t *testing.T) {
+	p := &dhcpPacket{GIAddr: net.IPv4zero}
+	addr := proxyDHCPReplyAddr(p, &net.UDPAddr{IP: net.IPv4zero, Port: 68})
+	if addr.String() != "255.255.255.255:68" {
+		t.Fatalf("Reply of client without address should be broadcast, got %v", addr)
+	}
+
+	p.GIAddr = net.ParseIP("10.0.0.1").To4()
+	if addr = proxyDHCPReplyAddr(p, &net.UDPAddr{IP: net.IPv4zero, Port: 68}); addr.String() != "10.0.0.1:67" {
+		t.Fatalf("Reply of relayed packet should be sent to relay, got %v", addr)
+	}
+}
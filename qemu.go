@@ -0,0 +1,287 @@
+package lazy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	qemuPIDFile     = "qemu.pid"
+	qemuMonitorFile = "monitor.sock"
+	qemuSerialFile  = "serial.log"
+
+	qemuMonitorPrompt = "(qemu) "
+)
+
+// qemuTimeout is how long qemu is waited to quit
+var qemuTimeout = 10 * time.Second
+
+// qemuRunner is the part of host which is used by qemu backend.
+type qemuRunner interface {
+	createDisk(file string, size int) error
+	// launch runs qemu, which daemonizes itself after it writes pid file
+	launch(args []string) error
+	alive(pid int) bool
+	// monitor sends command to human monitor of qemu
+	monitor(socket, command string) error
+}
+
+type execQEMURunner struct{}
+
+func (execQEMURunner) createDisk(file string, size int) error {
+	out, err := exec.Command("qemu-img", "create", "-f", "qcow2", file, strconv.Itoa(size)+"G").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("Create disk %s failed: %v %s", file, err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+func (execQEMURunner) launch(args []string) error {
+	out, err := exec.Command("qemu-system-x86_64", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("Launch qemu failed: %v %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// alive sends signal 0 to pid, which is never delivered on windows.
+func (execQEMURunner) alive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return p.Signal(syscall.Signal(0)) == nil
+}
+
+func (execQEMURunner) monitor(socket, command string) error {
+	conn, err := net.DialTimeout("unix", socket, 3*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+
+	if err = readQEMUPrompt(conn); err != nil {
+		return err
+	}
+	if _, err = conn.Write([]byte(command + "\n")); err != nil {
+		return err
+	}
+
+	// qemu closes monitor without prompt when it quits
+	if err = readQEMUPrompt(conn); err != nil && command == "quit" {
+		return nil
+	}
+	return err
+}
+
+func readQEMUPrompt(conn net.Conn) error {
+	var out []byte
+	buf := make([]byte, 512)
+	for !bytes.HasSuffix(out, []byte(qemuMonitorPrompt)) {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return err
+		}
+		out = append(out, buf[:n]...)
+	}
+	return nil
+}
+
+// qemuBackend launches qemu of each node without libvirt. Disk, pid file,
+// monitor socket and serial log of node are kept in node dir of state
+// dir, so nodes can be managed by later commands.
+type qemuBackend struct {
+	c   *Config
+	run qemuRunner
+}
+
+func (b *qemuBackend) file(n *Node, name string) string {
+	return filepath.Join(b.c.VM.Dir, n.ID, name)
+}
+
+func (b *qemuBackend) pid(n *Node) (int, error) {
+	bs, err := ioutil.ReadFile(b.file(n, qemuPIDFile))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(bs)))
+}
+
+func (b *qemuBackend) status(n *Node) (vmState, error) {
+	if pid, err := b.pid(n); err == nil && b.run.alive(pid) {
+		return vmRunning, nil
+	}
+
+	_, err := os.Stat(b.file(n, libvirtVolumeName(n)))
+	if err == nil {
+		return vmStopped, nil
+	}
+	if os.IsNotExist(err) {
+		return vmNotFound, nil
+	}
+	return vmNotFound, err
+}
+
+// qemuArgs returns arguments of qemu, which boots from network of the
+// first interface before disk like libvirt domain. Interfaces of pools
+// with bridge are taps on the bridge, others are user-mode networks
+// whose dhcp chains ipxe into matchbox.
+func (b *qemuBackend) qemuArgs(n *Node) []string {
+	args := []string{
+		"-name", n.ID,
+		"-machine", "q35,accel=kvm:tcg",
+		"-cpu", "max",
+		"-m", strconv.Itoa(n.Memory),
+		"-smp", strconv.Itoa(n.CPU),
+		"-nodefaults",
+		"-display", "none",
+		"-daemonize",
+		"-pidfile", b.file(n, qemuPIDFile),
+		"-monitor", "unix:" + b.file(n, qemuMonitorFile) + ",server,nowait",
+		"-chardev", "file,id=serial0,path=" + b.file(n, qemuSerialFile) + ",append=on",
+		"-serial", "chardev:serial0",
+	}
+	if n.Firmware == uefiFirmware {
+		args = append(args, "-bios", b.c.VM.OVMF)
+	}
+
+	args = append(args,
+		"-drive", "file="+b.file(n, libvirtVolumeName(n))+",format=qcow2,if=none,id=disk0",
+		"-device", "ide-hd,drive=disk0,bus=ide.0,bootindex=2")
+
+	for i, mac := range n.MAC {
+		netdev := fmt.Sprintf("user,id=net%d", i)
+		if i < len(b.c.VM.Bridges) && len(b.c.VM.Bridges[i]) != 0 {
+			netdev = fmt.Sprintf("bridge,id=net%d,br=%s", i, b.c.VM.Bridges[i])
+		} else if i == 0 {
+			netdev += ",bootfile=" + b.c.M.URL + "/boot.ipxe"
+		}
+
+		device := fmt.Sprintf("virtio-net-pci,netdev=net%d,mac=%s", i, mac)
+		if i == 0 {
+			device += ",bootindex=1"
+		}
+		args = append(args, "-netdev", netdev, "-device", device)
+	}
+	return args
+}
+
+func (b *qemuBackend) launch(n *Node) error {
+	os.Remove(b.file(n, qemuPIDFile))
+	os.Remove(b.file(n, qemuMonitorFile))
+	return b.run.launch(b.qemuArgs(n))
+}
+
+func (b *qemuBackend) create(n *Node) error {
+	if err := os.MkdirAll(filepath.Join(b.c.VM.Dir, n.ID), 0755); err != nil {
+		return err
+	}
+
+	state, err := b.status(n)
+	if err != nil {
+		return err
+	}
+	if state == vmNotFound {
+		if err = b.run.createDisk(b.file(n, libvirtVolumeName(n)), n.Disk); err != nil {
+			return err
+		}
+	}
+	if state != vmRunning {
+		return b.launch(n)
+	}
+	return nil
+}
+
+// existingVM returns state of vm, it fails when vm is not created.
+func (b *qemuBackend) existingVM(n *Node) (vmState, error) {
+	state, err := b.status(n)
+	if err == nil && state == vmNotFound {
+		err = errors.New("VM " + n.ID + " is not created")
+	}
+	return state, err
+}
+
+func (b *qemuBackend) start(n *Node) error {
+	state, err := b.existingVM(n)
+	if err != nil || state == vmRunning {
+		return err
+	}
+	return b.launch(n)
+}
+
+// reboot starts stopped vm, so node boots again in any state.
+func (b *qemuBackend) reboot(n *Node) error {
+	state, err := b.existingVM(n)
+	if err != nil {
+		return err
+	}
+	if state == vmRunning {
+		return b.run.monitor(b.file(n, qemuMonitorFile), "system_reset")
+	}
+	return b.launch(n)
+}
+
+func (b *qemuBackend) shutdown(n *Node) error {
+	state, err := b.status(n)
+	if err != nil || state != vmRunning {
+		return err
+	}
+	return b.run.monitor(b.file(n, qemuMonitorFile), "system_powerdown")
+}
+
+func (b *qemuBackend) poweroff(n *Node) error {
+	state, err := b.status(n)
+	if err != nil || state != vmRunning {
+		return err
+	}
+
+	if err = b.run.monitor(b.file(n, qemuMonitorFile), "quit"); err != nil {
+		return err
+	}
+	for deadline := time.Now().Add(qemuTimeout); ; time.Sleep(100 * time.Millisecond) {
+		if state, err = b.status(n); err != nil || state != vmRunning {
+			break
+		}
+		if time.Now().After(deadline) {
+			return errors.New("VM " + n.ID + " does not quit")
+		}
+	}
+	os.Remove(b.file(n, qemuPIDFile))
+	os.Remove(b.file(n, qemuMonitorFile))
+	return err
+}
+
+// destroy removes node dir, serial log is removed with disk.
+func (b *qemuBackend) destroy(n *Node) error {
+	if err := b.poweroff(n); err != nil {
+		return err
+	}
+	return os.RemoveAll(filepath.Join(b.c.VM.Dir, n.ID))
+}
+
+func (b *qemuBackend) deleteVolume(n *Node) error {
+	state, err := b.status(n)
+	if err != nil {
+		return err
+	}
+	if state == vmRunning {
+		return errors.New("Disk of running vm " + n.ID + " can not be deleted")
+	}
+
+	err = os.Remove(b.file(n, libvirtVolumeName(n)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,154 @@
+package lazy
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeQEMU runs processes in memory, pid of vm is found by its monitor.
+type fakeQEMU struct {
+	nextPID  int
+	alives   map[int]bool
+	monitors map[string]int
+	calls    []string
+}
+
+func newFakeQEMU() *fakeQEMU {
+	return &fakeQEMU{nextPID: 100, alives: make(map[int]bool), monitors: make(map[string]int)}
+}
+
+func (q *fakeQEMU) createDisk(file string, size int) error {
+	q.calls = append(q.calls, "disk "+filepath.Base(file)+" "+strconv.Itoa(size))
+	return ioutil.WriteFile(file, nil, 0644)
+}
+
+func argOf(args []string, name string) string {
+	for i := 0; i+1 < len(args); i++ {
+		if args[i] == name {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+func (q *fakeQEMU) launch(args []string) error {
+	q.nextPID++
+	q.calls = append(q.calls, "launch "+argOf(args, "-name"))
+	q.alives[q.nextPID] = true
+	monitor := strings.TrimSuffix(strings.TrimPrefix(argOf(args, "-monitor"), "unix:"), ",server,nowait")
+	q.monitors[monitor] = q.nextPID
+	return ioutil.WriteFile(argOf(args, "-pidfile"), []byte(strconv.Itoa(q.nextPID)+"\n"), 0644)
+}
+
+func (q *fakeQEMU) alive(pid int) bool {
+	return q.alives[pid]
+}
+
+func (q *fakeQEMU) monitor(socket, command string) error {
+	pid := q.monitors[socket]
+	if !q.alives[pid] {
+		return errors.New("Monitor " + socket + " is not found")
+	}
+
+	q.calls = append(q.calls, command+" "+strconv.Itoa(pid))
+	if command == "quit" || command == "system_powerdown" {
+		delete(q.alives, pid)
+	}
+	return nil
+}
+
+func TestQEMUArgs(t *testing.T) {
+	c := loadTestConfig(t, testINIConfig+`
+[vm]
+driver=qemu
+dir=/var/lib/lazykube
+bridges=,br1
+`)
+	b := &qemuBackend{c: c, run: newFakeQEMU()}
+	n := c.Nodes[0]
+	n.Firmware = uefiFirmware
+
+	args := strings.Join(b.qemuArgs(n), " ")
+	for _, expected := range []string{
+		"-name ctl1 ",
+		"-m 2048 -smp 2 ",
+		"-pidfile /var/lib/lazykube/ctl1/qemu.pid ",
+		"-monitor unix:/var/lib/lazykube/ctl1/monitor.sock,server,nowait ",
+		"path=/var/lib/lazykube/ctl1/serial.log,append=on ",
+		"-bios /usr/share/ovmf/OVMF.fd ",
+		"-drive file=/var/lib/lazykube/ctl1/ctl1.qcow2,format=qcow2,if=none,id=disk0 ",
+		"-netdev user,id=net0,bootfile=" + c.M.URL + "/boot.ipxe ",
+		"-device virtio-net-pci,netdev=net0,mac=" + n.MAC[0] + ",bootindex=1 ",
+		"-netdev bridge,id=net1,br=br1 ",
+		"-device virtio-net-pci,netdev=net1,mac=" + n.MAC[1],
+	} {
+		if !strings.Contains(filepath.ToSlash(args), expected) {
+			t.Fatalf("Args of qemu should contain %q, got %s", expected, args)
+		}
+	}
+}
+
+func TestQEMUBackend(t *testing.T) {
+	c := loadTestConfig(t, testINIConfig)
+	c.VM.Dir = t.TempDir()
+	q := newFakeQEMU()
+	b := &qemuBackend{c: c, run: q}
+
+	run := func(action string, targets []string, expected ...string) {
+		q.calls = nil
+		if err := c.runVM(b, action, targets); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(q.calls, ",") != strings.Join(expected, ",") {
+			t.Fatalf("Calls of %s %v should be %v, got %v", action, targets, expected, q.calls)
+		}
+	}
+	status := func(expected string) {
+		buf := &bytes.Buffer{}
+		if err := c.writeVMStatus(buf, b, nil); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != expected {
+			t.Fatalf("Status should be\n%s\ngot\n%s", expected, buf.String())
+		}
+	}
+
+	status("NODE   ROLE    STATE\nctl1   master  not found\nwork1  minion  not found\nwork2  minion  not found\n")
+	if err := c.runVM(b, vmStart, []string{"ctl1"}); err == nil {
+		t.Fatal("Vm should not be started before it is created")
+	}
+
+	run(vmCreate, []string{"ctl1", "work1"}, "disk ctl1.qcow2 15", "launch ctl1", "disk work1.qcow2 15", "launch work1")
+	run(vmCreate, []string{"master"})
+	status("NODE   ROLE    STATE\nctl1   master  running\nwork1  minion  running\nwork2  minion  not found\n")
+
+	run(vmReboot, []string{"ctl1"}, "system_reset 101")
+	run(vmShutdown, []string{"work1"}, "system_powerdown 102")
+	run(vmShutdown, []string{"work1"})
+	run(vmReboot, []string{"work1"}, "launch work1")
+	run(vmPoweroff, []string{"ctl1"}, "quit 101")
+	run(vmPoweroff, []string{"ctl1"})
+	if _, err := os.Stat(filepath.Join(c.VM.Dir, "ctl1", qemuPIDFile)); !os.IsNotExist(err) {
+		t.Fatal("Pid file should be removed after poweroff")
+	}
+	run(vmStart, []string{"ctl1"}, "launch ctl1")
+
+	if err := c.runVM(b, vmVolDelete, []string{"ctl1"}); err == nil {
+		t.Fatal("Disk of running vm should not be deleted")
+	}
+	run(vmDestroy, []string{"master"}, "quit 104")
+	run(vmDestroy, []string{"master"})
+	if _, err := os.Stat(filepath.Join(c.VM.Dir, "ctl1")); !os.IsNotExist(err) {
+		t.Fatal("Node dir should be removed after destroy")
+	}
+
+	run(vmPoweroff, []string{"work1"}, "quit 103")
+	run(vmVolDelete, []string{"work1"})
+	status("NODE   ROLE    STATE\nctl1   master  not found\nwork1  minion  not found\nwork2  minion  not found\n")
+}
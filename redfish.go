@@ -0,0 +1,161 @@
+package lazy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const redfishSystems = "/redfish/v1/Systems"
+
+// redfishDriver controls the first computer system of redfish service.
+type redfishDriver struct {
+	url      string
+	username string
+	password string
+	client   *http.Client
+	// system is odata id of computer system
+	system string
+}
+
+type redfishSystem struct {
+	PowerState string `json:"PowerState"`
+	Actions    struct {
+		Reset struct {
+			Target string `json:"target"`
+		} `json:"#ComputerSystem.Reset"`
+	} `json:"Actions"`
+}
+
+func newRedfishDriver(address, username, password string, insecure bool) *redfishDriver {
+	url := strings.TrimSuffix(address, "/")
+	if !strings.Contains(url, "://") {
+		url = "https://" + url
+	}
+
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: insecure}
+	return &redfishDriver{
+		url:      url,
+		username: username,
+		password: password,
+		client:   &http.Client{Transport: tr, Timeout: 30 * time.Second},
+	}
+}
+
+func (d *redfishDriver) do(method, path string, body, v interface{}) error {
+	var r io.Reader
+	if body != nil {
+		bs, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(bs)
+	}
+
+	req, err := http.NewRequest(method, d.url+path, r)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(d.username, d.password)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return errors.New(method + " " + path + " failed: " + resp.Status + " " + string(bytes.TrimSpace(msg)))
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (d *redfishDriver) getSystem() (*redfishSystem, error) {
+	if len(d.system) == 0 {
+		systems := struct {
+			Members []struct {
+				ID string `json:"@odata.id"`
+			} `json:"Members"`
+		}{}
+		if err := d.do("GET", redfishSystems, nil, &systems); err != nil {
+			return nil, err
+		}
+		if len(systems.Members) == 0 {
+			return nil, errors.New("Redfish service has no computer system")
+		}
+		d.system = systems.Members[0].ID
+	}
+
+	s := &redfishSystem{}
+	if err := d.do("GET", d.system, nil, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (d *redfishDriver) reset(s *redfishSystem, resetType string) error {
+	target := s.Actions.Reset.Target
+	if len(target) == 0 {
+		target = d.system + "/Actions/ComputerSystem.Reset"
+	}
+	return d.do("POST", target, map[string]string{"ResetType": resetType}, nil)
+}
+
+func (d *redfishDriver) power(on bool, resetType string) error {
+	s, err := d.getSystem()
+	if err != nil || (s.PowerState == "On") == on {
+		return err
+	}
+	return d.reset(s, resetType)
+}
+
+func (d *redfishDriver) on() error {
+	return d.power(true, "On")
+}
+
+func (d *redfishDriver) off() error {
+	return d.power(false, "ForceOff")
+}
+
+func (d *redfishDriver) cycle() error {
+	s, err := d.getSystem()
+	if err != nil {
+		return err
+	}
+	if s.PowerState != "On" {
+		return d.reset(s, "On")
+	}
+	return d.reset(s, "ForceRestart")
+}
+
+func (d *redfishDriver) pxeOnce() error {
+	if _, err := d.getSystem(); err != nil {
+		return err
+	}
+
+	boot := map[string]interface{}{
+		"Boot": map[string]string{
+			"BootSourceOverrideTarget":  "Pxe",
+			"BootSourceOverrideEnabled": "Once",
+		},
+	}
+	if err := d.do("PATCH", d.system, boot, nil); err != nil {
+		return err
+	}
+	return d.cycle()
+}
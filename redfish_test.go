@@ -0,0 +1,144 @@
+package lazy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// redfishSimulator is redfish service of one computer system.
+type redfishSimulator struct {
+	mu         sync.Mutex
+	powerState string
+	override   string
+	resets     []string
+}
+
+func (s *redfishSimulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if u, p, ok := r.BasicAuth(); !ok || u != "admin" || p != "secret" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method + " " + r.URL.Path {
+	case "GET /redfish/v1/Systems":
+		w.Write([]byte(`{"Members":[{"@odata.id":"/redfish/v1/Systems/1"}]}`))
+	case "GET /redfish/v1/Systems/1":
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"PowerState": s.powerState,
+			"Boot":       map[string]string{"BootSourceOverrideTarget": s.override},
+			"Actions": map[string]interface{}{
+				"#ComputerSystem.Reset": map[string]string{"target": "/redfish/v1/Systems/1/Actions/Reset"},
+			},
+		})
+	case "PATCH /redfish/v1/Systems/1":
+		body := struct {
+			Boot struct {
+				Target  string `json:"BootSourceOverrideTarget"`
+				Enabled string `json:"BootSourceOverrideEnabled"`
+			}
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Boot.Enabled != "Once" {
+			http.Error(w, "bad boot override", http.StatusBadRequest)
+			return
+		}
+		s.override = body.Boot.Target
+		w.WriteHeader(http.StatusNoContent)
+	case "POST /redfish/v1/Systems/1/Actions/Reset":
+		body := struct{ ResetType string }{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch body.ResetType {
+		case "On":
+			if s.powerState == "On" {
+				http.Error(w, "already on", http.StatusConflict)
+				return
+			}
+			s.powerState = "On"
+		case "ForceOff":
+			s.powerState = "Off"
+		case "ForceRestart":
+		default:
+			http.Error(w, "unknown reset type", http.StatusBadRequest)
+			return
+		}
+		s.resets = append(s.resets, body.ResetType)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestRedfishPower(t *testing.T) {
+	sim := &redfishSimulator{powerState: "Off"}
+	srv := httptest.NewTLSServer(sim)
+	defer srv.Close()
+
+	c := loadTestConfig(t, strings.Replace(testINIConfig, "[work1]", `[work1]
+bmc_driver=redfish
+bmc_address=`+srv.URL+`
+bmc_username=admin
+bmc_credentials=env:LAZYKUBE_TEST_BMC_PASSWORD
+bmc_insecure=true`, 1))
+	t.Setenv("LAZYKUBE_TEST_BMC_PASSWORD", "secret")
+
+	for _, step := range []struct {
+		action string
+		state  string
+		resets string
+	}{
+		{powerOn, "On", "On"},
+		{powerOn, "On", "On"},
+		{powerCycle, "On", "On,ForceRestart"},
+		{powerOff, "Off", "On,ForceRestart,ForceOff"},
+		{powerOff, "Off", "On,ForceRestart,ForceOff"},
+		{powerCycle, "On", "On,ForceRestart,ForceOff,On"},
+		{powerPXEOnce, "On", "On,ForceRestart,ForceOff,On,ForceRestart"},
+	} {
+		if err := c.RunPower(step.action, []string{"work1"}); err != nil {
+			t.Fatal(err)
+		}
+		if sim.powerState != step.state || strings.Join(sim.resets, ",") != step.resets {
+			t.Fatalf("Power %s should be %s with resets %s, got %s with %v",
+				step.action, step.state, step.resets, sim.powerState, sim.resets)
+		}
+	}
+	if sim.override != "Pxe" {
+		t.Fatal("Boot override should be pxe, got", sim.override)
+	}
+
+	if err := c.RunPower("suspend", []string{"work1"}); err == nil {
+		t.Fatal("Unknown power action should fail")
+	}
+	if err := c.RunPower(powerOn, []string{"work2"}); err == nil {
+		t.Fatal("Node without bmc should fail")
+	}
+
+	t.Setenv("LAZYKUBE_TEST_BMC_PASSWORD", "wrong")
+	if err := c.RunPower(powerOn, []string{"work1"}); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatal("Wrong password should be unauthorized, got", err)
+	}
+}
+
+func TestRedfishCertificate(t *testing.T) {
+	srv := httptest.NewTLSServer(&redfishSimulator{powerState: "Off"})
+	defer srv.Close()
+
+	d := newRedfishDriver(srv.URL, "admin", "secret", false)
+	if err := d.on(); err == nil {
+		t.Fatal("Self-signed certificate should not be trusted")
+	}
+
+	d = newRedfishDriver(strings.TrimPrefix(srv.URL, "https://"), "admin", "secret", true)
+	if err := d.on(); err != nil {
+		t.Fatal("Https should be default scheme of redfish address:", err)
+	}
+}
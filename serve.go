@@ -0,0 +1,139 @@
+package lazy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"syscall"
+)
+
+type ServeConfig struct {
+	ProxyDHCP bool   `ini:"proxy_dhcp"`
+	TFTP      bool   `ini:"tftp"`
+	TFTPRoot  string `ini:"tftp_root"`
+	HTTP      string `ini:"http"`
+	Assets    string `ini:"assets"`
+	Upstream  string `ini:"upstream"`
+	Generated string `ini:"generated"`
+	ListenIP  string `ini:"listen_ip"`
+	// State is file of node lifecycle states
+	State string `ini:"state"`
+}
+
+// serveListenUDP listens udp ports of proxy dhcp and tftp
+var serveListenUDP = listenUDP
+
+// Serve runs enabled proxy dhcp, tftp and http servers in process, it
+// only returns when one of them failed, then other servers are closed.
+func (c *Config) Serve() error {
+	s := c.S
+	errc := make(chan error, 4)
+	closers := make([]io.Closer, 0, 4)
+	defer func() {
+		for _, cl := range closers {
+			cl.Close()
+		}
+	}()
+
+	if s.ProxyDHCP {
+		r, err := c.dhcpRecords()
+		if err != nil {
+			return err
+		}
+
+		pd, err := newProxyDHCPServer(r)
+		if err != nil {
+			return err
+		}
+
+		for _, port := range []int{proxyDHCPPort, pxeDHCPPort} {
+			conn, err := serveListenUDP(s.ListenIP, port, true)
+			if err != nil {
+				return err
+			}
+			closers = append(closers, conn)
+
+			log.Println("Serve proxy dhcp on", conn.LocalAddr())
+			go func() { errc <- pd.Serve(conn) }()
+		}
+	}
+
+	if s.TFTP {
+		conn, err := serveListenUDP(s.ListenIP, tftpPort, false)
+		if err != nil {
+			return err
+		}
+		closers = append(closers, conn)
+
+		log.Println("Serve tftp", s.TFTPRoot, "on", conn.LocalAddr())
+		ts := &TFTPServer{Root: s.TFTPRoot}
+		go func() { errc <- ts.Serve(conn) }()
+	}
+
+	if len(s.HTTP) != 0 {
+		h, err := c.httpHandler()
+		if err != nil {
+			return err
+		}
+
+		l, err := net.Listen("tcp", s.HTTP)
+		if err != nil {
+			return err
+		}
+		hs := &http.Server{Handler: h}
+		closers = append(closers, l, hs)
+
+		log.Println("Serve http on", l.Addr())
+		go func() { errc <- hs.Serve(l) }()
+	}
+
+	if len(closers) == 0 {
+		return errors.New("Nothing to serve, enable proxy_dhcp, tftp or http of serve section")
+	}
+	return <-errc
+}
+
+// httpHandler serves assets of matchbox, other requests are proxied to
+// upstream matchbox. Requests of nodes update their states.
+func (c *Config) httpHandler() (http.Handler, error) {
+	mux := http.NewServeMux()
+	if len(c.S.Assets) != 0 {
+		mux.Handle("/assets/", http.StripPrefix("/assets/", http.FileServer(http.Dir(c.S.Assets))))
+	}
+
+	// generated configs, such as rendered ignition of nodes
+	if len(c.S.Generated) != 0 {
+		mux.Handle("/generated/", http.StripPrefix("/generated/", http.FileServer(http.Dir(c.S.Generated))))
+	}
+
+	mux.HandleFunc(ubuntuInstalledPath, c.ubuntuInstalled)
+
+	if len(c.S.Upstream) != 0 {
+		u, err := url.Parse(c.S.Upstream)
+		if err != nil || len(u.Host) == 0 {
+			return nil, errors.New("Upstream format is not correct: " + c.S.Upstream)
+		}
+		mux.Handle("/", httputil.NewSingleHostReverseProxy(u))
+	}
+	return c.trackNodeStates(c.ubuntuBootInstalled(mux)), nil
+}
+
+func listenUDP(ip string, port int, broadcast bool) (net.PacketConn, error) {
+	lc := net.ListenConfig{}
+	if broadcast {
+		lc.Control = func(network, address string, rc syscall.RawConn) error {
+			var err error
+			if cerr := rc.Control(func(fd uintptr) { err = setBroadcast(fd) }); cerr != nil {
+				return cerr
+			}
+			return err
+		}
+	}
+	return lc.ListenPacket(context.Background(), "udp4", net.JoinHostPort(ip, strconv.Itoa(port)))
+}
@@ -0,0 +1,60 @@
+package lazy
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestServeCloseOnError(t *testing.T) {
+	c := loadTestConfig(t, testINIConfig)
+	c.S.ProxyDHCP = true
+	c.S.TFTP = true
+
+	defer func(f func(string, int, bool) (net.PacketConn, error)) { serveListenUDP = f }(serveListenUDP)
+	var conns []net.PacketConn
+	failPort := tftpPort
+	serveListenUDP = func(ip string, port int, broadcast bool) (net.PacketConn, error) {
+		if port == failPort {
+			return nil, errors.New("address already in use")
+		}
+		conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		if err == nil {
+			conns = append(conns, conn)
+		}
+		return conn, err
+	}
+
+	closed := func() {
+		for _, conn := range conns {
+			if _, err := conn.WriteTo([]byte{0}, conn.LocalAddr()); !errors.Is(err, net.ErrClosed) {
+				t.Fatalf("Listener %v should be closed, got %v", conn.LocalAddr(), err)
+			}
+		}
+	}
+
+	if err := c.Serve(); err == nil {
+		t.Fatal("Serve should fail when tftp can not listen")
+	}
+	if len(conns) != 2 {
+		t.Fatalf("Proxy dhcp should listen 2 ports, got %d", len(conns))
+	}
+	closed()
+
+	// http listen fails after proxy dhcp and tftp
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	conns, failPort = nil, 0
+	c.S.HTTP = l.Addr().String()
+	if err = c.Serve(); err == nil {
+		t.Fatal("Serve should fail when http can not listen")
+	}
+	if len(conns) != 3 {
+		t.Fatalf("Proxy dhcp and tftp should listen 3 ports, got %d", len(conns))
+	}
+	closed()
+}
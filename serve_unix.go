@@ -0,0 +1,10 @@
+//go:build !windows
+// +build !windows
+
+package lazy
+
+import "syscall"
+
+func setBroadcast(fd uintptr) error {
+	return syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_BROADCAST, 1)
+}
@@ -0,0 +1,7 @@
+package lazy
+
+import "syscall"
+
+func setBroadcast(fd uintptr) error {
+	return syscall.SetsockoptInt(syscall.Handle(fd), syscall.SOL_SOCKET, syscall.SO_BROADCAST, 1)
+}
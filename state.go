@@ -0,0 +1,268 @@
+package lazy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	stageDeclared   = "declared"
+	stageBooting    = "booting"
+	stageInstalling = "installing"
+	stageInstalled  = "installed"
+	stageReady      = "ready"
+)
+
+// nodeStages are lifecycle stages of node in order. Requests of node to
+// http server move it up to installed by requestStage, ready is only set
+// by verify when node of kubernetes is ready.
+var nodeStages = []string{stageDeclared, stageBooting, stageInstalling, stageInstalled, stageReady}
+
+// stateNow is clock of node states
+var stateNow = time.Now
+
+func stageIndex(stage string) int {
+	for i, s := range nodeStages {
+		if s == stage {
+			return i
+		}
+	}
+	return -1
+}
+
+type NodeState struct {
+	Stage string `json:"stage"`
+	// Stages are times when node entered each stage
+	Stages    map[string]time.Time `json:"stages,omitempty"`
+	Error     string               `json:"error,omitempty"`
+	ErrorTime time.Time            `json:"error_time"`
+}
+
+// Since returns time when node entered its stage.
+func (s *NodeState) Since() time.Time {
+	return s.Stages[s.Stage]
+}
+
+// stateLock serializes updates of state file in process
+var stateLock sync.Mutex
+
+// NodeStates returns state of every node, nodes which are not in state
+// file are declared.
+func (c *Config) NodeStates() (map[string]*NodeState, error) {
+	states := make(map[string]*NodeState)
+	bs, err := ioutil.ReadFile(c.S.State)
+	if err == nil {
+		err = json.Unmarshal(bs, &states)
+		if err != nil {
+			return nil, errors.New("State file " + c.S.State + " is not correct: " + err.Error())
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	for _, n := range c.Nodes {
+		if _, ok := states[n.ID]; !ok {
+			states[n.ID] = &NodeState{Stage: stageDeclared}
+		}
+	}
+	return states, nil
+}
+
+func (c *Config) writeNodeStates(states map[string]*NodeState) error {
+	bs, err := json.MarshalIndent(states, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(c.S.State), 0755); err != nil {
+		return err
+	}
+
+	// state file is read by other commands while it is updated
+	tmp := c.S.State + ".tmp"
+	if err = ioutil.WriteFile(tmp, append(bs, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.S.State)
+}
+
+func (c *Config) updateNodeStates(f func(states map[string]*NodeState) error) error {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+
+	states, err := c.NodeStates()
+	if err != nil {
+		return err
+	}
+	if err = f(states); err != nil {
+		return err
+	}
+	return c.writeNodeStates(states)
+}
+
+// UpdateNodeStage moves node forward to stage, node never goes back to
+// earlier stage until it is reset. Error of node is cleared.
+func (c *Config) UpdateNodeStage(id, stage string) error {
+	if stageIndex(stage) < 0 {
+		return errors.New("Unknown node stage: " + stage)
+	}
+
+	return c.updateNodeStates(func(states map[string]*NodeState) error {
+		s, ok := states[id]
+		if !ok {
+			return errors.New("Node " + id + " is not declared")
+		}
+
+		s.Error = ""
+		s.ErrorTime = time.Time{}
+		if stageIndex(stage) <= stageIndex(s.Stage) {
+			return nil
+		}
+
+		if s.Stages == nil {
+			s.Stages = make(map[string]time.Time)
+		}
+		s.Stage = stage
+		s.Stages[stage] = stateNow()
+		return nil
+	})
+}
+
+// UpdateNodeError records the last error of node, stage is kept.
+func (c *Config) UpdateNodeError(id, msg string) error {
+	return c.updateNodeStates(func(states map[string]*NodeState) error {
+		s, ok := states[id]
+		if !ok {
+			return errors.New("Node " + id + " is not declared")
+		}
+		s.Error = msg
+		s.ErrorTime = stateNow()
+		return nil
+	})
+}
+
+// ResetNodeStates moves nodes selected by targets back to declared, so
+// they can be tracked again after they are reinstalled.
+func (c *Config) ResetNodeStates(targets []string) error {
+	nodes, err := c.selectNodes(targets)
+	if err != nil {
+		return err
+	}
+
+	return c.updateNodeStates(func(states map[string]*NodeState) error {
+		for _, n := range nodes {
+			states[n.ID] = &NodeState{Stage: stageDeclared}
+		}
+		return nil
+	})
+}
+
+func formatStateTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format(time.RFC3339)
+}
+
+// WriteNodeStatus writes stage, time of stage and the last error of
+// nodes selected by targets.
+func (c *Config) WriteNodeStatus(w io.Writer, targets []string) error {
+	nodes, err := c.selectNodes(targets)
+	if err != nil {
+		return err
+	}
+	states, err := c.NodeStates()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NODE\tROLE\tSTAGE\tSINCE\tLAST ERROR")
+	for _, n := range nodes {
+		s := states[n.ID]
+		lastErr := "-"
+		if len(s.Error) != 0 {
+			lastErr = formatStateTime(s.ErrorTime) + " " + s.Error
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", n.ID, n.Role, s.Stage, formatStateTime(s.Since()), lastErr)
+	}
+	return tw.Flush()
+}
+
+// nodeOfMAC returns node of mac, which is hexhyp format of ipxe.
+func (c *Config) nodeOfMAC(mac string) *Node {
+	mac = strings.ToLower(strings.Replace(mac, "-", ":", -1))
+	for _, n := range c.Nodes {
+		for _, m := range n.MAC {
+			if strings.ToLower(m) == mac {
+				return n
+			}
+		}
+	}
+	return nil
+}
+
+// requestStage returns stage of node which sends matchbox request. Node
+// boots by ipxe script, fetches ignition while it installs and fetches
+// installed ignition after it reboots into disk. Ubuntu nodes report
+// installed by late-commands of autoinstall.
+func requestStage(r *http.Request) string {
+	switch r.URL.Path {
+	case "/ipxe":
+		return stageBooting
+	case "/ignition":
+		if r.URL.Query().Get("os") == "installed" {
+			return stageInstalled
+		}
+		return stageInstalling
+	case ubuntuInstalledPath:
+		return stageInstalled
+	}
+	return ""
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// trackNodeStates updates node states by matchbox requests of nodes,
+// failed requests are recorded as error of node.
+func (c *Config) trackNodeStates(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stage := requestStage(r)
+		n := c.nodeOfMAC(r.URL.Query().Get("mac"))
+		if len(stage) == 0 || n == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(rec, r)
+
+		var err error
+		if rec.status >= 400 {
+			err = c.UpdateNodeError(n.ID, r.Method+" "+r.URL.Path+" failed: "+http.StatusText(rec.status))
+		} else {
+			err = c.UpdateNodeStage(n.ID, stage)
+		}
+		if err != nil {
+			log.Println("Update state of node", n.ID, "failed:", err)
+		}
+	})
+}
@@ -0,0 +1,96 @@
+package lazy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrackNodeStates(t *testing.T) {
+	matchbox := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("uuid") == "missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer matchbox.Close()
+
+	c := loadTestConfig(t, testINIConfig)
+	c.S.Upstream = matchbox.URL
+	c.S.State = filepath.Join(t.TempDir(), "state", "state.json")
+
+	now := time.Date(2020, 1, 2, 3, 4, 0, 0, time.Local)
+	defer func(f func() time.Time) { stateNow = f }(stateNow)
+	stateNow = func() time.Time { return now }
+
+	h, err := c.httpHandler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := func(path string) {
+		now = now.Add(time.Minute)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+	}
+	status := func(expected string) {
+		buf := &bytes.Buffer{}
+		if err := c.WriteNodeStatus(buf, nil); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != expected {
+			t.Fatalf("Status should be\n%s\ngot\n%s", expected, buf.String())
+		}
+	}
+	ts := func(minute int) string {
+		return time.Date(2020, 1, 2, 3, 4+minute, 0, 0, time.Local).Format(time.RFC3339)
+	}
+
+	status("NODE   ROLE    STAGE     SINCE  LAST ERROR\n" +
+		"ctl1   master  declared  -      -\n" +
+		"work1  minion  declared  -      -\n" +
+		"work2  minion  declared  -      -\n")
+
+	request("/ipxe?uuid=1&mac=52-54-00-a1-9c-ae")
+	request("/ignition?uuid=1&mac=52-54-00-a1-9c-ae")
+	request("/ipxe?uuid=1&mac=52-54-00-a1-9c-ae")
+	request("/ignition?uuid=1&mac=52-54-00-a1-9c-ae&os=installed")
+	request("/ipxe?uuid=2&mac=52-54-00-d7-99-c7")
+	request("/ignition?uuid=missing&mac=52-54-00-d7-99-c7")
+	request("/ipxe?uuid=3&mac=52-54-00-00-00-00")
+	request("/boot.ipxe")
+
+	// width of time depends on local zone
+	pad := strings.Repeat(" ", len(ts(0))-len("SINCE"))
+	status("NODE   ROLE    STAGE      SINCE" + pad + "  LAST ERROR\n" +
+		"ctl1   master  installed  " + ts(4) + "  -\n" +
+		"work1  minion  booting    " + ts(5) + "  " + ts(6) + " GET /ignition failed: Not Found\n" +
+		"work2  minion  declared   -" + pad + "      -\n")
+
+	request("/ignition?uuid=2&mac=52-54-00-d7-99-c7")
+	if err = c.UpdateNodeStage("ctl1", stageReady); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.ResetNodeStates([]string{"minion"}); err != nil {
+		t.Fatal(err)
+	}
+
+	states, err := c.NodeStates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := states["ctl1"]; s.Stage != stageReady || !s.Stages[stageBooting].Equal(now.Add(-8*time.Minute)) {
+		t.Fatalf("State of ctl1 is not correct: %+v", s)
+	}
+	if s := states["work1"]; s.Stage != stageDeclared || len(s.Error) != 0 {
+		t.Fatalf("State of work1 should be reset: %+v", s)
+	}
+
+	if err = c.UpdateNodeStage("ctl1", "joined"); err == nil {
+		t.Fatal("Unknown stage should fail")
+	}
+}
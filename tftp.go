@@ -0,0 +1,286 @@
+package lazy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	tftpPort = 69
+
+	tftpOpRRQ   = 1
+	tftpOpWRQ   = 2
+	tftpOpData  = 3
+	tftpOpAck   = 4
+	tftpOpError = 5
+	tftpOpOACK  = 6
+
+	tftpErrNotFound     = 1
+	tftpErrAccess       = 2
+	tftpErrIllegalOp    = 4
+	tftpErrUnknownTID   = 5
+	tftpErrBadOption    = 8
+	tftpDefaultBlksize  = 512
+	tftpMaxBlksize      = 65464
+	tftpMinBlksize      = 8
+	tftpDefaultTimeout  = 3 * time.Second
+	tftpDefaultRetries  = 5
+	tftpMaxRequestBytes = 516
+)
+
+// TFTPServer serves read requests of files under Root, write requests
+// are always refused.
+type TFTPServer struct {
+	Root    string
+	Timeout time.Duration
+	Retries int
+}
+
+type tftpRequest struct {
+	Filename string
+	Mode     string
+	Options  map[string]string
+}
+
+func parseTFTPRequest(bs []byte) (*tftpRequest, error) {
+	fields := bytes.Split(bs, []byte{0})
+	// last field is empty because request ends with zero byte
+	if len(fields) < 3 || len(fields[len(fields)-1]) != 0 {
+		return nil, errors.New("TFTP request is not correct")
+	}
+	fields = fields[:len(fields)-1]
+
+	r := &tftpRequest{
+		Filename: string(fields[0]),
+		Mode:     strings.ToLower(string(fields[1])),
+		Options:  make(map[string]string),
+	}
+	for i := 2; i+1 < len(fields); i += 2 {
+		r.Options[strings.ToLower(string(fields[i]))] = string(fields[i+1])
+	}
+	return r, nil
+}
+
+func tftpErrorPacket(code uint16, msg string) []byte {
+	bs := make([]byte, 4, 5+len(msg))
+	binary.BigEndian.PutUint16(bs, tftpOpError)
+	binary.BigEndian.PutUint16(bs[2:], code)
+	bs = append(bs, msg...)
+	return append(bs, 0)
+}
+
+// Serve handles requests of conn until conn is closed, every transfer
+// uses its own port as tftp requires.
+func (s *TFTPServer) Serve(conn net.PacketConn) error {
+	buf := make([]byte, tftpMaxRequestBytes)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+
+		if n < 2 {
+			continue
+		}
+
+		switch binary.BigEndian.Uint16(buf) {
+		case tftpOpRRQ:
+			r, err := parseTFTPRequest(buf[2:n])
+			if err != nil {
+				conn.WriteTo(tftpErrorPacket(tftpErrIllegalOp, err.Error()), addr)
+				continue
+			}
+			go s.transfer(conn.LocalAddr(), addr, r)
+		case tftpOpWRQ:
+			conn.WriteTo(tftpErrorPacket(tftpErrAccess, "Write is not allowed"), addr)
+		default:
+			conn.WriteTo(tftpErrorPacket(tftpErrIllegalOp, "Unknown operation"), addr)
+		}
+	}
+}
+
+// open returns file of request, file name can not escape from root.
+func (s *TFTPServer) open(name string) (*os.File, os.FileInfo, error) {
+	file := filepath.Join(s.Root, filepath.FromSlash(path.Clean("/"+name)))
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fi, err := f.Stat()
+	if err == nil && fi.IsDir() {
+		err = errors.New(name + " is a directory")
+	}
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, fi, nil
+}
+
+func (s *TFTPServer) transfer(local, remote net.Addr, r *tftpRequest) {
+	host := "0.0.0.0"
+	if ua, ok := local.(*net.UDPAddr); ok && ua.IP != nil {
+		host = ua.IP.String()
+	}
+
+	conn, err := net.ListenPacket("udp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		log.Println("Listen tftp transfer failed:", err)
+		return
+	}
+	defer conn.Close()
+
+	f, fi, err := s.open(r.Filename)
+	if err != nil {
+		log.Println("Open tftp file", r.Filename, "failed:", err)
+		conn.WriteTo(tftpErrorPacket(tftpErrNotFound, "File not found"), remote)
+		return
+	}
+	defer f.Close()
+
+	t := &tftpTransfer{
+		conn:    conn,
+		remote:  remote,
+		blksize: tftpDefaultBlksize,
+		timeout: s.Timeout,
+		retries: s.Retries,
+	}
+	if t.timeout <= 0 {
+		t.timeout = tftpDefaultTimeout
+	}
+	if t.retries <= 0 {
+		t.retries = tftpDefaultRetries
+	}
+
+	if err = t.negotiate(r.Options, fi.Size()); err == nil {
+		err = t.send(f)
+	}
+	if err != nil {
+		log.Println("Transfer tftp file", r.Filename, "to", remote, "failed:", err)
+	}
+}
+
+type tftpTransfer struct {
+	conn    net.PacketConn
+	remote  net.Addr
+	blksize int
+	timeout time.Duration
+	retries int
+}
+
+// negotiate acknowledges blksize, tsize and timeout options, other
+// options are ignored.
+func (t *tftpTransfer) negotiate(opts map[string]string, size int64) error {
+	oack := make([]byte, 2)
+	binary.BigEndian.PutUint16(oack, tftpOpOACK)
+	appendOpt := func(k, v string) {
+		oack = append(oack, k...)
+		oack = append(oack, 0)
+		oack = append(oack, v...)
+		oack = append(oack, 0)
+	}
+
+	if v, ok := opts["blksize"]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < tftpMinBlksize {
+			t.conn.WriteTo(tftpErrorPacket(tftpErrBadOption, "Bad blksize"), t.remote)
+			return errors.New("TFTP blksize is not correct: " + v)
+		}
+		if n > tftpMaxBlksize {
+			n = tftpMaxBlksize
+		}
+		t.blksize = n
+		appendOpt("blksize", strconv.Itoa(n))
+	}
+
+	if _, ok := opts["tsize"]; ok {
+		appendOpt("tsize", strconv.FormatInt(size, 10))
+	}
+
+	if v, ok := opts["timeout"]; ok {
+		if n, err := strconv.Atoi(v); err == nil && n >= 1 && n <= 255 {
+			t.timeout = time.Duration(n) * time.Second
+			appendOpt("timeout", v)
+		}
+	}
+
+	if len(oack) == 2 {
+		return nil
+	}
+	return t.sendAndWait(oack, 0)
+}
+
+func (t *tftpTransfer) send(r io.Reader) error {
+	buf := make([]byte, 4+t.blksize)
+	binary.BigEndian.PutUint16(buf, tftpOpData)
+	for block := uint16(1); ; block++ {
+		n, err := io.ReadFull(r, buf[4:])
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			t.conn.WriteTo(tftpErrorPacket(0, "Read file failed"), t.remote)
+			return err
+		}
+
+		binary.BigEndian.PutUint16(buf[2:], block)
+		if err := t.sendAndWait(buf[:4+n], block); err != nil {
+			return err
+		}
+
+		// The last block is shorter than block size, it may be empty
+		if n < t.blksize {
+			return nil
+		}
+	}
+}
+
+// sendAndWait retransmits packet until ack of block is received.
+func (t *tftpTransfer) sendAndWait(packet []byte, block uint16) error {
+	buf := make([]byte, tftpMaxRequestBytes)
+	for i := 0; i < t.retries; i++ {
+		if _, err := t.conn.WriteTo(packet, t.remote); err != nil {
+			return err
+		}
+
+		deadline := time.Now().Add(t.timeout)
+		for {
+			t.conn.SetReadDeadline(deadline)
+			n, addr, err := t.conn.ReadFrom(buf)
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					break
+				}
+				return err
+			}
+
+			// Packets of other transfer get unknown transfer id error
+			if addr.String() != t.remote.String() {
+				t.conn.WriteTo(tftpErrorPacket(tftpErrUnknownTID, "Unknown transfer id"), addr)
+				continue
+			}
+
+			if n < 4 {
+				continue
+			}
+
+			switch binary.BigEndian.Uint16(buf) {
+			case tftpOpAck:
+				if binary.BigEndian.Uint16(buf[2:]) == block {
+					return nil
+				}
+			case tftpOpError:
+				return errors.New("TFTP client aborted: " + string(bytes.TrimRight(buf[4:n], "\x00")))
+			}
+		}
+	}
+	return errors.New("TFTP transfer timeout at block " + strconv.Itoa(int(block)))
+}
@@ -0,0 +1,112 @@
+package lazy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func testTFTPRequest(name string, opts ...string) []byte {
+	bs := []byte{0, tftpOpRRQ}
+	for _, f := range append([]string{name, "octet"}, opts...) {
+		bs = append(bs, f...)
+		bs = append(bs, 0)
+	}
+	return bs
+}
+
+func testTFTPServer(t *testing.T, content []byte) net.Addr {
+	root := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(root, "undionly.kpxe"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	s := &TFTPServer{Root: root, Timeout: time.Second, Retries: 2}
+	go s.Serve(conn)
+	return conn.LocalAddr()
+}
+
+// testTFTPGet reads file from server and acknowledges every packet.
+func testTFTPGet(t *testing.T, server net.Addr, request []byte) ([]byte, map[string]string) {
+	client, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if _, err = client.WriteTo(request, server); err != nil {
+		t.Fatal(err)
+	}
+
+	var data []byte
+	var opts map[string]string
+	blksize := tftpDefaultBlksize
+	buf := make([]byte, 4+tftpMaxBlksize)
+	for {
+		client.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, addr, err := client.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ack := []byte{0, tftpOpAck, 0, 0}
+		switch binary.BigEndian.Uint16(buf) {
+		case tftpOpOACK:
+			r, err := parseTFTPRequest(append([]byte("x\x00octet\x00"), buf[2:n]...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			opts = r.Options
+			if v, ok := opts["blksize"]; ok {
+				blksize, _ = strconv.Atoi(v)
+			}
+		case tftpOpData:
+			copy(ack[2:], buf[2:4])
+			data = append(data, buf[4:n]...)
+		case tftpOpError:
+			return nil, map[string]string{"error": string(bytes.TrimRight(buf[4:n], "\x00"))}
+		}
+
+		if _, err = client.WriteTo(ack, addr); err != nil {
+			t.Fatal(err)
+		}
+
+		if binary.BigEndian.Uint16(buf) == tftpOpData && n-4 < blksize {
+			return data, opts
+		}
+	}
+}
+
+func TestTFTPServe(t *testing.T) {
+	// Exactly two blocks, the last data packet should be empty
+	content := bytes.Repeat([]byte("lazykube"), 128)
+	server := testTFTPServer(t, content)
+
+	data, _ := testTFTPGet(t, server, testTFTPRequest("undionly.kpxe"))
+	if !bytes.Equal(data, content) {
+		t.Fatalf("TFTP should send %d bytes, got %d", len(content), len(data))
+	}
+
+	data, opts := testTFTPGet(t, server, testTFTPRequest("/undionly.kpxe", "blksize", "100", "tsize", "0"))
+	if !bytes.Equal(data, content) {
+		t.Fatalf("TFTP with blksize should send %d bytes, got %d", len(content), len(data))
+	}
+	if opts["blksize"] != "100" || opts["tsize"] != strconv.Itoa(len(content)) {
+		t.Fatalf("TFTP options are not acknowledged: %v", opts)
+	}
+
+	if _, opts = testTFTPGet(t, server, testTFTPRequest("../../etc/passwd")); len(opts["error"]) == 0 {
+		t.Fatal("TFTP should not serve file outside of root")
+	}
+}
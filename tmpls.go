@@ -1,20 +1,109 @@
 package lazy
 
 import (
+	"bytes"
 	"encoding/json"
 	"os"
+	"regexp"
+	"strings"
 	"text/template"
 )
 
 const OS_INSTALL_TMPL = `{
-  "id": "coreos-install",
-  "name": "CoreOS Install",
+  "id": "{{.ID}}",
+  "name": "{{.Name}}",
   "profile": "install-reboot",
   "metadata": {
-    "coreos_channel": "{{.Channel}}",
-    "coreos_version": "{{.Version}}",
-    "ignition_endpoint": "{{.M.URL}}/ignition",
-    "baseurl": "{{.M.URL}}/assets/coreos"
+  {{- range $i, $m := .Metadata }}{{ if $i }},{{ end }}
+    "{{$m.Key}}": "{{$m.Value}}"
+  {{- end }}
+  }
+}
+`
+
+const PROFILE_TMPL = `{
+  "id": "{{.ID}}",
+  "name": "{{.Name}}",
+  "boot": {
+    "kernel": "{{.Kernel}}",
+    "initrd": {{j2s .Initrd}},
+    "args": [
+    {{- range $i, $arg := .Args }}{{ if $i }},{{ end }}
+      "{{$arg}}"
+    {{- end }}
+    ]
+  }{{ with .IgnitionID }},
+  "ignition_id": "{{.}}"{{ end }}
+}
+`
+
+const NETPLAN_TMPL = `network:
+  version: 2
+  ethernets:
+  {{- range .Nics }}
+    {{.Interface}}:
+      match:
+        macaddress: "{{.MAC}}"
+      set-name: {{.Interface}}
+      {{- if .DHCP }}
+      dhcp4: true
+      {{- else }}
+      addresses: ["{{.IP}}/{{.Prefix}}"]
+      {{- with .Gateway }}
+      routes:
+        - to: default
+          via: {{.}}
+      {{- end }}
+      {{- end }}
+      {{- with .DNS }}
+      nameservers:
+        addresses: {{j2s .}}
+      {{- end }}
+  {{- end }}
+`
+
+const UBUNTU_USER_DATA_TMPL = `#cloud-config
+autoinstall:
+  version: 1
+  ssh:
+    install-server: true
+    allow-pw: false
+  storage:
+    layout:
+      name: {{.U.Storage}}
+  network:
+{{indent 4 .Netplan}}
+  {{- with .U.Packages }}
+  packages: {{j2s .}}
+  {{- end }}
+  late-commands:
+    - curl -fsS "{{.InstalledURL}}"
+  user-data:
+    hostname: {{.ID}}
+    fqdn: {{.Domain}}
+    users:
+      - name: {{.U.Username}}
+        shell: /bin/bash
+        sudo: "ALL=(ALL) NOPASSWD:ALL"
+        ssh_authorized_keys: {{.AuthorizedKeys}}
+    {{- with .EtcHosts }}
+    write_files:
+      - path: /etc/hosts
+        content: {{j2s (printf "%s\n" (join . "\n"))}}
+    {{- end }}
+`
+
+const UBUNTU_META_DATA_TMPL = `instance-id: {{.ID}}
+local-hostname: {{.ID}}
+`
+
+const UBUNTU_INSTALLED_TMPL = `{
+  "id": "{{.ID}}-installed",
+  "name": "Node {{.ID}} Installed",
+  "profile": "ubuntu-installed",
+  "selector": {
+    "mac": "{{index .MAC 0}}",
+    "os": "installed"
   }
 }
 `
@@ -33,12 +122,15 @@ const K8S_CONTROLLER_TMPL = `{
     "etcd_initial_cluster": "{{.InitialCluster}}",
     "etcd_name": "{{.ID}}",
     "k8s_cert_endpoint": "{{.M.URL}}/assets",
-    "k8s_dns_service_ip": "10.3.0.10",
+    "k8s_dns_service_ip": "{{.K.DNSServiceIP}}",
     "k8s_etcd_endpoints": "{{.Endpoints}}",
-    "k8s_pod_network": "10.2.0.0/16",
-    "k8s_service_ip_range": "10.3.0.0/24",
+    "k8s_pod_network": "{{.K.PodNetwork}}",
+    "k8s_service_ip_range": "{{.K.ServiceIPRange}}",
     "vip": {{with .VIP}}{{ . }}{{ end }},
     "interfaces": {{.Nics}},
+    {{- with .EtcHosts }}
+    "etc_hosts": {{j2s .}},
+    {{- end }}
     "ssh_authorized_keys": {{.AuthorizedKeys}}
   }
 }
@@ -58,9 +150,12 @@ const K8S_WORKER_TMPL = `{
     "etcd_initial_cluster": "{{.InitialCluster}}",
     "k8s_controller_endpoint": "{{.ControllerEndpoint}}",
     "k8s_cert_endpoint": "{{.M.URL}}/assets",
-    "k8s_dns_service_ip": "10.3.0.10",
+    "k8s_dns_service_ip": "{{.K.DNSServiceIP}}",
     "k8s_etcd_endpoints": "{{.Endpoints}}",
     "interfaces": {{.Nics}},
+    {{- with .EtcHosts }}
+    "etc_hosts": {{j2s .}},
+    {{- end }}
     {{- with .Registries }}
     "registries": {{- j2s .}},
     {{- end }}
@@ -79,82 +174,313 @@ const NODE_TMPL = `{
   "metadata": {
     "domain_name": "{{.Domain}}",
     "interfaces": {{.Nics}},
+    {{- with .EtcHosts }}
+    "etc_hosts": {{j2s .}},
+    {{- end }}
     "ssh_authorized_keys": {{.AuthorizedKeys}}
   }
 }
 `
 
 const DNSMASQ_TMPL = `# dnsmasq.conf
+{{- if ne .DHCP.Driver "dnsmasq" }}
 
 ### DHCP CONFIG ###
-{{- if .N.Gateway }}
-dhcp-option=3,{{.N.Gateway}}
+# DHCP is served by {{.DHCP.Driver}}
 {{- end }}
 
-{{- with (.Cls.GetKeepIPRange) }}
-dhcp-range={{.Start}},{{.End}}
+### TFTP CONFIG ###
+enable-tftp
+tftp-root=/var/lib/tftpboot
+
+{{- if ne .D.Driver "dnsmasq" }}
+
+### DNS CONFIG ###
+# DNS is served by {{.D.Driver}}
+port=0
 {{- end }}
 
-{{- range $i, $node := .Nodes }}
-  {{- range $index, $mac := .MAC }}
-    {{- if lt $index (len $node.IP) }}
-dhcp-host={{$mac}},{{index $node.IP $index}},1h
-    {{- end }}
+### OTHER CONFIG ###
+log-queries
+log-dhcp
+`
+
+const DNSMASQ_DHCP_TMPL = `
+### DHCP CONFIG ###
+{{- range .Listen }}
+interface={{.}}
+{{- end }}
+{{- with .DNSServers }}
+dhcp-option=option:dns-server,{{join . ","}}
+{{- end }}
+{{- with .Search }}
+dhcp-option=option:domain-search,{{join . ","}}
+{{- end }}
+{{- with .NTPServers }}
+dhcp-option=option:ntp-server,{{join . ","}}
+{{- end }}
+{{- range .Subnets }}
+dhcp-range={{with .Interface}}tag:{{.}},{{end}}set:{{.Tag}},{{.Start}},{{.End}},{{.Netmask}},{{.Lease}}
+  {{- if .Gateway }}
+dhcp-option=tag:{{.Tag}},3,{{.Gateway}}
   {{- end }}
 {{- end }}
 
+{{- range .Hosts }}
+dhcp-host={{.MAC}},{{if .UEFI}}set:uefi,{{end}}{{.IP}},{{.Lease}}
+{{- end }}
+
+# client arch 7 and 9 are uefi, 16 is uefi http boot
+dhcp-match=set:uefi,option:client-arch,7
+dhcp-match=set:uefi,option:client-arch,9
+dhcp-match=set:uefi-http,option:client-arch,16
 dhcp-userclass=set:ipxe,iPXE
-dhcp-boot=tag:#ipxe,undionly.kpxe
-dhcp-boot=tag:ipxe,{{.M.URL}}/boot.ipxe
+dhcp-boot=tag:#ipxe,tag:#uefi,tag:#uefi-http,{{.BootFile}}
+dhcp-boot=tag:#ipxe,tag:uefi,tag:#uefi-http,{{.EFIBootFile}}
+dhcp-boot=tag:#ipxe,tag:uefi-http,{{.HTTPBootURL}}
+dhcp-option-force=tag:#ipxe,tag:uefi-http,60,HTTPClient
+dhcp-boot=tag:ipxe,{{.BootURL}}
+`
 
-### TFTP CONFIG ###
-enable-tftp
-tftp-root=/var/lib/tftpboot
+const DHCPD_TMPL = `# dhcpd.conf
+{{- with .Listen }}
+# Run dhcpd with interfaces:{{range .}} {{.}}{{end}}
+{{- end }}
+authoritative;
+{{- with .DNSServers }}
+option domain-name-servers {{join . ", "}};
+{{- end }}
+{{- with .Search }}
+option domain-search {{range $i, $s := .}}{{if $i}}, {{end}}"{{$s}}"{{end}};
+{{- end }}
+{{- with .NTPServers }}
+option ntp-servers {{join . ", "}};
+{{- end }}
+{{- range .Subnets }}
 
-### DNS CONFIG ###
+subnet {{.Network}} netmask {{.Netmask}} {
+  range {{.Start}} {{.End}};
+  {{- if .Gateway }}
+  option routers {{.Gateway}};
+  {{- end }}
+  default-lease-time {{.LeaseSeconds}};
+  max-lease-time {{.LeaseSeconds}};
+}
+{{- end }}
 
-address=/{{.M.Domain}}/{{.M.IP}}
+# iPXE chainload, pxe firmware gets iPXE from tftp first,
+# client arch 7 and 9 are uefi, 16 is uefi http boot
+option client-arch code 93 = unsigned integer 16;
+{{- with .NextServer }}
+next-server {{.}};
+{{- end }}
+if exists user-class and option user-class = "iPXE" {
+  filename "{{.BootURL}}";
+} elsif option client-arch = 16 {
+  option vendor-class-identifier "HTTPClient";
+  filename "{{.HTTPBootURL}}";
+} elsif option client-arch = 7 or option client-arch = 9 {
+  filename "{{.EFIBootFile}}";
+} else {
+  filename "{{.BootFile}}";
+}
+{{- range .Hosts }}
 
-##### vip address #####
-{{- with .V }}
-  {{- if .Enable }}
-address=/{{.Domain}}/{{.VIP}}
+host {{.Name}} {
+  hardware ethernet {{.MAC}};
+  fixed-address {{.IP}};
+  option host-name "{{.Hostname}}";
+  default-lease-time {{.LeaseSeconds}};
+  max-lease-time {{.LeaseSeconds}};
+  {{- if .UEFI }}
+  if not exists user-class or option user-class != "iPXE" {
+    if option client-arch != 16 {
+      filename "{{$.EFIBootFile}}";
+    }
+  }
   {{- end }}
+}
+{{- end }}
+`
+
+const HOSTS_TMPL = `# hosts generated by lazykube
+{{- range . }}
+{{.}}
+{{- end }}
+`
+
+const KUBECONFIG_TMPL = `apiVersion: v1
+kind: Config
+clusters:
+- name: lazykube
+  cluster:
+    certificate-authority-data: {{.CA}}
+    server: {{.Server}}
+users:
+- name: admin
+  user:
+    client-certificate-data: {{.Cert}}
+    client-key-data: {{.Key}}
+contexts:
+- name: lazykube
+  context:
+    cluster: lazykube
+    user: admin
+current-context: lazykube
+`
+
+const DNSMASQ_DNS_TMPL = `
+### DNS CONFIG ###
+
+##### address #####
+{{- range .Addresses }}
+address=/{{.Name}}/{{.IP}}
 {{- end }}
 
 ##### node address #####
-{{- range .Nodes }}
-  {{- if gt (len .IP) 0 }}
-address=/{{.Domain}}/{{index .IP 0}}
-  {{- end }}
+{{- range .Hosts }}
+host-record={{.Name}},{{.IP}}
+{{- end }}
+{{- range .Pointers }}
+ptr-record={{arpa .IP}},{{.Name}}
+{{- end }}
+
+##### etcd discovery #####
+{{- range .SRVs }}
+srv-host={{.Service}},{{.Target}},{{.Port}},{{.Priority}},{{.Weight}}
 {{- end }}
 
 ##### dns server #####
-{{- with .D }}
-  {{- range .DNS }}
+{{- range .Forwarders }}
 server={{.}}
+{{- end }}
+`
+
+const COREDNS_TMPL = `# Corefile
+. {
+    root {{.Dir}}
+    hosts hosts {
+        fallthrough
+    }
+{{- range .SRVGroups }}
+    template IN SRV {
+        match ^{{regexQuote .Service}}\.$
+  {{- range .Records }}
+        answer "{{.Service}}. 60 IN SRV {{.Priority}} {{.Weight}} {{.Port}} {{.Target}}."
   {{- end }}
+        fallthrough
+    }
 {{- end }}
+{{- with .Forwarders }}
+    forward .{{range .}} {{.}}{{end}}
+{{- end }}
+    log
+    errors
+}
+{{- if or .Hosts .Pointers }}
 
-### OTHER CONFIG ###
-log-queries
-log-dhcp
+in-addr.arpa {
+    root {{.Dir}}
+    hosts hosts.reverse {
+        fallthrough
+    }
+  {{- with .Forwarders }}
+    forward .{{range .}} {{.}}{{end}}
+  {{- end }}
+    log
+    errors
+}
+{{- end }}
+`
+
+const COREDNS_HOSTS_TMPL = `# Generated by lazykube
+{{- range .Addresses }}
+{{.IP}} {{.Name}}
+{{- end }}
+{{- range .Hosts }}
+{{.IP}} {{.Name}}
+{{- end }}
+`
+
+const COREDNS_REVERSE_HOSTS_TMPL = `# Generated by lazykube
+{{- range .Hosts }}
+{{.IP}} {{.Name}}
+{{- end }}
+{{- range .Pointers }}
+{{.IP}} {{.Name}}
+{{- end }}
+`
+
+const BIND_NAMED_TMPL = `// named.conf zones generated by lazykube, zone files are
+// relative to the directory option of named.
+{{- range . }}
+
+zone "{{.Origin}}" {
+	type master;
+	file "{{.File}}";
+};
+{{- end }}
+`
+
+const BIND_ZONE_TMPL = `$ORIGIN {{.Origin}}.
+$TTL 3600
+@	IN	SOA	{{.NS}}. hostmaster.{{.Origin}}. (
+		{{.Serial}}	; serial
+		3600	; refresh
+		600	; retry
+		604800	; expire
+		60 )	; minimum
+	IN	NS	{{.NS}}.
+{{- range .Records }}
+{{.Name}}	IN	{{.Type}}	{{.Data}}
+{{- end }}
 `
 
 var funcMap = template.FuncMap{
+	"arpa":       reverseIPv4,
+	"indent":     indentLines,
+	"join":       strings.Join,
+	"regexQuote": regexp.QuoteMeta,
 	"j2s": func(v interface{}) string {
 		bs, _ := json.Marshal(v)
 		return string(bs)
 	},
 }
 
+// indentLines indents every line of s, it is used to embed yaml.
+func indentLines(n int, s string) string {
+	pad := strings.Repeat(" ", n)
+	return pad + strings.Replace(strings.TrimRight(s, "\n"), "\n", "\n"+pad, -1)
+}
+
 func writeTemplateToFile(tmplContent, name, fileName string, data interface{}) error {
+	return executeTemplateToFile(tmplContent, name, fileName, data,
+		os.O_CREATE|os.O_TRUNC|os.O_WRONLY)
+}
+
+func appendTemplateToFile(tmplContent, name, fileName string, data interface{}) error {
+	return executeTemplateToFile(tmplContent, name, fileName, data,
+		os.O_CREATE|os.O_APPEND|os.O_WRONLY)
+}
+
+func renderTemplate(tmplContent, name string, data interface{}) ([]byte, error) {
+	tmpl, err := template.New(name).Funcs(funcMap).Parse(tmplContent)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, data)
+	return buf.Bytes(), err
+}
+
+func executeTemplateToFile(tmplContent, name, fileName string, data interface{}, flag int) error {
 	tmpl, err := template.New(name).Funcs(funcMap).Parse(tmplContent)
 	if err != nil {
 		return err
 	}
 
-	f, err := os.OpenFile(fileName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	f, err := os.OpenFile(fileName, flag, 0644)
 	if err != nil {
 		return err
 	}
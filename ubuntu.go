@@ -0,0 +1,204 @@
+package lazy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	ubuntuDistro    = "ubuntu"
+	ubuntuOutputDir = "ubuntu"
+
+	ubuntuLVMStorage    = "lvm"
+	ubuntuDirectStorage = "direct"
+
+	// late-commands of autoinstall report the node is installed by
+	// ubuntuInstalledPath, then installed nodes boot ubuntuLocalBoot.
+	ubuntuInstalledPath    = "/ubuntu/installed"
+	ubuntuInstalledProfile = "ubuntu-installed"
+	ubuntuLocalBootFile    = "local.ipxe"
+	ubuntuLocalBoot        = "#!ipxe\nsanboot --no-describe --drive 0x80 || exit\n"
+)
+
+type UbuntuConfig struct {
+	Version  string   `ini:"version"`
+	Username string   `ini:"username"`
+	Storage  string   `ini:"storage"`
+	Packages []string `ini:"packages"`
+}
+
+type ubuntuNic struct {
+	NodeInterface
+	Prefix int
+}
+
+// ubuntuNode is data of autoinstall templates, netplan is rendered first
+// because it is written as file and embedded into user-data.
+type ubuntuNode struct {
+	*Node
+	U       *UbuntuConfig
+	Nics    []ubuntuNic
+	Netplan string
+	// InstalledURL is called when autoinstall is finished
+	InstalledURL string
+}
+
+func ubuntuProfileID(n *Node) string {
+	return "ubuntu-" + n.ID
+}
+
+// ubuntuSeedURL is the nocloud-net datasource of node, lazykube serve
+// provides output path at /generated/.
+func (c *Config) ubuntuSeedURL(n *Node) string {
+	return c.M.URL + "/generated/" + ubuntuOutputDir + "/" + n.ID + "/"
+}
+
+// ubuntuProfile boots live server of Ubuntu, subiquity installs it with
+// the autoinstall seed of node.
+func (c *Config) ubuntuProfile(n *Node) osProfile {
+	base := "/assets/ubuntu/" + c.U.Version
+	return osProfile{
+		ID:     ubuntuProfileID(n),
+		Name:   "Ubuntu " + c.U.Version + " Install " + n.ID,
+		Kernel: base + "/vmlinuz",
+		Initrd: []string{base + "/initrd"},
+		Args: []string{
+			"ip=dhcp",
+			"url=" + c.M.URL + base + "/ubuntu-" + c.U.Version + "-live-server-amd64.iso",
+			"autoinstall",
+			"ds=nocloud-net;s=" + c.ubuntuSeedURL(n),
+			"cloud-config-url=/dev/null",
+			"console=tty0",
+			"console=ttyS0",
+		},
+	}
+}
+
+// ubuntuInstalledURL identifies node by hexhyp mac as ipxe requests.
+func (c *Config) ubuntuInstalledURL(n *Node) string {
+	return c.M.URL + ubuntuInstalledPath + "?mac=" + strings.ToLower(strings.Replace(n.MAC[0], ":", "-", -1))
+}
+
+// ubuntuLocalBootProfile chains ipxe script which boots local disk, or
+// exits to the next boot device of firmware.
+func ubuntuLocalBootProfile() osProfile {
+	return osProfile{
+		ID:     ubuntuInstalledProfile,
+		Name:   "Ubuntu Boot From Disk",
+		Kernel: "/generated/" + ubuntuOutputDir + "/" + ubuntuLocalBootFile,
+		Initrd: []string{},
+	}
+}
+
+func (c *Config) newUbuntuNode(n *Node) (*ubuntuNode, error) {
+	un := &ubuntuNode{
+		Node:         n,
+		U:            c.U,
+		Nics:         make([]ubuntuNic, 0, len(n.Nics)),
+		InstalledURL: c.ubuntuInstalledURL(n),
+	}
+	for _, nic := range n.Nics {
+		un.Nics = append(un.Nics, ubuntuNic{nic, c.Cls.PrefixOf(nic.IP)})
+	}
+
+	bs, err := renderTemplate(NETPLAN_TMPL, "netplan", un)
+	if err != nil {
+		return nil, err
+	}
+	un.Netplan = string(bs)
+	return un, nil
+}
+
+// generateUbuntu writes autoinstall seed and profile of ubuntu nodes,
+// seed of node is under ubuntu/<id> of output path. Group <id>-installed
+// selects the profile which boots local disk once node is installed.
+func (c *Config) generateUbuntu(outputPath string) error {
+	localBoot := false
+	for _, n := range c.Nodes {
+		if n.Distro != ubuntuDistro {
+			continue
+		}
+
+		un, err := c.newUbuntuNode(n)
+		if err != nil {
+			return err
+		}
+
+		dir := filepath.Join(outputPath, ubuntuOutputDir, n.ID)
+		if err = os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+
+		err = writeTemplateToFile(UBUNTU_USER_DATA_TMPL, "user-data",
+			filepath.Join(dir, "user-data"), un)
+		if err == nil {
+			err = writeTemplateToFile(UBUNTU_META_DATA_TMPL, "meta-data",
+				filepath.Join(dir, "meta-data"), un)
+		}
+		if err == nil {
+			err = ioutil.WriteFile(filepath.Join(dir, "netplan.yaml"), []byte(un.Netplan), 0644)
+		}
+		if err != nil {
+			return err
+		}
+
+		profileDir := filepath.Join(outputPath, profileOutputDir)
+		if err = os.MkdirAll(profileDir, 0755); err != nil {
+			return err
+		}
+
+		p := c.ubuntuProfile(n)
+		err = writeTemplateToFile(PROFILE_TMPL, "profile",
+			filepath.Join(profileDir, p.ID+".json"), p)
+		if err == nil {
+			err = writeTemplateToFile(UBUNTU_INSTALLED_TMPL, "installed",
+				filepath.Join(outputPath, n.ID+"-installed.json"), n)
+		}
+		if err != nil {
+			return err
+		}
+		localBoot = true
+	}
+
+	if !localBoot {
+		return nil
+	}
+
+	err := ioutil.WriteFile(filepath.Join(outputPath, ubuntuOutputDir, ubuntuLocalBootFile), []byte(ubuntuLocalBoot), 0644)
+	if err != nil {
+		return err
+	}
+	p := ubuntuLocalBootProfile()
+	return writeTemplateToFile(PROFILE_TMPL, "profile",
+		filepath.Join(outputPath, profileOutputDir, p.ID+".json"), p)
+}
+
+// ubuntuInstalled is called by late-commands of autoinstall, stage of
+// node is moved to installed by trackNodeStates.
+func (c *Config) ubuntuInstalled(w http.ResponseWriter, r *http.Request) {
+	if n := c.nodeOfMAC(r.URL.Query().Get("mac")); n == nil || n.Distro != ubuntuDistro {
+		http.NotFound(w, r)
+		return
+	}
+	w.Write([]byte("ok"))
+}
+
+// ubuntuBootInstalled adds os=installed to ipxe requests of installed
+// ubuntu nodes, so matchbox selects their installed group instead of
+// installing them again. Reset state of node to reinstall it.
+func (c *Config) ubuntuBootInstalled(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if n := c.nodeOfMAC(q.Get("mac")); r.URL.Path == "/ipxe" && n != nil && n.Distro == ubuntuDistro {
+			states, err := c.NodeStates()
+			if err == nil && stageIndex(states[n.ID].Stage) >= stageIndex(stageInstalled) {
+				q.Set("os", "installed")
+				r.URL.RawQuery = q.Encode()
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
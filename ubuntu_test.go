@@ -0,0 +1,219 @@
+package lazy
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testUbuntuINIConfig = `
+domain_base=example.com
+nodes=ctl1,lb1
+
+[matchbox]
+ip=172.17.0.2
+url=http://matchbox.com:8080
+domain=matchbox.com
+
+[network]
+gateway=172.17.0.1
+ips=172.17.0.0/24:172.17.0.21-172.17.0.40,192.168.100.0/25:192.168.100.50
+dhcp_keep=5
+
+[dns]
+dns=8.8.8.8
+inject_hosts=true
+
+[ubuntu]
+packages=haproxy
+
+[ctl1]
+mac=52:54:00:a1:9c:ae
+role=master
+
+[lb1]
+mac=52:54:00:f9:a0:3e,52:54:00:f9:a0:3f
+ip=172.17.0.30
+role=lb
+distro=ubuntu
+`
+
+func TestGenerateUbuntu(t *testing.T) {
+	c := loadTestConfig(t, testUbuntuINIConfig)
+	lb := c.Nodes[1]
+	if lb.Profile != "ubuntu-lb1" {
+		t.Fatalf("Profile of ubuntu node should be ubuntu-lb1, got %s", lb.Profile)
+	}
+
+	dir := t.TempDir()
+	if err := c.generateUbuntu(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	bs, err := ioutil.ReadFile(filepath.Join(dir, "ubuntu", "lb1", "user-data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(bs), "#cloud-config\n") {
+		t.Fatal("User data should start with #cloud-config")
+	}
+
+	v, err := parseYAML(string(bs))
+	if err != nil {
+		t.Fatalf("User data is not yaml: %v", err)
+	}
+
+	ai := clcNode{v: v, err: &err}.get("autoinstall")
+	if *ai.get("version").integer() != 1 || ai.get("storage").get("layout").get("name").str() != "lvm" {
+		t.Fatalf("Autoinstall is not correct: %v", ai.v)
+	}
+
+	eths := ai.get("network").get("network").get("ethernets")
+	if addr := eths.get("eth0").get("addresses").strs(); len(addr) != 1 || addr[0] != "172.17.0.30/24" {
+		t.Fatalf("Address of eth0 is not correct: %v", addr)
+	}
+	if addr := eths.get("eth1").get("addresses").strs(); len(addr) != 1 || addr[0] != "192.168.100.50/25" {
+		t.Fatalf("Address of eth1 is not correct: %v", addr)
+	}
+	if via := eths.get("eth0").get("routes").list()[0].get("via").str(); via != "172.17.0.1" {
+		t.Fatalf("Default route of eth0 should be 172.17.0.1, got %s", via)
+	}
+
+	ud := ai.get("user-data")
+	if ud.get("fqdn").str() != "lb1.example.com" {
+		t.Fatalf("FQDN of lb1 is not correct: %v", ud.get("fqdn").v)
+	}
+	hosts := ud.get("write_files").list()[0].get("content").str()
+	if !strings.Contains(hosts, "172.17.0.30 lb1.example.com\n") {
+		t.Fatalf("Hosts of lb1 is not correct: %q", hosts)
+	}
+
+	late := ai.get("late-commands").strs()
+	if len(late) != 1 || late[0] != `curl -fsS "http://matchbox.com:8080/ubuntu/installed?mac=52-54-00-f9-a0-3e"` {
+		t.Fatalf("Late commands should report installed, got %v", late)
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = ioutil.ReadFile(filepath.Join(dir, "ubuntu", "lb1", "meta-data")); err != nil {
+		t.Fatal(err)
+	}
+
+	p := c.ubuntuProfile(lb)
+	if args := strings.Join(p.Args, " "); !strings.Contains(args, "ds=nocloud-net;s=http://matchbox.com:8080/generated/ubuntu/lb1/") {
+		t.Fatalf("Args of ubuntu profile are not correct: %s", args)
+	}
+
+	group := struct {
+		Profile  string            `json:"profile"`
+		Selector map[string]string `json:"selector"`
+	}{}
+	bs, err = ioutil.ReadFile(filepath.Join(dir, "lb1-installed.json"))
+	if err == nil {
+		err = json.Unmarshal(bs, &group)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if group.Profile != ubuntuInstalledProfile || group.Selector["mac"] != "52:54:00:f9:a0:3e" || group.Selector["os"] != "installed" {
+		t.Fatalf("Installed group of lb1 is not correct: %+v", group)
+	}
+
+	profile := struct {
+		Boot struct {
+			Kernel string `json:"kernel"`
+		} `json:"boot"`
+	}{}
+	bs, err = ioutil.ReadFile(filepath.Join(dir, "profiles", ubuntuInstalledProfile+".json"))
+	if err == nil {
+		err = json.Unmarshal(bs, &profile)
+	}
+	if err != nil || profile.Boot.Kernel != "/generated/ubuntu/local.ipxe" {
+		t.Fatalf("Installed profile should chain local boot script, got %+v, %v", profile, err)
+	}
+	if bs, err = ioutil.ReadFile(filepath.Join(dir, "ubuntu", "local.ipxe")); err != nil || !strings.Contains(string(bs), "sanboot") {
+		t.Fatalf("Local boot script is not correct: %q, %v", bs, err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "ctl1-installed.json")); !os.IsNotExist(err) {
+		t.Fatal("Only ubuntu nodes have installed group")
+	}
+}
+
+func TestUbuntuInstalled(t *testing.T) {
+	var query url.Values
+	matchbox := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		w.Write([]byte("ok"))
+	}))
+	defer matchbox.Close()
+
+	c := loadTestConfig(t, testUbuntuINIConfig)
+	c.S.Upstream = matchbox.URL
+	c.S.State = filepath.Join(t.TempDir(), "nodes.json")
+	h, err := c.httpHandler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := func(path string) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec.Code
+	}
+
+	request("/ipxe?mac=52-54-00-f9-a0-3e")
+	if query.Get("os") != "" {
+		t.Fatal("Ubuntu node should not select installed group before it is installed")
+	}
+
+	if code := request("/ubuntu/installed?mac=52-54-00-a1-9c-ae"); code != http.StatusNotFound {
+		t.Fatal("Only ubuntu nodes report installed, got", code)
+	}
+	if code := request("/ubuntu/installed?mac=52-54-00-f9-a0-3e"); code != http.StatusOK {
+		t.Fatal("Installed callback of lb1 failed:", code)
+	}
+
+	states, err := c.NodeStates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if states["lb1"].Stage != stageInstalled || states["ctl1"].Stage != stageDeclared {
+		t.Fatalf("Only lb1 should be installed: %+v, %+v", states["lb1"], states["ctl1"])
+	}
+
+	request("/ipxe?mac=52-54-00-f9-a0-3e")
+	if query.Get("os") != "installed" || query.Get("mac") != "52-54-00-f9-a0-3e" {
+		t.Fatalf("Installed ubuntu node should select installed group, got %v", query)
+	}
+	request("/ipxe?mac=52-54-00-a1-9c-ae")
+	if query.Get("os") != "" {
+		t.Fatal("Other nodes should not select installed group")
+	}
+
+	if err = c.ResetNodeStates([]string{"lb1"}); err != nil {
+		t.Fatal(err)
+	}
+	request("/ipxe?mac=52-54-00-f9-a0-3e")
+	if query.Get("os") != "" {
+		t.Fatal("Reset ubuntu node should be installed again")
+	}
+}
+
+func TestUbuntuDistroOfMaster(t *testing.T) {
+	content := strings.Replace(testUbuntuINIConfig, "role=master", "role=master\ndistro=ubuntu", 1)
+	file := filepath.Join(t.TempDir(), "lazy.ini")
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(file); err == nil {
+		t.Fatal("Master should not be installed with ubuntu")
+	}
+}
@@ -0,0 +1,362 @@
+package lazy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// verifyDialContext dials apiservers and etcd of cluster
+var verifyDialContext = (&net.Dialer{Timeout: 5 * time.Second}).DialContext
+
+// kubeconfig is cluster and user of current context.
+type kubeconfig struct {
+	Server string
+	CA     []byte
+	Cert   []byte
+	Key    []byte
+}
+
+// kubeconfigEntry is named cluster, user or context of kubeconfig.
+type kubeconfigEntry struct {
+	Name    string                 `yaml:"name"`
+	Cluster map[string]interface{} `yaml:"cluster"`
+	User    map[string]interface{} `yaml:"user"`
+	Context struct {
+		Cluster string `yaml:"cluster"`
+		User    string `yaml:"user"`
+	} `yaml:"context"`
+}
+
+type kubeconfigFile struct {
+	CurrentContext string            `yaml:"current-context"`
+	Clusters       []kubeconfigEntry `yaml:"clusters"`
+	Users          []kubeconfigEntry `yaml:"users"`
+	Contexts       []kubeconfigEntry `yaml:"contexts"`
+}
+
+// namedEntry returns entry whose name is name, such as cluster of
+// clusters.
+func namedEntry(entries []kubeconfigEntry, name string) kubeconfigEntry {
+	for _, e := range entries {
+		if e.Name == name {
+			return e
+		}
+	}
+	return kubeconfigEntry{}
+}
+
+// kubeconfigBytes returns inline data of key, or content of file which is
+// relative to kubeconfig.
+func kubeconfigBytes(m map[string]interface{}, key, dir string) ([]byte, error) {
+	if data, ok := m[key+"-data"].(string); ok {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	file, ok := m[key].(string)
+	if !ok {
+		return nil, errors.New(key + " is not found")
+	}
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(dir, file)
+	}
+	return ioutil.ReadFile(file)
+}
+
+func loadKubeconfig(file string) (*kubeconfig, error) {
+	bs, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var kf kubeconfigFile
+	if err = yaml.Unmarshal(bs, &kf); err != nil {
+		return nil, errors.New("Kubeconfig " + file + " is not correct: " + err.Error())
+	}
+
+	ctx := namedEntry(kf.Contexts, kf.CurrentContext).Context
+	cluster := namedEntry(kf.Clusters, ctx.Cluster).Cluster
+	user := namedEntry(kf.Users, ctx.User).User
+	if cluster == nil || user == nil {
+		return nil, errors.New("Kubeconfig " + file + " has no cluster or user of current context")
+	}
+
+	kc := &kubeconfig{}
+	kc.Server, _ = cluster["server"].(string)
+	dir := filepath.Dir(file)
+	if kc.CA, err = kubeconfigBytes(cluster, "certificate-authority", dir); err == nil {
+		if kc.Cert, err = kubeconfigBytes(user, "client-certificate", dir); err == nil {
+			kc.Key, err = kubeconfigBytes(user, "client-key", dir)
+		}
+	}
+	if err != nil {
+		return nil, errors.New("Kubeconfig " + file + " is not correct: " + err.Error())
+	}
+	return kc, nil
+}
+
+func (kc *kubeconfig) client() (*http.Client, error) {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(kc.CA) {
+		return nil, errors.New("CA of kubeconfig is not correct")
+	}
+	cert, err := tls.X509KeyPair(kc.Cert, kc.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	tr := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return verifyDialContext(ctx, network, addr)
+		},
+		TLSClientConfig: &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{cert}},
+	}
+	return &http.Client{Transport: tr, Timeout: 10 * time.Second}, nil
+}
+
+func getJSON(client *http.Client, url string, v interface{}) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("GET " + url + " failed: " + resp.Status)
+	}
+	if v == nil {
+		bs, err := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		if err == nil && strings.TrimSpace(string(bs)) != "ok" {
+			err = errors.New("GET " + url + " is not ok: " + string(bs))
+		}
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+type verifyResult struct {
+	Check string
+	Err   error
+}
+
+// verifier checks cluster by admin credentials, apiserver is the first
+// healthy one of controller endpoint and masters.
+type verifier struct {
+	c         *Config
+	client    *http.Client
+	apiserver string
+}
+
+func (v *verifier) apiservers() []string {
+	servers := []string{v.c.Cls.ControllerEndpoint}
+	for _, m := range v.c.Cls.Masters {
+		if s := "https://" + m; s != servers[0] {
+			servers = append(servers, s)
+		}
+	}
+	return servers
+}
+
+func (v *verifier) checkAPIServers() []verifyResult {
+	results := make([]verifyResult, 0, len(v.c.Cls.Masters)+1)
+	for _, s := range v.apiservers() {
+		err := getJSON(v.client, s+"/healthz", nil)
+		if err == nil && len(v.apiserver) == 0 {
+			v.apiserver = s
+		}
+		results = append(results, verifyResult{"apiserver " + s, err})
+	}
+	return results
+}
+
+// checkEtcd compares members of etcd with initial cluster of config.
+func (v *verifier) checkEtcd() verifyResult {
+	r := verifyResult{Check: "etcd members"}
+	members := struct {
+		Members []struct {
+			Name     string   `json:"name"`
+			PeerURLs []string `json:"peerURLs"`
+		} `json:"members"`
+	}{}
+
+	r.Err = errors.New("No etcd endpoint")
+	for _, e := range strings.Split(v.c.Cls.Endpoints, ",") {
+		if r.Err = getJSON(v.client, e+"/v2/members", &members); r.Err == nil {
+			break
+		}
+	}
+	if r.Err != nil {
+		return r
+	}
+
+	actual := make([]string, 0, len(members.Members))
+	for _, m := range members.Members {
+		for _, u := range m.PeerURLs {
+			actual = append(actual, m.Name+"="+u)
+		}
+	}
+	expected := strings.Split(v.c.Cls.InitialCluster, ",")
+	sort.Strings(actual)
+	sort.Strings(expected)
+	if strings.Join(actual, ",") != strings.Join(expected, ",") {
+		r.Err = errors.New("Members " + strings.Join(actual, ",") + " are not initial cluster")
+	}
+	return r
+}
+
+// checkNodes returns whether every node is registered and ready.
+func (v *verifier) checkNodes() []verifyResult {
+	nodes := struct {
+		Items []struct {
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+			Status struct {
+				Conditions []struct {
+					Type   string `json:"type"`
+					Status string `json:"status"`
+				} `json:"conditions"`
+			} `json:"status"`
+		} `json:"items"`
+	}{}
+
+	err := errors.New("No healthy apiserver")
+	if len(v.apiserver) != 0 {
+		err = getJSON(v.client, v.apiserver+"/api/v1/nodes", &nodes)
+	}
+
+	ready := make(map[string]bool)
+	for _, item := range nodes.Items {
+		ready[item.Metadata.Name] = false
+		for _, cond := range item.Status.Conditions {
+			if cond.Type == "Ready" && cond.Status == "True" {
+				ready[item.Metadata.Name] = true
+			}
+		}
+	}
+
+	results := make([]verifyResult, 0, len(v.c.Nodes))
+	for _, n := range v.c.Nodes {
+		r := verifyResult{Check: "node " + n.Domain, Err: err}
+		if err == nil {
+			if isReady, ok := ready[n.Domain]; !ok {
+				r.Err = errors.New("Node is not registered")
+			} else if !isReady {
+				r.Err = errors.New("Node is not ready")
+			}
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+func (v *verifier) checkDNS() verifyResult {
+	r := verifyResult{Check: "kube-dns"}
+	if len(v.apiserver) == 0 {
+		r.Err = errors.New("No healthy apiserver")
+		return r
+	}
+
+	endpoints := struct {
+		Subsets []struct {
+			Addresses []struct {
+				IP string `json:"ip"`
+			} `json:"addresses"`
+		} `json:"subsets"`
+	}{}
+	r.Err = getJSON(v.client, v.apiserver+"/api/v1/namespaces/kube-system/endpoints/kube-dns", &endpoints)
+	if r.Err != nil {
+		return r
+	}
+
+	for _, s := range endpoints.Subsets {
+		if len(s.Addresses) != 0 {
+			return r
+		}
+	}
+	r.Err = errors.New("Kube-dns has no ready endpoint")
+	return r
+}
+
+// verifyCluster runs every check, nodes which pass are moved to ready.
+func (c *Config) verifyCluster(kc *kubeconfig) ([]verifyResult, error) {
+	client, err := kc.client()
+	if err != nil {
+		return nil, err
+	}
+
+	v := &verifier{c: c, client: client}
+	results := v.checkAPIServers()
+	results = append(results, v.checkEtcd())
+	nodeResults := v.checkNodes()
+	results = append(results, nodeResults...)
+	results = append(results, v.checkDNS())
+
+	for i, r := range nodeResults {
+		if r.Err != nil {
+			continue
+		}
+		if err = c.UpdateNodeStage(c.Nodes[i].ID, stageReady); err != nil {
+			log.Println("Update state of node", c.Nodes[i].ID, "failed:", err)
+		}
+	}
+	return results, nil
+}
+
+func writeVerifyReport(w io.Writer, results []verifyResult) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHECK\tRESULT\tDETAIL")
+	for _, r := range results {
+		if r.Err != nil {
+			fmt.Fprintf(tw, "%s\tFAIL\t%s\n", r.Check, r.Err)
+		} else {
+			fmt.Fprintf(tw, "%s\tPASS\t-\n", r.Check)
+		}
+	}
+	return tw.Flush()
+}
+
+func failedChecks(results []verifyResult) int {
+	failed := 0
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+		}
+	}
+	return failed
+}
+
+// Verify checks health of apiservers, etcd members, node registration
+// and kube-dns by admin kubeconfig of pki dir, report is written into w.
+func (c *Config) Verify(w io.Writer) error {
+	kc, err := loadKubeconfig(filepath.Join(c.K.PKI, adminKubeconfig))
+	if err != nil {
+		return err
+	}
+
+	results, err := c.verifyCluster(kc)
+	if err != nil {
+		return err
+	}
+	if err = writeVerifyReport(w, results); err != nil {
+		return err
+	}
+
+	if failed := failedChecks(results); failed != 0 {
+		return fmt.Errorf("Verify failed: %d of %d checks failed", failed, len(results))
+	}
+	return nil
+}
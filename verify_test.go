@@ -0,0 +1,90 @@
+package lazy
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	c := loadTestConfig(t, testINIConfig)
+	api, master, etcd := startFakeCluster(t, c)
+
+	buf := &bytes.Buffer{}
+	err := c.Verify(buf)
+	if err == nil || err.Error() != "Verify failed: 4 of 7 checks failed" {
+		t.Fatal("Verify should fail 4 checks, got", err)
+	}
+	for _, line := range []string{
+		"apiserver https://vip.cluster.com   PASS",
+		"apiserver https://ctl1.example.com  FAIL    GET https://ctl1.example.com/healthz failed: 500",
+		"etcd members                        PASS",
+		"node ctl1.example.com               PASS",
+		"node work1.example.com              FAIL    Node is not ready",
+		"node work2.example.com              FAIL    Node is not registered",
+		"kube-dns                            FAIL    Kube-dns has no ready endpoint",
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Fatalf("Report should contain %q, got\n%s", line, buf.String())
+		}
+	}
+
+	states, err := c.NodeStates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if states["ctl1"].Stage != stageReady || states["work1"].Stage != stageDeclared {
+		t.Fatal("Only ready nodes should be moved to ready")
+	}
+
+	master.healthy = true
+	api.ready["work1.example.com"] = true
+	api.ready["work2.example.com"] = true
+	api.dns = []string{"10.2.1.5"}
+	if err = c.Verify(&bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+
+	etcd.members = `{"members":[{"name":"ctl9","peerURLs":["http://ctl9.example.com:2380"]}]}`
+	buf.Reset()
+	if err = c.Verify(buf); err == nil || !strings.Contains(buf.String(), "are not initial cluster") {
+		t.Fatal("Unexpected etcd members should fail, got\n", buf.String())
+	}
+}
+
+func TestLoadKubeconfig(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "ca.pem"), "ca")
+	writeTestFile(t, filepath.Join(dir, "kubeconfig"), `apiVersion: v1
+kind: Config
+clusters:
+- name: other
+  cluster:
+    server: https://other
+- name: k8s
+  cluster:
+    certificate-authority: ca.pem
+    server: https://k8s.example.com
+users:
+- name: user
+  user:
+    client-certificate-data: Y2VydA==
+    client-key-data: a2V5
+contexts:
+- name: k8s
+  context:
+    cluster: k8s
+    user: user
+current-context: k8s
+preferences: {}
+`)
+
+	kc, err := loadKubeconfig(filepath.Join(dir, "kubeconfig"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kc.Server != "https://k8s.example.com" || string(kc.CA) != "ca" || string(kc.Cert) != "cert" || string(kc.Key) != "key" {
+		t.Fatalf("Kubeconfig is not correct: %+v", kc)
+	}
+}
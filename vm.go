@@ -0,0 +1,323 @@
+package lazy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"text/tabwriter"
+)
+
+const (
+	libvirtVMDriver = "libvirt"
+	qemuVMDriver    = "qemu"
+
+	vmCreate    = "create"
+	vmStart     = "start"
+	vmReboot    = "reboot"
+	vmShutdown  = "shutdown"
+	vmPoweroff  = "poweroff"
+	vmDestroy   = "destroy"
+	vmVolDelete = "vol-delete"
+)
+
+type vmState int
+
+const (
+	vmNotFound vmState = iota
+	vmStopped
+	vmRunning
+)
+
+func (s vmState) String() string {
+	switch s {
+	case vmStopped:
+		return "stopped"
+	case vmRunning:
+		return "running"
+	}
+	return "not found"
+}
+
+// vmBackend manages vm of node, every action should be idempotent.
+type vmBackend interface {
+	status(n *Node) (vmState, error)
+	create(n *Node) error
+	start(n *Node) error
+	reboot(n *Node) error
+	shutdown(n *Node) error
+	poweroff(n *Node) error
+	destroy(n *Node) error
+	deleteVolume(n *Node) error
+}
+
+func newVMBackend(c *Config) (vmBackend, error) {
+	switch c.VM.Driver {
+	case libvirtVMDriver:
+		return &libvirtBackend{c: c, conn: &rpcConn{URI: c.VM.URI}}, nil
+	case qemuVMDriver:
+		return &qemuBackend{c: c, run: execQEMURunner{}}, nil
+	}
+	return nil, errors.New("Unknown vm driver: " + c.VM.Driver)
+}
+
+// selectNodes returns nodes whose id or role is one of targets, all nodes
+// are returned without targets.
+func (c *Config) selectNodes(targets []string) ([]*Node, error) {
+	if len(targets) == 0 {
+		return c.Nodes, nil
+	}
+
+	nodes := make([]*Node, 0, len(c.Nodes))
+	matched := make(map[string]bool)
+	for _, n := range c.Nodes {
+		for _, t := range targets {
+			if n.ID == t || n.Role == t {
+				matched[t] = true
+				nodes = append(nodes, n)
+				break
+			}
+		}
+	}
+
+	for _, t := range targets {
+		if !matched[t] {
+			return nil, errors.New("No node id or role is " + t)
+		}
+	}
+	return nodes, nil
+}
+
+// RunVM runs action of vm backend on nodes selected by targets.
+func (c *Config) RunVM(action string, targets []string) error {
+	b, err := newVMBackend(c)
+	if err != nil {
+		return err
+	}
+	return c.runVM(b, action, targets)
+}
+
+// WriteVMStatus writes state of vm of nodes selected by targets.
+func (c *Config) WriteVMStatus(w io.Writer, targets []string) error {
+	b, err := newVMBackend(c)
+	if err != nil {
+		return err
+	}
+	return c.writeVMStatus(w, b, targets)
+}
+
+func (c *Config) writeVMStatus(w io.Writer, b vmBackend, targets []string) error {
+	nodes, err := c.selectNodes(targets)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NODE\tROLE\tSTATE")
+	for _, n := range nodes {
+		state, err := b.status(n)
+		if err != nil {
+			return errors.New("Get state of vm " + n.ID + " failed: " + err.Error())
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", n.ID, n.Role, state)
+	}
+	return tw.Flush()
+}
+
+func (c *Config) runVM(b vmBackend, action string, targets []string) error {
+	var f func(n *Node) error
+	switch action {
+	case vmCreate:
+		f = b.create
+	case vmStart:
+		f = b.start
+	case vmReboot:
+		f = b.reboot
+	case vmShutdown:
+		f = b.shutdown
+	case vmPoweroff:
+		f = b.poweroff
+	case vmDestroy:
+		f = b.destroy
+	case vmVolDelete:
+		f = b.deleteVolume
+	default:
+		return errors.New("Unknown vm action: " + action)
+	}
+
+	nodes, err := c.selectNodes(targets)
+	if err != nil {
+		return err
+	}
+
+	for _, n := range nodes {
+		if err = f(n); err != nil {
+			return errors.New(action + " vm " + n.ID + " failed: " + err.Error())
+		}
+		log.Println(action, "vm", n.ID, "done")
+	}
+	return nil
+}
+
+// libvirtConn is the part of libvirt api which is used by libvirt
+// backend, names are names of domain, network and volume.
+type libvirtConn interface {
+	domainState(name string) (vmState, error)
+	defineDomain(xml []byte) error
+	startDomain(name string) error
+	rebootDomain(name string) error
+	shutdownDomain(name string) error
+	destroyDomain(name string) error
+	undefineDomain(name string) error
+
+	networkState(name string) (vmState, error)
+	defineNetwork(xml []byte) error
+	startNetwork(name string) error
+
+	volumeExists(pool, name string) (bool, error)
+	createVolume(pool string, xml []byte) error
+	deleteVolume(pool, name string) error
+}
+
+// libvirtBackend defines libvirt objects by rendered xml of node.
+type libvirtBackend struct {
+	c    *Config
+	conn libvirtConn
+}
+
+func (b *libvirtBackend) status(n *Node) (vmState, error) {
+	return b.conn.domainState(n.ID)
+}
+
+func (b *libvirtBackend) create(n *Node) error {
+	networks := b.c.libvirtNetworks()
+	for i := range n.MAC {
+		if i >= len(networks) {
+			break
+		}
+
+		state, err := b.conn.networkState(networks[i].Name)
+		if err != nil {
+			return err
+		}
+		if state == vmNotFound {
+			bs, err := marshalLibvirtXML(networks[i])
+			if err == nil {
+				err = b.conn.defineNetwork(bs)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if state != vmRunning {
+			if err = b.conn.startNetwork(networks[i].Name); err != nil {
+				return err
+			}
+		}
+	}
+
+	exist, err := b.conn.volumeExists(b.c.VM.Pool, libvirtVolumeName(n))
+	if err != nil {
+		return err
+	}
+	if !exist {
+		bs, err := marshalLibvirtXML(b.c.libvirtVolume(n))
+		if err == nil {
+			err = b.conn.createVolume(b.c.VM.Pool, bs)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	state, err := b.conn.domainState(n.ID)
+	if err != nil {
+		return err
+	}
+	if state == vmNotFound {
+		bs, err := marshalLibvirtXML(b.c.libvirtDomain(n))
+		if err == nil {
+			err = b.conn.defineDomain(bs)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if state != vmRunning {
+		return b.conn.startDomain(n.ID)
+	}
+	return nil
+}
+
+// existingDomain returns state of domain, it fails when domain is not
+// created.
+func (b *libvirtBackend) existingDomain(n *Node) (vmState, error) {
+	state, err := b.conn.domainState(n.ID)
+	if err == nil && state == vmNotFound {
+		err = errors.New("Domain " + n.ID + " is not created")
+	}
+	return state, err
+}
+
+func (b *libvirtBackend) start(n *Node) error {
+	state, err := b.existingDomain(n)
+	if err != nil || state == vmRunning {
+		return err
+	}
+	return b.conn.startDomain(n.ID)
+}
+
+// reboot starts stopped domain, so node boots again in any state.
+func (b *libvirtBackend) reboot(n *Node) error {
+	state, err := b.existingDomain(n)
+	if err != nil {
+		return err
+	}
+	if state == vmRunning {
+		return b.conn.rebootDomain(n.ID)
+	}
+	return b.conn.startDomain(n.ID)
+}
+
+func (b *libvirtBackend) shutdown(n *Node) error {
+	state, err := b.conn.domainState(n.ID)
+	if err != nil || state != vmRunning {
+		return err
+	}
+	return b.conn.shutdownDomain(n.ID)
+}
+
+func (b *libvirtBackend) poweroff(n *Node) error {
+	state, err := b.conn.domainState(n.ID)
+	if err != nil || state != vmRunning {
+		return err
+	}
+	return b.conn.destroyDomain(n.ID)
+}
+
+// destroy removes domain and its volume, networks are kept for other
+// nodes.
+func (b *libvirtBackend) destroy(n *Node) error {
+	if err := b.poweroff(n); err != nil {
+		return err
+	}
+
+	state, err := b.conn.domainState(n.ID)
+	if err != nil {
+		return err
+	}
+	if state != vmNotFound {
+		if err = b.conn.undefineDomain(n.ID); err != nil {
+			return err
+		}
+	}
+	return b.deleteVolume(n)
+}
+
+func (b *libvirtBackend) deleteVolume(n *Node) error {
+	exist, err := b.conn.volumeExists(b.c.VM.Pool, libvirtVolumeName(n))
+	if err != nil || !exist {
+		return err
+	}
+	return b.conn.deleteVolume(b.c.VM.Pool, libvirtVolumeName(n))
+}
@@ -0,0 +1,346 @@
+package lazy
+
+import (
+	"encoding/xml"
+	"errors"
+	"github.com/digitalocean/go-libvirt"
+	"strings"
+	"testing"
+)
+
+// fakeHypervisor keeps libvirt objects in memory and records calls.
+type fakeHypervisor struct {
+	domains  map[string]vmState
+	networks map[string]vmState
+	volumes  map[string]bool
+	calls    []string
+}
+
+func newFakeHypervisor() *fakeHypervisor {
+	return &fakeHypervisor{
+		domains:  make(map[string]vmState),
+		networks: make(map[string]vmState),
+		volumes:  make(map[string]bool),
+	}
+}
+
+func (h *fakeHypervisor) call(name string) {
+	h.calls = append(h.calls, name)
+}
+
+func (h *fakeHypervisor) domainState(name string) (vmState, error) {
+	return h.domains[name], nil
+}
+
+func (h *fakeHypervisor) defineDomain(bs []byte) error {
+	d := libvirtDomain{}
+	if err := xml.Unmarshal(bs, &d); err != nil {
+		return err
+	}
+	if _, ok := h.domains[d.Name]; ok {
+		return errors.New("Domain " + d.Name + " exists")
+	}
+	h.call("define " + d.Name)
+	h.domains[d.Name] = vmStopped
+	return nil
+}
+
+func (h *fakeHypervisor) setDomain(name string, from, to vmState, call string) error {
+	if h.domains[name] != from {
+		return errors.New("Domain " + name + " is " + h.domains[name].String())
+	}
+	h.call(call + " " + name)
+	h.domains[name] = to
+	return nil
+}
+
+func (h *fakeHypervisor) startDomain(name string) error {
+	return h.setDomain(name, vmStopped, vmRunning, "start")
+}
+
+func (h *fakeHypervisor) rebootDomain(name string) error {
+	return h.setDomain(name, vmRunning, vmRunning, "reboot")
+}
+
+func (h *fakeHypervisor) shutdownDomain(name string) error {
+	return h.setDomain(name, vmRunning, vmStopped, "shutdown")
+}
+
+func (h *fakeHypervisor) destroyDomain(name string) error {
+	return h.setDomain(name, vmRunning, vmStopped, "destroy")
+}
+
+func (h *fakeHypervisor) undefineDomain(name string) error {
+	if err := h.setDomain(name, vmStopped, vmNotFound, "undefine"); err != nil {
+		return err
+	}
+	delete(h.domains, name)
+	return nil
+}
+
+func (h *fakeHypervisor) networkState(name string) (vmState, error) {
+	return h.networks[name], nil
+}
+
+func (h *fakeHypervisor) defineNetwork(bs []byte) error {
+	n := libvirtNetwork{}
+	if err := xml.Unmarshal(bs, &n); err != nil {
+		return err
+	}
+	h.call("net-define " + n.Name)
+	h.networks[n.Name] = vmStopped
+	return nil
+}
+
+func (h *fakeHypervisor) startNetwork(name string) error {
+	if h.networks[name] != vmStopped {
+		return errors.New("Network " + name + " can not start")
+	}
+	h.call("net-start " + name)
+	h.networks[name] = vmRunning
+	return nil
+}
+
+func (h *fakeHypervisor) volumeExists(pool, name string) (bool, error) {
+	return h.volumes[pool+"/"+name], nil
+}
+
+func (h *fakeHypervisor) createVolume(pool string, bs []byte) error {
+	v := libvirtVolume{}
+	if err := xml.Unmarshal(bs, &v); err != nil {
+		return err
+	}
+	h.call("vol-create " + pool + "/" + v.Name)
+	h.volumes[pool+"/"+v.Name] = true
+	return nil
+}
+
+func (h *fakeHypervisor) deleteVolume(pool, name string) error {
+	if !h.volumes[pool+"/"+name] {
+		return errors.New("Volume " + name + " is not found")
+	}
+	h.call("vol-delete " + pool + "/" + name)
+	delete(h.volumes, pool+"/"+name)
+	return nil
+}
+
+func TestRunVM(t *testing.T) {
+	c := loadTestConfig(t, testINIConfig)
+	h := newFakeHypervisor()
+	b := &libvirtBackend{c: c, conn: h}
+
+	run := func(action string, targets []string, expected ...string) {
+		h.calls = nil
+		if err := c.runVM(b, action, targets); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(h.calls, ",") != strings.Join(expected, ",") {
+			t.Fatalf("Calls of %s %v should be %v, got %v", action, targets, expected, h.calls)
+		}
+	}
+
+	run(vmCreate, []string{"master"},
+		"net-define lazykube-pool0", "net-start lazykube-pool0",
+		"net-define lazykube-pool1", "net-start lazykube-pool1",
+		"vol-create default/ctl1.qcow2", "define ctl1", "start ctl1")
+	run(vmCreate, []string{"ctl1", "work1"},
+		"vol-create default/work1.qcow2", "define work1", "start work1")
+
+	h.domains["work1"] = vmStopped
+	run(vmCreate, nil, "start work1", "vol-create default/work2.qcow2", "define work2", "start work2")
+	run(vmCreate, nil)
+
+	run(vmShutdown, []string{"minion"}, "shutdown work1", "shutdown work2")
+	run(vmShutdown, []string{"minion"})
+	run(vmStart, []string{"work1"}, "start work1")
+	run(vmReboot, nil, "reboot ctl1", "reboot work1", "start work2")
+	run(vmPoweroff, []string{"ctl1"}, "destroy ctl1")
+	run(vmPoweroff, []string{"ctl1"})
+
+	run(vmDestroy, []string{"ctl1", "work1"},
+		"undefine ctl1", "vol-delete default/ctl1.qcow2",
+		"destroy work1", "undefine work1", "vol-delete default/work1.qcow2")
+	run(vmDestroy, []string{"ctl1"})
+	run(vmVolDelete, []string{"work1"})
+
+	if err := c.runVM(b, vmStart, []string{"ctl1"}); err == nil {
+		t.Fatal("Destroyed node should not be started")
+	}
+	if err := c.runVM(b, vmStart, []string{"ctl9"}); err == nil {
+		t.Fatal("Unknown node should not be selected")
+	}
+	if err := c.runVM(b, "suspend", nil); err == nil {
+		t.Fatal("Unknown action should fail")
+	}
+}
+
+// fakeLibvirtRPC answers go-libvirt calls by states of objects, missing
+// objects fail with error codes of libvirt.
+type fakeLibvirtRPC struct {
+	domains  map[string]libvirt.DomainState
+	networks map[string]int32
+	volumes  map[string]bool
+	err      error
+	calls    []string
+}
+
+func (f *fakeLibvirtRPC) DomainLookupByName(name string) (libvirt.Domain, error) {
+	if f.err != nil {
+		return libvirt.Domain{}, f.err
+	}
+	if _, ok := f.domains[name]; !ok {
+		return libvirt.Domain{}, libvirt.Error{Code: uint32(libvirt.ErrNoDomain), Message: "Domain not found"}
+	}
+	return libvirt.Domain{Name: name}, nil
+}
+
+func (f *fakeLibvirtRPC) DomainGetState(dom libvirt.Domain, flags uint32) (int32, int32, error) {
+	return int32(f.domains[dom.Name]), 0, nil
+}
+
+func (f *fakeLibvirtRPC) DomainDefineXML(xml string) (libvirt.Domain, error) {
+	f.calls = append(f.calls, "define")
+	return libvirt.Domain{}, nil
+}
+
+func (f *fakeLibvirtRPC) DomainCreate(dom libvirt.Domain) error {
+	f.calls = append(f.calls, "start "+dom.Name)
+	return nil
+}
+
+func (f *fakeLibvirtRPC) DomainReboot(dom libvirt.Domain, flags libvirt.DomainRebootFlagValues) error {
+	f.calls = append(f.calls, "reboot "+dom.Name)
+	return nil
+}
+
+func (f *fakeLibvirtRPC) DomainShutdown(dom libvirt.Domain) error {
+	f.calls = append(f.calls, "shutdown "+dom.Name)
+	return nil
+}
+
+func (f *fakeLibvirtRPC) DomainDestroy(dom libvirt.Domain) error {
+	f.calls = append(f.calls, "destroy "+dom.Name)
+	return nil
+}
+
+func (f *fakeLibvirtRPC) DomainUndefineFlags(dom libvirt.Domain, flags libvirt.DomainUndefineFlagsValues) error {
+	if flags&libvirt.DomainUndefineNvram == 0 {
+		return errors.New("Nvram should be removed")
+	}
+	f.calls = append(f.calls, "undefine "+dom.Name)
+	return nil
+}
+
+func (f *fakeLibvirtRPC) NetworkLookupByName(name string) (libvirt.Network, error) {
+	if _, ok := f.networks[name]; !ok {
+		return libvirt.Network{}, libvirt.Error{Code: uint32(libvirt.ErrNoNetwork), Message: "Network not found"}
+	}
+	return libvirt.Network{Name: name}, nil
+}
+
+func (f *fakeLibvirtRPC) NetworkIsActive(net libvirt.Network) (int32, error) {
+	return f.networks[net.Name], nil
+}
+
+func (f *fakeLibvirtRPC) NetworkDefineXML(xml string) (libvirt.Network, error) {
+	f.calls = append(f.calls, "net-define")
+	return libvirt.Network{}, nil
+}
+
+func (f *fakeLibvirtRPC) NetworkCreate(net libvirt.Network) error {
+	f.calls = append(f.calls, "net-start "+net.Name)
+	return nil
+}
+
+func (f *fakeLibvirtRPC) NetworkSetAutostart(net libvirt.Network, autostart int32) error {
+	f.calls = append(f.calls, "net-autostart "+net.Name)
+	return nil
+}
+
+func (f *fakeLibvirtRPC) StoragePoolLookupByName(name string) (libvirt.StoragePool, error) {
+	if name != "default" {
+		return libvirt.StoragePool{}, libvirt.Error{Code: uint32(libvirt.ErrNoStoragePool), Message: "Pool not found"}
+	}
+	return libvirt.StoragePool{Name: name}, nil
+}
+
+func (f *fakeLibvirtRPC) StoragePoolRefresh(pool libvirt.StoragePool, flags uint32) error {
+	f.calls = append(f.calls, "pool-refresh "+pool.Name)
+	return nil
+}
+
+func (f *fakeLibvirtRPC) StorageVolLookupByName(pool libvirt.StoragePool, name string) (libvirt.StorageVol, error) {
+	if !f.volumes[name] {
+		return libvirt.StorageVol{}, libvirt.Error{Code: uint32(libvirt.ErrNoStorageVol), Message: "Volume not found"}
+	}
+	return libvirt.StorageVol{Pool: pool.Name, Name: name}, nil
+}
+
+func (f *fakeLibvirtRPC) StorageVolCreateXML(pool libvirt.StoragePool, xml string, flags libvirt.StorageVolCreateFlags) (libvirt.StorageVol, error) {
+	f.calls = append(f.calls, "vol-create "+pool.Name)
+	return libvirt.StorageVol{}, nil
+}
+
+func (f *fakeLibvirtRPC) StorageVolDelete(vol libvirt.StorageVol, flags libvirt.StorageVolDeleteFlags) error {
+	f.calls = append(f.calls, "vol-delete "+vol.Name)
+	return nil
+}
+
+func TestRPCConn(t *testing.T) {
+	f := &fakeLibvirtRPC{
+		domains: map[string]libvirt.DomainState{
+			"ctl1": libvirt.DomainRunning, "ctl2": libvirt.DomainPaused, "work1": libvirt.DomainShutoff,
+		},
+		networks: map[string]int32{"lazykube-pool0": 0, "lazykube-pool1": 1},
+		volumes:  map[string]bool{"work1.qcow2": true},
+	}
+	var uri string
+	defer func(d func(string) (libvirtRPC, error)) { libvirtDial = d }(libvirtDial)
+	libvirtDial = func(u string) (libvirtRPC, error) {
+		uri = u
+		return f, nil
+	}
+
+	r := &rpcConn{URI: "qemu:///system"}
+	for name, expected := range map[string]vmState{"ctl1": vmRunning, "ctl2": vmRunning, "work1": vmStopped, "work2": vmNotFound} {
+		if s, err := r.domainState(name); err != nil || s != expected {
+			t.Fatalf("State of domain %s should be %v, got %v %v", name, expected, s, err)
+		}
+	}
+	if uri != "qemu:///system" {
+		t.Fatal("Libvirt should be connected by uri, got", uri)
+	}
+	for name, expected := range map[string]vmState{"lazykube-pool0": vmStopped, "lazykube-pool1": vmRunning, "lazykube-pool2": vmNotFound} {
+		if s, err := r.networkState(name); err != nil || s != expected {
+			t.Fatalf("State of network %s should be %v, got %v %v", name, expected, s, err)
+		}
+	}
+	for name, expected := range map[string]bool{"work1.qcow2": true, "work2.qcow2": false} {
+		if ok, err := r.volumeExists("default", name); err != nil || ok != expected {
+			t.Fatalf("Existence of volume %s should be %v, got %v %v", name, expected, ok, err)
+		}
+	}
+	if _, err := r.volumeExists("images", "work1.qcow2"); err == nil {
+		t.Fatal("Missing pool should fail")
+	}
+
+	f.calls = nil
+	if err := r.startNetwork("lazykube-pool0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.undefineDomain("work1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.deleteVolume("default", "work1.qcow2"); err != nil {
+		t.Fatal(err)
+	}
+	expected := "net-start lazykube-pool0,net-autostart lazykube-pool0,undefine work1,vol-delete work1.qcow2"
+	if calls := strings.Join(f.calls, ","); calls != expected {
+		t.Fatalf("Calls should be %s, got %s", expected, calls)
+	}
+
+	f.err = errors.New("connection reset")
+	if _, err := r.domainState("ctl1"); err == nil {
+		t.Fatal("Failed libvirt should not be reported as missing domain")
+	}
+}
@@ -0,0 +1,121 @@
+package lazy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// waitInterval is interval of polling node states
+var waitInterval = 5 * time.Second
+
+// stuckNodes returns nodes which do not reach stage yet with their stage
+// and the last error.
+func (c *Config) stuckNodes(nodes []*Node, stage string) ([]string, error) {
+	states, err := c.NodeStates()
+	if err != nil {
+		return nil, err
+	}
+
+	stuck := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		s := states[n.ID]
+		if stageIndex(s.Stage) >= stageIndex(stage) {
+			continue
+		}
+
+		msg := n.ID + " (" + s.Stage
+		if len(s.Error) != 0 {
+			msg += ": " + s.Error
+		}
+		stuck = append(stuck, msg+")")
+	}
+	return stuck, nil
+}
+
+// waitHealth verifies cluster by admin kubeconfig, checks of nodes which
+// are not waited are dropped.
+func (c *Config) waitHealth(nodes []*Node) ([]verifyResult, error) {
+	kc, err := loadKubeconfig(filepath.Join(c.K.PKI, adminKubeconfig))
+	if err != nil {
+		return nil, err
+	}
+	results, err := c.verifyCluster(kc)
+	if err != nil {
+		return nil, err
+	}
+
+	waited := make(map[string]bool)
+	for _, n := range nodes {
+		waited["node "+n.Domain] = true
+	}
+	checks := make([]verifyResult, 0, len(results))
+	for _, r := range results {
+		if strings.HasPrefix(r.Check, "node ") && !waited[r.Check] {
+			continue
+		}
+		checks = append(checks, r)
+	}
+	return checks, nil
+}
+
+// WaitNodes polls node states until nodes selected by targets reach
+// stage, it fails with stuck nodes when timeout expires.
+func (c *Config) WaitNodes(stage string, timeout time.Duration, targets []string) error {
+	return c.Wait(ioutil.Discard, stage, timeout, targets)
+}
+
+// Wait polls node states until nodes selected by targets reach stage,
+// cluster health is also checked when waiting for ready. When timeout
+// expires, status of nodes and failed checks are written into w, and it
+// fails with stuck nodes.
+func (c *Config) Wait(w io.Writer, stage string, timeout time.Duration, targets []string) error {
+	if stageIndex(stage) < 0 {
+		return errors.New("Unknown node stage: " + stage)
+	}
+	nodes, err := c.selectNodes(targets)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		var results []verifyResult
+		if stage == stageReady {
+			if results, err = c.waitHealth(nodes); err != nil {
+				return err
+			}
+		}
+		stuck, err := c.stuckNodes(nodes, stage)
+		if err != nil {
+			return err
+		}
+		failed := failedChecks(results)
+		if len(stuck) == 0 && failed == 0 {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			if err = c.WriteNodeStatus(w, targets); err != nil {
+				return err
+			}
+			if failed != 0 {
+				fmt.Fprintln(w)
+				if err = writeVerifyReport(w, results); err != nil {
+					return err
+				}
+			}
+			if len(stuck) == 0 {
+				return fmt.Errorf("Cluster is not %s after %v: %d of %d checks failed", stage, timeout, failed, len(results))
+			}
+			return fmt.Errorf("Nodes are not %s after %v: %s", stage, timeout, strings.Join(stuck, ", "))
+		}
+		log.Println("Wait for", len(stuck), "nodes and", failed, "checks to be", stage)
+		time.Sleep(waitInterval)
+	}
+}
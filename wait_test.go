@@ -0,0 +1,89 @@
+package lazy
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWaitNodes(t *testing.T) {
+	c := loadTestConfig(t, testINIConfig)
+	c.S.State = filepath.Join(t.TempDir(), "nodes.json")
+
+	defer func(d time.Duration) { waitInterval = d }(waitInterval)
+	waitInterval = time.Millisecond
+
+	for id, stage := range map[string]string{"ctl1": stageReady, "work1": stageInstalled, "work2": stageInstalling} {
+		if err := c.UpdateNodeStage(id, stage); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.UpdateNodeError("work2", "GET /ignition failed: Not Found"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.WaitNodes(stageInstalled, time.Second, []string{"ctl1", "work1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := c.WaitNodes(stageInstalled, 10*time.Millisecond, nil)
+	if err == nil || !strings.Contains(err.Error(), "work2 (installing: GET /ignition failed: Not Found)") ||
+		strings.Contains(err.Error(), "work1") {
+		t.Fatal("Wait should report stuck nodes, got", err)
+	}
+}
+
+func TestWaitReport(t *testing.T) {
+	c := loadTestConfig(t, testINIConfig)
+	c.S.State = filepath.Join(t.TempDir(), "nodes.json")
+	if err := c.UpdateNodeStage("work1", stageBooting); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	err := c.Wait(buf, stageInstalled, 0, []string{"minion"})
+	if err == nil || err.Error() != "Nodes are not installed after 0s: work1 (booting), work2 (declared)" {
+		t.Fatal("Wait should report stuck nodes, got", err)
+	}
+	if !strings.Contains(buf.String(), "work1  minion  booting") || strings.Contains(buf.String(), "ctl1") {
+		t.Fatal("Status of waited nodes should be reported, got\n", buf.String())
+	}
+}
+
+func TestWaitReady(t *testing.T) {
+	c := loadTestConfig(t, testINIConfig)
+	api, master, _ := startFakeCluster(t, c)
+	master.healthy = true
+	api.dns = []string{"10.2.1.5"}
+
+	defer func(d time.Duration) { waitInterval = d }(waitInterval)
+	waitInterval = time.Millisecond
+
+	// work1 is not ready, but only ctl1 is waited
+	if err := c.Wait(&bytes.Buffer{}, stageReady, time.Second, []string{"ctl1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	err := c.Wait(buf, stageReady, 10*time.Millisecond, []string{"work1"})
+	if err == nil || err.Error() != "Nodes are not ready after 10ms: work1 (declared)" {
+		t.Fatal("Wait should report node which is not ready, got", err)
+	}
+	if !strings.Contains(buf.String(), "node work1.example.com              FAIL    Node is not ready") {
+		t.Fatal("Failed checks should be reported, got\n", buf.String())
+	}
+
+	api.ready["work1.example.com"] = true
+	api.dns = nil
+	err = c.Wait(&bytes.Buffer{}, stageReady, 10*time.Millisecond, []string{"work1"})
+	if err == nil || err.Error() != "Cluster is not ready after 10ms: 1 of 5 checks failed" {
+		t.Fatal("Wait should report failed checks, got", err)
+	}
+
+	api.dns = []string{"10.2.1.5"}
+	if err = c.Wait(&bytes.Buffer{}, stageReady, time.Second, []string{"work1"}); err != nil {
+		t.Fatal(err)
+	}
+}